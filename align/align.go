@@ -1,6 +1,7 @@
 package align
 
 import (
+	"context"
 	"sort"
     "regexp"
     // "fmt"
@@ -40,7 +41,7 @@ type ResultParams struct {
     AnyChecked       string
 }
 
-func Search(text string, source string) *ResultParams {
+func Search(ctx context.Context, text string, source string) *ResultParams {
     // sapiResults := sapi.Search( params )
 
     var textForSearch string
@@ -62,7 +63,11 @@ func Search(text string, source string) *ResultParams {
 
     // fmt.Println("align.Search: sRequest=", sRequest) 
 
-    sapiResults := content.Search( sRequest )
+    // if the search fails, as logged by Search, there is nothing to align
+    sapiResults, err := content.Search( ctx, sRequest )
+    if err != nil {
+        sapiResults = &content.SearchResponse{ Articles: &[]*content.Article{} }
+    }
 
     // fmt.Println("align.Search: sapiResults=", sapiResults) 
     // fmt.Println("align.Search: sapiResults.Articles=", sapiResults.Articles) 
@@ -0,0 +1,231 @@
+// Package api defines the versioned JSON interface to the meter and haiku detector, for other teams' tools.
+// The types here are the contract: fields may be added to them within a version, but not renamed or removed.
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/rhyme"
+	"github.com/railsagainstignorance/alignment/scoring"
+)
+
+const Version = "v1"
+
+const (
+	MaxTexts      = 100
+	MaxTextLength = 100000 // characters per text
+)
+
+// Forms names the commonly used meters, as an alternative to spelling out a meter.
+var Forms = map[string]string{
+	"haiku":               rhyme.HaikuMeter,
+	"iambic-tetrameter":   "01010101",
+	"iambic-pentameter":   "0101010101",
+	"trochaic-tetrameter": "10101010",
+}
+
+// DetectRequest is one text, or a batch of them, to be scanned against a meter (e.g. "0101010101$")
+// or a named form (e.g. "haiku"). If both are given, the form wins; if neither, rhyme.DefaultMeter is used.
+type DetectRequest struct {
+	Text  string   `json:"text,omitempty"`
+	Texts []string `json:"texts,omitempty"`
+	Meter string   `json:"meter,omitempty"`
+	Form  string   `json:"form,omitempty"`
+}
+
+type DetectResponse struct {
+	Version string        `json:"version"`
+	Meter   string        `json:"meter"`
+	Form    string        `json:"form,omitempty"`
+	Results []*TextResult `json:"results"`
+}
+
+// TextResult holds the matches found in one of the request's texts, in the order the texts were given.
+type TextResult struct {
+	Text         string   `json:"text"`
+	NumSentences int      `json:"numSentences"`
+	Matches      []*Match `json:"matches"`
+	UnknownWords []string `json:"unknownWords"` // words missing from the dictionary, whose syllables are guessed at
+}
+
+// Match is one sentence's match on the meter. Lines is only set for meters with several lines, e.g. haiku.
+type Match struct {
+	Sentence       string      `json:"sentence"`
+	Before         string      `json:"before"`
+	During         string      `json:"during"`
+	After          string      `json:"after"`
+	Lines          []string    `json:"lines,omitempty"`
+	FinalWord      string      `json:"finalWord"`
+	FinalSyllable  string      `json:"finalSyllable"`
+	EndsOnWeakWord bool        `json:"endsOnWeakWord"`
+	Score          float64     `json:"score"`
+	Syllables      []*Syllable `json:"syllables"`
+}
+
+// Syllable is where one syllable of the sentence landed against the meter (see rhyme.SyllableAlignment).
+// MeterSlot is -1 for syllables outside the match.
+type Syllable struct {
+	WordIndex     int    `json:"wordIndex"`
+	Word          string `json:"word"`
+	SyllableIndex int    `json:"syllableIndex"`
+	Phoneme       string `json:"phoneme"`
+	Stress        string `json:"stress"`
+	MeterSlot     int    `json:"meterSlot"`
+	MeterSymbol   string `json:"meterSymbol,omitempty"`
+	Matched       bool   `json:"matched"`
+	Forced        bool   `json:"forced"`
+}
+
+type ErrorResponse struct {
+	Version string `json:"version"`
+	Error   string `json:"error"`
+}
+
+var (
+	ErrNoText       = errors.New("no text given")
+	ErrTooManyTexts = errors.New("too many texts given")
+	ErrTextTooLong  = errors.New("text too long")
+	ErrUnknownForm  = errors.New("unknown form")
+)
+
+// texts combines the request's Text and Texts, in that order.
+func (req *DetectRequest) texts() []string {
+	texts := []string{}
+	if req.Text != "" {
+		texts = append(texts, req.Text)
+	}
+	return append(texts, req.Texts...)
+}
+
+func (req *DetectRequest) meter() (string, error) {
+	if req.Form != "" {
+		meter, ok := Forms[strings.ToLower(req.Form)]
+		if !ok {
+			return "", ErrUnknownForm
+		}
+		return meter, nil
+	}
+	if req.Meter != "" {
+		return req.Meter, nil
+	}
+	return rhyme.DefaultMeter, nil
+}
+
+// Validate checks the request is within the limits, and names a known form.
+func (req *DetectRequest) Validate() error {
+	texts := req.texts()
+	if len(texts) == 0 {
+		return ErrNoText
+	}
+	if len(texts) > MaxTexts {
+		return ErrTooManyTexts
+	}
+	for _, text := range texts {
+		if len(text) > MaxTextLength {
+			return ErrTextTooLong
+		}
+	}
+	_, err := req.meter()
+	return err
+}
+
+func Detect(ctx context.Context, req *DetectRequest, syllabi *rhyme.Syllabi) (*DetectResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	meter, _ := req.meter()
+
+	results := []*TextResult{}
+	for _, text := range req.texts() {
+		results = append(results, DetectInText(ctx, text, meter, syllabi))
+	}
+
+	return &DetectResponse{
+		Version: Version,
+		Meter:   meter,
+		Form:    req.Form,
+		Results: results,
+	}, nil
+}
+
+// DetectInText scans one text, of any length, against the meter.
+func DetectInText(ctx context.Context, text string, meter string, syllabi *rhyme.Syllabi) *TextResult {
+	sentences := article.SplitTextIntoSentences(text)
+	rams := article.FindRhymeAndMetersInSentences(ctx, sentences, meter, syllabi)
+	scorer := scoring.NewScorer(*sentences, syllabi.PhraseWordsRegexp)
+
+	matches := []*Match{}
+	for _, ram := range *rams {
+		matches = append(matches, newMatch(ram, scorer.ScoreRhymeAndMeter(ram)))
+	}
+
+	return &TextResult{
+		Text:         text,
+		NumSentences: len(*sentences),
+		Matches:      matches,
+		UnknownWords: unknownWords(sentences, syllabi),
+	}
+}
+
+func newMatch(ram *rhyme.RhymeAndMeter, score *scoring.Score) *Match {
+	mom := ram.MatchesOnMeter
+
+	match := Match{
+		Sentence:       ram.Phrase,
+		Before:         mom.Before,
+		During:         mom.During,
+		After:          mom.After,
+		FinalWord:      mom.FinalDuringWord,
+		FinalSyllable:  mom.FinalDuringSyllable,
+		EndsOnWeakWord: mom.FinalDuringWordWord == nil || mom.FinalDuringWordWord.IsBadEnd,
+		Syllables:      []*Syllable{},
+	}
+
+	if score != nil {
+		match.Score = score.Total
+	}
+
+	if mom.SecondaryMatch != nil {
+		match.Lines = *mom.SecondaryMatch.PhraseInEachMatch
+		for _, w := range *mom.SecondaryMatch.FinalWordWordInEachMatch {
+			if w == nil || w.IsBadEnd {
+				match.EndsOnWeakWord = true
+			}
+		}
+	}
+
+	if mom.SyllableAlignments != nil {
+		for _, sa := range *mom.SyllableAlignments {
+			match.Syllables = append(match.Syllables, &Syllable{
+				WordIndex:     sa.WordIndex,
+				Word:          sa.Word,
+				SyllableIndex: sa.SyllableIndex,
+				Phoneme:       sa.Phoneme,
+				Stress:        sa.Stress,
+				MeterSlot:     sa.MeterSlot,
+				MeterSymbol:   sa.MeterSymbol,
+				Matched:       sa.Matched,
+				Forced:        sa.Forced,
+			})
+		}
+	}
+
+	return &match
+}
+
+func unknownWords(sentences *[]string, syllabi *rhyme.Syllabi) []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, s := range *sentences {
+		for _, w := range syllabi.FindAllEmphasisPointsDetails(s).MatchingWords {
+			if w.Unknown && !seen[w.Name] {
+				seen[w.Name] = true
+				words = append(words, w.Name)
+			}
+		}
+	}
+	return words
+}
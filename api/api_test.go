@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+var syllabi = rhyme.ConstructSyllabi(&[]string{"../rhyme/cmudict-0.7b", "../rhyme/cmudict-0.7b_my_additions"})
+
+func TestDetect(t *testing.T) {
+	req := DetectRequest{
+		Texts: []string{
+			"When I do count the clock that tells the time. Zxqvbnmwords are hard to say",
+			"Short.",
+		},
+		Form: "iambic-pentameter",
+	}
+
+	resp, err := Detect(context.Background(), &req, syllabi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != Version || resp.Meter != Forms["iambic-pentameter"] {
+		t.Errorf("unexpected version=%s, meter=%s", resp.Version, resp.Meter)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("expected a result per text, got %d", len(resp.Results))
+	}
+
+	first := resp.Results[0]
+	if first.NumSentences != 2 || len(first.Matches) != 1 {
+		t.Fatalf("expected 2 sentences and 1 match, got %d and %d", first.NumSentences, len(first.Matches))
+	}
+	if m := first.Matches[0]; m.FinalWord != "time" || len(m.Syllables) == 0 {
+		t.Errorf("expected a match ending on time with syllables, got %+v", m)
+	}
+	if len(first.UnknownWords) != 1 {
+		t.Errorf("expected one unknown word, got %v", first.UnknownWords)
+	}
+
+	if len(resp.Results[1].Matches) != 0 {
+		t.Errorf("expected no matches in the second text, got %d", len(resp.Results[1].Matches))
+	}
+}
+
+func TestDetectValidation(t *testing.T) {
+	cases := []struct {
+		req DetectRequest
+		err error
+	}{
+		{DetectRequest{}, ErrNoText},
+		{DetectRequest{Text: "some text", Form: "limerick"}, ErrUnknownForm},
+		{DetectRequest{Texts: make([]string, MaxTexts+1)}, ErrTooManyTexts},
+	}
+
+	for _, c := range cases {
+		if _, err := Detect(context.Background(), &c.req, syllabi); err != c.err {
+			t.Errorf("expected %v, got %v", c.err, err)
+		}
+	}
+}
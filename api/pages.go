@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/align"
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/ontology"
+)
+
+// AlignResult is the JSON form of the /align page, i.e. of align.ResultParams:
+// the titles or excerpts containing Text, split around it.
+type AlignResult struct {
+	Version        string           `json:"version"`
+	Text           string           `json:"text"`
+	Source         string           `json:"source"` // "keyword" or "title-only"
+	FtcomUrl       string           `json:"ftcomUrl"`
+	FtcomSearchUrl string           `json:"ftcomSearchUrl"`
+	Phrases        []*AlignedPhrase `json:"phrases"`
+}
+
+type AlignedPhrase struct {
+	Before  string `json:"before"`
+	Common  string `json:"common"`
+	After   string `json:"after"`
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+	Url     string `json:"url"`
+}
+
+func NewAlignResult(p *align.ResultParams) *AlignResult {
+	phrases := []*AlignedPhrase{}
+	for _, pb := range p.Phrases {
+		phrases = append(phrases, &AlignedPhrase{
+			Before:  pb.Before,
+			Common:  pb.Common,
+			After:   pb.After,
+			Title:   pb.Title,
+			Excerpt: pb.Excerpt,
+			Url:     pb.LocationUri,
+		})
+	}
+
+	return &AlignResult{
+		Version:        Version,
+		Text:           p.Text,
+		Source:         p.Source,
+		FtcomUrl:       p.FtcomUrl,
+		FtcomSearchUrl: p.FtcomSearchUrl,
+		Phrases:        phrases,
+	}
+}
+
+// OntologyResult is the JSON form of the /ontology page, i.e. of ontology.Details.
+// Matches are the single-line matches ending on a strong word, grouped by rhyme and best first within each group;
+// Haiku are the multi-line matches (if the meter has several lines) ending every line on a strong word.
+// WeakEndMatches and WeakEndHaiku are the rest.
+type OntologyResult struct {
+	Version        string            `json:"version"`
+	Ontology       string            `json:"ontology"`
+	Value          string            `json:"value"`
+	Meter          string            `json:"meter"`
+	MaxArticles    int               `json:"maxArticles"`
+	MaxMillis      int               `json:"maxMillis"`
+	Articles       []*ArticleSummary `json:"articles"`
+	Matches        []*ArticleMatch   `json:"matches"`
+	WeakEndMatches []*ArticleMatch   `json:"weakEndMatches"`
+	Haiku          []*ArticleMatch   `json:"haiku"`
+	WeakEndHaiku   []*ArticleMatch   `json:"weakEndHaiku"`
+	UnknownWords   []string          `json:"unknownWords"`
+}
+
+// ArticleSummary is one of the articles scanned.
+type ArticleSummary struct {
+	Uuid         string `json:"uuid"`
+	Url          string `json:"url"`
+	Title        string `json:"title"`
+	Author       string `json:"author"`
+	PubDate      string `json:"pubDate"`
+	NumSentences int    `json:"numSentences"`
+	NumMatches   int    `json:"numMatches"`
+}
+
+func newArticleSummary(a *article.ArticleWithSentencesAndMeter) *ArticleSummary {
+	summary := ArticleSummary{
+		Uuid:    a.Uuid,
+		Url:     a.SiteUrl,
+		Title:   a.Title,
+		Author:  a.Author,
+		PubDate: a.PubDateString,
+	}
+	if a.Sentences != nil {
+		summary.NumSentences = len(*a.Sentences)
+	}
+	if a.MatchedPhrases != nil {
+		summary.NumMatches = len(*a.MatchedPhrases)
+	}
+	return &summary
+}
+
+// ArticleProgress is streamed as each article of an ontology query is processed, with that article's matches.
+// These are not yet scored, ranked or folded into duplicates, which only happens once every article is in.
+type ArticleProgress struct {
+	Version  string          `json:"version"`
+	NumDone  int             `json:"numDone"`
+	NumTotal int             `json:"numTotal"`
+	Article  *ArticleSummary `json:"article"`
+	Matches  []*ArticleMatch `json:"matches"`
+}
+
+func NewArticleProgress(numDone int, numTotal int, a *article.ArticleWithSentencesAndMeter) *ArticleProgress {
+	return &ArticleProgress{
+		Version:  Version,
+		NumDone:  numDone,
+		NumTotal: numTotal,
+		Article:  newArticleSummary(a),
+		Matches:  newArticleMatches(article.MatchedPhrasesWithUrlOfArticle(a)),
+	}
+}
+
+// ArticleMatch is a Match found in an article. DuplicateUrls lists the later articles with the same (or nearly the same) match.
+type ArticleMatch struct {
+	*Match
+	Url              string   `json:"url"`
+	PubDate          string   `json:"pubDate,omitempty"`
+	ScoreExplanation string   `json:"scoreExplanation,omitempty"`
+	DuplicateUrls    []string `json:"duplicateUrls,omitempty"`
+}
+
+func newArticleMatch(mpwu *article.MatchedPhraseWithUrl) *ArticleMatch {
+	am := ArticleMatch{
+		Match:         newMatch(mpwu.RhymeAndMeter, mpwu.Score),
+		DuplicateUrls: []string{},
+	}
+	if mpwu.Url != nil {
+		am.Url = *mpwu.Url
+	}
+	if mpwu.PubDate != nil {
+		am.PubDate = mpwu.PubDate.Format(time.RFC3339)
+	}
+	if mpwu.Score != nil {
+		am.ScoreExplanation = mpwu.Score.Explanation()
+	}
+	for _, d := range mpwu.Duplicates {
+		if d.Url != nil {
+			am.DuplicateUrls = append(am.DuplicateUrls, *d.Url)
+		}
+	}
+	return &am
+}
+
+func newArticleMatches(mpwus *[]*article.MatchedPhraseWithUrl) []*ArticleMatch {
+	ams := []*ArticleMatch{}
+	if mpwus != nil {
+		for _, mpwu := range *mpwus {
+			ams = append(ams, newArticleMatch(mpwu))
+		}
+	}
+	return ams
+}
+
+func newArticleMatchesWithFirst(mpwuwfs *[]*ontology.MatchedPhraseWithUrlWithFirst) []*ArticleMatch {
+	ams := []*ArticleMatch{}
+	if mpwuwfs != nil {
+		for _, mpwuwf := range *mpwuwfs {
+			ams = append(ams, newArticleMatch(mpwuwf.MatchedPhraseWithUrl))
+		}
+	}
+	return ams
+}
+
+func NewOntologyResult(d *ontology.Details) *OntologyResult {
+	articles := []*ArticleSummary{}
+	if d.Articles != nil {
+		for _, a := range *d.Articles {
+			articles = append(articles, newArticleSummary(a))
+		}
+	}
+
+	unknownWords := []string{}
+	if d.KnownUnknowns != nil {
+		unknownWords = *d.KnownUnknowns
+	}
+
+	return &OntologyResult{
+		Version:        Version,
+		Ontology:       d.OntologyName,
+		Value:          d.OntologyValue,
+		Meter:          d.Meter,
+		MaxArticles:    d.MaxArticles,
+		MaxMillis:      d.MaxMillis,
+		Articles:       articles,
+		Matches:        newArticleMatchesWithFirst(d.MatchedPhrasesWithUrl),
+		WeakEndMatches: newArticleMatchesWithFirst(d.BadMatchedPhrasesWithUrl),
+		Haiku:          newArticleMatches(d.SecondaryMatchedPhrasesWithUrl),
+		WeakEndHaiku:   newArticleMatches(d.BadSecondaryMatchedPhrasesWithUrl),
+		UnknownWords:   unknownWords,
+	}
+}
+
+// OntologyCSVHeader names the columns written by WriteOntologyCSV. Kind is one of haiku, match, weak-end-haiku or weak-end-match.
+var OntologyCSVHeader = []string{"kind", "url", "pub_date", "match", "lines", "final_word", "score", "duplicate_urls"}
+
+// WriteOntologyCSV writes every match in the result, one per row, in the order of the JSON lists.
+func WriteOntologyCSV(w io.Writer, result *OntologyResult) error {
+	writer := csv.NewWriter(w)
+	writer.Write(OntologyCSVHeader)
+
+	lists := []struct {
+		kind    string
+		matches []*ArticleMatch
+	}{
+		{"haiku", result.Haiku},
+		{"match", result.Matches},
+		{"weak-end-haiku", result.WeakEndHaiku},
+		{"weak-end-match", result.WeakEndMatches},
+	}
+
+	for _, list := range lists {
+		for _, m := range list.matches {
+			writer.Write([]string{
+				list.kind,
+				m.Url,
+				m.PubDate,
+				m.During,
+				strings.Join(m.Lines, " / "),
+				m.FinalWord,
+				strconv.FormatFloat(m.Score, 'f', 3, 64),
+				strings.Join(m.DuplicateUrls, " "),
+			})
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
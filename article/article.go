@@ -1,20 +1,23 @@
 package article
 
 import (
+	"context"
 	// "sort"
 	//    "regexp"
-	"fmt"
-	"github.com/joho/godotenv"
 	"github.com/kennygrant/sanitize"
 	// "github.com/railsagainstignorance/alignment/capi"
 	// "github.com/railsagainstignorance/alignment/sapi"
 	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/corpus"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/metrics"
 	"github.com/railsagainstignorance/alignment/rhyme"
+	"github.com/railsagainstignorance/alignment/scoring"
 	"strings"
 	"time"
 )
 
-func splitTextIntoSentences(text string) *[]string {
+func SplitTextIntoSentences(text string) *[]string {
 	sentences := strings.FieldsFunc(text, func(r rune) bool {
 		switch r {
 		case '.', ':':
@@ -40,20 +43,66 @@ type ArticleWithSentences struct {
 	Sentences *[]string
 }
 
-func getArticleWithSentences(uuid string) *ArticleWithSentences {
-	latest := false
-	article := content.GetArticle(uuid, latest)
-
+func splitArticleIntoSentences(article *content.Article) *[]string {
 	tidyBody := sanitize.HTML(article.Body)
+	return SplitTextIntoSentences(tidyBody)
+}
+
+// getArticleWithSentences prefers the corpus index, only fetching (and indexing) the article if it is missing,
+// has been republished since (if pubDateString is known), or was indexed with a different dictionary.
+func getArticleWithSentences(ctx context.Context, uuid string, pubDateString string, syllabi *rhyme.Syllabi) (*ArticleWithSentences, *corpus.Entry) {
+	index := corpus.DefaultIndex()
+	entry := index.Get(uuid)
+	logger := logging.FromContext(ctx)
+
+	if index.IsStale(uuid, pubDateString, syllabi) {
+		var article *content.Article
+		if entry != nil && (pubDateString == "" || pubDateString == entry.PubDateString) {
+			logger.Info("article: getArticleWithSentences: reprocessing with new dictionary", "uuid", uuid)
+			article = entry.Article
+		} else {
+			latest := (entry != nil)
+			var err error
+			if article, err = content.GetArticle(ctx, uuid, latest); err != nil {
+				// making do with the copy indexed, if any, or else with no article, not indexed
+				logger.Warn("article: getArticleWithSentences: could not fetch article", "uuid", uuid, "err", err)
+				if entry != nil {
+					return &ArticleWithSentences{entry.Article, entry.SentenceTexts()}, entry
+				}
+				article = &content.Article{PullQuoteAssets: &[]content.PullQuoteAsset{}}
+			}
+		}
 
-	sentences := splitTextIntoSentences(tidyBody)
+		stopTiming := logging.Time(ctx, "parse")
+		defer stopTiming()
+
+		sentences := splitArticleIntoSentences(article)
+		if article.Uuid == "" {
+			logger.Warn("article: getArticleWithSentences: not indexing article with no uuid", "uuid", uuid)
+			aws := ArticleWithSentences{article, sentences}
+			return &aws, nil
+		}
+
+		entry, _ = index.Add(article, *sentences, syllabi)
+	}
 
 	aws := ArticleWithSentences{
-		article,
-		sentences,
+		entry.Article,
+		entry.SentenceTexts(),
 	}
 
-	return &aws
+	return &aws, entry
+}
+
+// IndexArticle makes sure the article is in the corpus index, and up to date, fetching it if need be.
+func IndexArticle(ctx context.Context, uuid string, syllabi *rhyme.Syllabi) *corpus.Entry {
+	_, entry := getArticleWithSentences(ctx, uuid, "", syllabi)
+	return entry
+}
+
+// RebuildCorpusIndex reprocesses every article in the corpus index from its stored body.
+func RebuildCorpusIndex(syllabi *rhyme.Syllabi) int {
+	return corpus.DefaultIndex().Rebuild(syllabi, splitArticleIntoSentences)
 }
 
 type ArticleWithSentencesAndMeter struct {
@@ -63,7 +112,9 @@ type ArticleWithSentencesAndMeter struct {
 	KnownUnknowns  *[]string
 }
 
-func FindRhymeAndMetersInSentences(sentences *[]string, meter string, syllabi *rhyme.Syllabi) *[]*rhyme.RhymeAndMeter {
+func FindRhymeAndMetersInSentences(ctx context.Context, sentences *[]string, meter string, syllabi *rhyme.Syllabi) *[]*rhyme.RhymeAndMeter {
+	defer logging.Time(ctx, "scan")()
+
 	rams := []*rhyme.RhymeAndMeter{}
 
 	if meter == "" {
@@ -73,7 +124,7 @@ func FindRhymeAndMetersInSentences(sentences *[]string, meter string, syllabi *r
 	emphasisRegexp, emphasisRegexpSecondary := rhyme.ConvertToEmphasisPointsStringRegexp(meter)
 
 	for _, s := range *(sentences) {
-		syllabiRams := syllabi.RhymeAndMetersOfPhrase(s, emphasisRegexp, emphasisRegexpSecondary)
+		syllabiRams := syllabi.RhymeAndMetersOfPhrase(ctx, s, emphasisRegexp, emphasisRegexpSecondary)
 
 		for _, ram := range *syllabiRams {
 			if ram.EmphasisRegexpMatch2 != "" {
@@ -85,9 +136,34 @@ func FindRhymeAndMetersInSentences(sentences *[]string, meter string, syllabi *r
 	return &rams
 }
 
-func GetArticleWithSentencesAndMeter(uuid string, meter string, syllabi *rhyme.Syllabi) *ArticleWithSentencesAndMeter {
-	aws := getArticleWithSentences(uuid)
-	rams := FindRhymeAndMetersInSentences(aws.Sentences, meter, syllabi)
+var articleMatches = metrics.NewHistogram("alignment_article_matches", "Phrases matching the meter found per article scanned.", []float64{0, 1, 2, 5, 10, 20, 50, 100})
+
+func GetArticleWithSentencesAndMeter(ctx context.Context, uuid string, meter string, syllabi *rhyme.Syllabi) *ArticleWithSentencesAndMeter {
+	return getArticleWithSentencesAndMeter(ctx, uuid, "", meter, syllabi)
+}
+
+// GetArticleWithSentencesAndMeterPublishedAt is GetArticleWithSentencesAndMeter for an article known (e.g. from a search)
+// to have been published at pubDateString, so an older copy in the corpus index is re-fetched.
+func GetArticleWithSentencesAndMeterPublishedAt(ctx context.Context, uuid string, pubDateString string, meter string, syllabi *rhyme.Syllabi) *ArticleWithSentencesAndMeter {
+	return getArticleWithSentencesAndMeter(ctx, uuid, pubDateString, meter, syllabi)
+}
+
+func getArticleWithSentencesAndMeter(ctx context.Context, uuid string, pubDateString string, meter string, syllabi *rhyme.Syllabi) *ArticleWithSentencesAndMeter {
+	aws, entry := getArticleWithSentences(ctx, uuid, pubDateString, syllabi)
+
+	// only the sentences whose indexed stresses fit the meter need scanning in full
+	sentences := aws.Sentences
+	if entry != nil {
+		indexMeter := meter
+		if indexMeter == "" {
+			indexMeter = rhyme.DefaultMeter
+		}
+		emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp(indexMeter)
+		sentences = entry.MatchingSentences(emphasisRegexp)
+	}
+
+	rams := FindRhymeAndMetersInSentences(ctx, sentences, meter, syllabi)
+	articleMatches.With().Observe(float64(len(*rams)))
 
 	// sort.Sort(rhyme.RhymeAndMeters(*rams))
 
@@ -103,7 +179,10 @@ func GetArticleWithSentencesAndMeter(uuid string, meter string, syllabi *rhyme.S
 
 type MatchedPhraseWithUrl struct {
 	*rhyme.RhymeAndMeter
-	Url *string
+	Url        *string
+	Score      *scoring.Score
+	PubDate    *time.Time
+	Duplicates []*MatchedPhraseWithUrl
 }
 
 type MatchedPhrasesWithUrl []*MatchedPhraseWithUrl
@@ -114,11 +193,38 @@ func (mpwus MatchedPhrasesWithUrl) Less(i, j int) bool {
 	return mpwus[i].MatchesOnMeter.FinalDuringSyllableAZ > mpwus[j].MatchesOnMeter.FinalDuringSyllableAZ
 }
 
+// Text and Date make MatchedPhrasesWithUrl a dedup.Documents, by the whole sentence matched,
+// as the match itself may be only a word or two, shared by unrelated sentences
+func (mpwus MatchedPhrasesWithUrl) Text(i int) string     { return mpwus[i].Phrase }
+func (mpwus MatchedPhrasesWithUrl) Date(i int) *time.Time { return mpwus[i].PubDate }
+
+// MatchedPhrasesWithUrlByScore sorts the best scoring matches first, with unscored matches last.
+type MatchedPhrasesWithUrlByScore []*MatchedPhraseWithUrl
+
+func (mpwus MatchedPhrasesWithUrlByScore) Len() int      { return len(mpwus) }
+func (mpwus MatchedPhrasesWithUrlByScore) Swap(i, j int) { mpwus[i], mpwus[j] = mpwus[j], mpwus[i] }
+func (mpwus MatchedPhrasesWithUrlByScore) Less(i, j int) bool {
+	if mpwus[j].Score == nil {
+		return mpwus[i].Score != nil
+	}
+	return mpwus[i].Score != nil && mpwus[i].Score.Total > mpwus[j].Score.Total
+}
+
 func GetArticlesByAuthorWithSentencesAndMeter(author string, meter string, syllabi *rhyme.Syllabi, maxArticles int, maxMillis int) (*[]*ArticleWithSentencesAndMeter, *[]*MatchedPhraseWithUrl) {
 	return GetArticlesByOntologyWithSentencesAndMeter("authors", author, meter, syllabi, maxArticles, maxMillis)
 }
 
+// ArticleProgress is told about each article as soon as it has been processed,
+// along with how many have been processed so far, out of how many there are to do.
+type ArticleProgress func(numDone int, numTotal int, a *ArticleWithSentencesAndMeter)
+
 func GetArticlesByOntologyWithSentencesAndMeter(ontologyName string, ontologyValue string, meter string, syllabi *rhyme.Syllabi, maxArticles int, maxMillis int) (*[]*ArticleWithSentencesAndMeter, *[]*MatchedPhraseWithUrl) {
+	return GetArticlesByOntologyWithSentencesAndMeterContext(context.Background(), ontologyName, ontologyValue, meter, syllabi, maxArticles, maxMillis, nil)
+}
+
+// GetArticlesByOntologyWithSentencesAndMeterContext stops processing articles (returning those done so far) if ctx is cancelled,
+// e.g. when the client goes away, and reports each article to progress, if not nil, as it goes.
+func GetArticlesByOntologyWithSentencesAndMeterContext(ctx context.Context, ontologyName string, ontologyValue string, meter string, syllabi *rhyme.Syllabi, maxArticles int, maxMillis int, progress ArticleProgress) (*[]*ArticleWithSentencesAndMeter, *[]*MatchedPhraseWithUrl) {
 	start := time.Now()
 	maxDurationNanoseconds := int64(maxMillis * 1e6)
 
@@ -146,16 +252,29 @@ func GetArticlesByOntologyWithSentencesAndMeter(ontologyName string, ontologyVal
 		SearchOnly:        true,
 	}
 
-	sapiResult := content.Search(sRequest)
+	// a failed search, logged by Search, finds nothing
+	sapiResult, _ := content.Search(ctx, sRequest)
 
 	if sapiResult != nil && *(sapiResult.Articles) != nil && len(*(sapiResult.Articles)) > 0 {
+		numTotal := len(*(sapiResult.Articles))
+		if numTotal > maxArticles {
+			numTotal = maxArticles
+		}
+
 		for i, item := range *(sapiResult.Articles) {
 			if i >= maxArticles {
 				break
 			}
+			if ctx.Err() != nil {
+				logging.FromContext(ctx).Info("article: GetArticlesByOntologyWithSentencesAndMeterContext: cancelled", "numArticles", len(articles), "err", ctx.Err())
+				break
+			}
 			if item != nil {
-				aws := GetArticleWithSentencesAndMeter(item.Uuid, meter, syllabi)
+				aws := getArticleWithSentencesAndMeter(ctx, item.Uuid, item.PubDateString, meter, syllabi)
 				articles = append(articles, aws)
+				if progress != nil {
+					progress(i+1, numTotal, aws)
+				}
 			}
 			if time.Since(start).Nanoseconds() > maxDurationNanoseconds {
 				break
@@ -166,33 +285,26 @@ func GetArticlesByOntologyWithSentencesAndMeter(ontologyName string, ontologyVal
 	mpwus := []*MatchedPhraseWithUrl{}
 
 	for _, article := range articles {
-		for _, mp := range *(article.MatchedPhrases) {
-			mpwu := &MatchedPhraseWithUrl{
-				mp,
-				&article.SiteUrl,
-			}
-
-			mpwus = append(mpwus, mpwu)
-		}
+		mpwus = append(mpwus, *MatchedPhrasesWithUrlOfArticle(article)...)
 	}
 
 	return &articles, &mpwus
 }
 
-func main() {
-	godotenv.Load()
-	var syllabi = rhyme.ConstructSyllabi(&[]string{"../rhyme/cmudict-0.7b", "../rhyme/cmudict-0.7b_my_additions"})
-
-	uuid := "b57fee24-cb3c-11e5-be0b-b7ece4e953a0"
-	meter := "1010101010"
-
-	aws := GetArticleWithSentencesAndMeter(uuid, meter, syllabi)
-	fmt.Println("main: article.Title=", aws.Title)
-	fmt.Println("main: body=", aws.Body)
+func MatchedPhrasesWithUrlOfArticle(article *ArticleWithSentencesAndMeter) *[]*MatchedPhraseWithUrl {
+	mpwus := []*MatchedPhraseWithUrl{}
 
-	fmt.Println("main: num Sentences=", len(*(aws.Sentences)), "num MatchedPhrases=", len(*(aws.MatchedPhrases)))
+	for _, mp := range *(article.MatchedPhrases) {
+		mpwu := &MatchedPhraseWithUrl{
+			mp,
+			&article.SiteUrl,
+			nil,
+			article.PubDate,
+			nil,
+		}
 
-	for _, mp := range *(aws.MatchedPhrases) {
-		fmt.Println("main: mp.MatchesOnMeter.During=", mp.MatchesOnMeter.During)
+		mpwus = append(mpwus, mpwu)
 	}
+
+	return &mpwus
 }
@@ -0,0 +1,250 @@
+// Package auth decides who may call which routes. A Provider recognises the credentials of a request, e.g. an API key,
+// as a Principal, who has scopes, e.g. "detect" or "jobs". A Policy tries its providers in order, and wraps each route's
+// handler so it is only called by principals with the route's scope.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/railsagainstignorance/alignment/logging"
+)
+
+// AllScopes is the scope of principals who may call any route, e.g. staff signed in with s3o.
+const AllScopes = "*"
+
+// The names of the providers, as listed in a policy, e.g. "s3o,apikey".
+const (
+	ProviderNone   = "none"
+	ProviderS3o    = "s3o"
+	ProviderApiKey = "apikey"
+	ProviderBasic  = "basic"
+)
+
+var ProviderNames = []string{ProviderNone, ProviderS3o, ProviderApiKey, ProviderBasic}
+
+// ApiKeyHeader carries an API key. Not a query parameter, so keys don't end up in logs.
+const ApiKeyHeader = "X-Api-Key"
+
+type Principal struct {
+	Name     string
+	Provider string
+	Scopes   []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == AllScopes || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type Provider interface {
+	Name() string
+	// Authenticate returns who made the request, nil if it carries none of this provider's credentials
+	// (so the next provider is tried), or an error if it carries wrong ones.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge responds to a request which was not authenticated, because of err or, if nil, for want of credentials,
+	// e.g. with a 401 or a redirect to sign in.
+	Challenge(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type contextKey int
+
+const principalKey contextKey = 0
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext is who made the request, or nil if its route is not behind a policy.
+func FromContext(ctx context.Context) *Principal {
+	if ctx != nil {
+		if p, ok := ctx.Value(principalKey).(*Principal); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// Credential is a name, a secret (an API key or a password) and the scopes they give.
+type Credential struct {
+	Name   string
+	Secret string
+	Scopes []string
+}
+
+// ParseCredentials parses a comma separated list of name:secret:scope+scope, e.g. "partner:s3cret:detect+pullquotes".
+// The scope * gives every scope, and no scopes, none.
+func ParseCredentials(s string) ([]*Credential, error) {
+	credentials := []*Credential{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("auth: ParseCredentials: expected name:secret:scope+scope, got an entry with %d parts", len(parts))
+		}
+		c := Credential{Name: parts[0], Secret: parts[1], Scopes: []string{}}
+		if len(parts) == 3 && parts[2] != "" {
+			c.Scopes = strings.Split(parts[2], "+")
+		}
+		credentials = append(credentials, &c)
+	}
+	return credentials, nil
+}
+
+// find is the credential with the secret, or with the name and secret if name is not "", comparing secrets in constant time.
+func find(credentials []*Credential, name string, secret string) *Credential {
+	var found *Credential
+	for _, c := range credentials {
+		if (name == "" || name == c.Name) && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1 {
+			found = c
+		}
+	}
+	return found
+}
+
+type noneProvider struct{}
+
+// None authenticates every request, as "dev", with every scope. It is for working locally, without s3o.
+func None() Provider {
+	return noneProvider{}
+}
+
+func (noneProvider) Name() string { return ProviderNone }
+
+func (noneProvider) Authenticate(r *http.Request) (*Principal, error) {
+	return &Principal{Name: "dev", Provider: ProviderNone, Scopes: []string{AllScopes}}, nil
+}
+
+func (noneProvider) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+type apiKeyProvider struct {
+	keys []*Credential
+}
+
+// ApiKeys authenticates requests with one of the keys in their X-Api-Key header, e.g. from partner tools.
+func ApiKeys(keys []*Credential) Provider {
+	return &apiKeyProvider{keys}
+}
+
+func (p *apiKeyProvider) Name() string { return ProviderApiKey }
+
+func (p *apiKeyProvider) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(ApiKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+	c := find(p.keys, "", key)
+	if c == nil {
+		return nil, errors.New("unknown API key")
+	}
+	return &Principal{Name: c.Name, Provider: ProviderApiKey, Scopes: c.Scopes}, nil
+}
+
+func (p *apiKeyProvider) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil {
+		err = errors.New("an " + ApiKeyHeader + " header is required")
+	}
+	http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+}
+
+type basicProvider struct {
+	users []*Credential
+	realm string
+}
+
+// Basic authenticates requests with the name and password of one of the users, by HTTP basic auth.
+func Basic(users []*Credential, realm string) Provider {
+	return &basicProvider{users, realm}
+}
+
+func (p *basicProvider) Name() string { return ProviderBasic }
+
+func (p *basicProvider) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	c := find(p.users, name, password)
+	if c == nil {
+		return nil, errors.New("unknown user or wrong password")
+	}
+	return &Principal{Name: c.Name, Provider: ProviderBasic, Scopes: c.Scopes}, nil
+}
+
+func (p *basicProvider) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+p.realm+`"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// Policy lets a request through if one of its providers authenticates it, as a principal with the route's scope.
+type Policy struct {
+	providers []Provider
+}
+
+// NewPolicy is the policy trying the named providers (a comma separated list, e.g. "s3o,apikey") in order.
+// The first challenges the requests which none authenticate, so should suit the route's usual callers.
+func NewPolicy(names string, providers map[string]Provider) (*Policy, error) {
+	p := Policy{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		provider, ok := providers[name]
+		if !ok || provider == nil {
+			return nil, fmt.Errorf("auth: NewPolicy: unknown provider %q, expected one of %s", name, strings.Join(ProviderNames, ", "))
+		}
+		p.providers = append(p.providers, provider)
+	}
+	if len(p.providers) == 0 {
+		return nil, errors.New("auth: NewPolicy: no providers, expected " + ProviderNone + " at least")
+	}
+	return &p, nil
+}
+
+// Require wraps next so it is only called for principals with scope, which it carries in the request's context,
+// and adds to its logger.
+func (p *Policy) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+
+		for _, provider := range p.providers {
+			principal, err := provider.Authenticate(r)
+			if err != nil {
+				logger.Warn("auth: Require: not authenticated", "provider", provider.Name(), "scope", scope, "err", err)
+				provider.Challenge(w, r, err)
+				return
+			}
+			if principal == nil {
+				continue
+			}
+
+			if !principal.HasScope(scope) {
+				logger.Warn("auth: Require: lacks scope", "provider", provider.Name(), "principal", principal.Name, "scope", scope)
+				http.Error(w, "forbidden: "+principal.Name+" lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			ctx := NewContext(r.Context(), principal)
+			ctx = logging.NewContext(ctx, logger.With("principal", principal.Name))
+			next(w, r.WithContext(ctx))
+			return
+		}
+
+		p.providers[0].Challenge(w, r, nil)
+	}
+}
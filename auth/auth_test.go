@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	credentials, err := ParseCredentials("partner:k1:detect+pullquotes, admin:k2:*,nobody:k3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 3 || len(credentials[0].Scopes) != 2 || credentials[1].Scopes[0] != AllScopes || len(credentials[2].Scopes) != 0 {
+		t.Errorf("unexpected credentials %v", credentials)
+	}
+
+	if _, err := ParseCredentials("partner"); err == nil {
+		t.Error("expected an error for an entry with no secret")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	keys, _ := ParseCredentials("partner:k1:detect")
+	users, _ := ParseCredentials("editor:pw:*")
+	providers := map[string]Provider{
+		ProviderApiKey: ApiKeys(keys),
+		ProviderBasic:  Basic(users, "test"),
+	}
+	policy, err := NewPolicy("basic,apikey", providers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen *Principal
+	h := func(scope string) http.HandlerFunc {
+		return policy.Require(scope, func(w http.ResponseWriter, r *http.Request) {
+			seen = FromContext(r.Context())
+		})
+	}
+
+	cases := []struct {
+		scope    string
+		key      string
+		password string
+		status   int
+		name     string
+	}{
+		{"detect", "k1", "", http.StatusOK, "partner"},
+		{"jobs", "k1", "", http.StatusForbidden, ""},
+		{"detect", "wrong", "", http.StatusUnauthorized, ""},
+		{"jobs", "", "pw", http.StatusOK, "editor"},
+		{"jobs", "", "wrong", http.StatusUnauthorized, ""},
+		{"detect", "", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, c := range cases {
+		seen = nil
+		r := httptest.NewRequest("GET", "/api/v1/detect", nil)
+		if c.key != "" {
+			r.Header.Set(ApiKeyHeader, c.key)
+		}
+		if c.password != "" {
+			r.SetBasicAuth("editor", c.password)
+		}
+		w := httptest.NewRecorder()
+		h(c.scope)(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%+v: expected status %d, got %d", c, c.status, w.Code)
+		}
+		if (seen == nil && c.name != "") || (seen != nil && seen.Name != c.name) {
+			t.Errorf("%+v: expected principal %q, got %v", c, c.name, seen)
+		}
+	}
+
+	// with no credentials, the first provider challenges
+	w := httptest.NewRecorder()
+	h("detect")(w, httptest.NewRequest("GET", "/api/v1/detect", nil))
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected a basic auth challenge")
+	}
+
+	if _, err := NewPolicy("apikey,carrier-pigeon", providers); err == nil {
+		t.Error("expected an unknown provider to be an error")
+	}
+}
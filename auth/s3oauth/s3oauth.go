@@ -0,0 +1,71 @@
+// Package s3oauth is the auth Provider for FT staff, signed in with s3o (Staff Single Sign On).
+// It is apart from package auth because importing s3o starts fetching its public key, which the tools have no use for.
+package s3oauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/ft-s3o-go/s3o"
+	"github.com/railsagainstignorance/alignment/auth"
+)
+
+type provider struct{}
+
+// New authenticates requests carrying the username and token s3o posts back after signing in, with every scope.
+func New() auth.Provider {
+	return provider{}
+}
+
+func (provider) Name() string { return auth.ProviderS3o }
+
+// discardWriter keeps what s3o.Handler responds with when it rejects a token, as the error.
+type discardWriter struct {
+	header http.Header
+	body   []byte
+}
+
+func (dw *discardWriter) Header() http.Header    { return dw.header }
+func (dw *discardWriter) WriteHeader(status int) {}
+func (dw *discardWriter) Write(b []byte) (int, error) {
+	dw.body = append(dw.body, b...)
+	return len(b), nil
+}
+
+func (provider) Authenticate(r *http.Request) (principal *auth.Principal, err error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	username := r.Form.Get("username")
+	if username == "" || r.Form.Get("token") == "" {
+		return nil, nil
+	}
+
+	// s3o.Handler verifies the token, calling on only if it is good, but dereferences a nil key if it has none yet
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			principal, err = nil, errors.New("public s3o key unavailable")
+		}
+	}()
+
+	verified := false
+	dw := discardWriter{header: http.Header{}}
+	s3o.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified = true
+	})).ServeHTTP(&dw, r)
+
+	if !verified {
+		return nil, errors.New(strings.TrimSpace(string(dw.body)))
+	}
+	return &auth.Principal{Name: username, Provider: auth.ProviderS3o, Scopes: []string{auth.AllScopes}}, nil
+}
+
+// Challenge redirects to sign in with s3o, unless a token was rejected, which signing in again won't fix.
+func (provider) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		http.Error(w, "forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	s3o.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+}
@@ -0,0 +1,254 @@
+// Package card renders pull quotes and haiku as shareable PNG cards: the article's image, cropped to fill the card,
+// under a panel in the colour of its quieter end, with the text, its attribution and the FT's branding.
+// Each card has a stable path, by its article's uuid and a fingerprint of its text, for the feeds to link to.
+package card
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/railsagainstignorance/alignment/dedup"
+	ftimage "github.com/railsagainstignorance/alignment/image"
+	"github.com/railsagainstignorance/alignment/logging"
+)
+
+// The size of a card, that shown by the social networks.
+const (
+	Width  = 1200
+	Height = 630
+)
+
+// ContentType is that of a rendered card.
+const ContentType = "image/png"
+
+const (
+	KindPullQuote = "pullquote"
+	KindHaiku     = "haiku"
+)
+
+const (
+	margin       = 60
+	maxTextLines = 6
+	brandSize    = 84
+)
+
+var textScales = []int{6, 5, 4, 3} // the largest which fits the text in maxTextLines is used
+
+// The FT's colours, for the branding and for cards without an image.
+var (
+	ftPaper = color.RGBA{0xff, 0xf1, 0xe5, 0xff}
+	ftBlack = color.RGBA{0x33, 0x30, 0x2e, 0xff}
+)
+
+type Card struct {
+	Text        string
+	Attribution string
+	Image       image.Image // nil for a plain card
+	Background  color.Color // of the panel behind the text
+	Foreground  color.Color // of the text
+	PanelAtTop  bool        // over an image, if its top is more uniform than its foot, so the panel hides less of it
+}
+
+// Path is where the card of the kind for the text, from the article with the uuid, is served.
+func Path(kind string, uuid string, text string) string {
+	return "/cards/" + kind + "/" + uuid + "/" + dedup.Fingerprint(text) + ".png"
+}
+
+var pathRegexp = regexp.MustCompile(`^/cards/(` + KindPullQuote + `|` + KindHaiku + `)/([0-9a-f-]+)/([0-9a-f]{32})\.png$`)
+
+// ParsePath is the kind, uuid and fingerprint of the text of the card at the path, if it is one.
+func ParsePath(path string) (kind string, uuid string, fingerprint string, ok bool) {
+	m := pathRegexp.FindStringSubmatch(path)
+	if m == nil {
+		return "", "", "", false
+	}
+	return m[1], m[2], m[3], true
+}
+
+// New is the card of the text and attribution, over the image at imageUrl, in its colours,
+// or, if there is none or it can't be had, a plain card in the FT's.
+func New(ctx context.Context, text string, attribution string, imageUrl string) *Card {
+	c := Card{Text: text, Attribution: attribution, Background: ftPaper, Foreground: ftBlack}
+	if imageUrl == "" {
+		return &c
+	}
+
+	img, err := ftimage.GetImage(ctx, imageUrl)
+	if err != nil {
+		logging.FromContext(ctx).Warn("card: New: could not get the image, so making a plain card", "url", imageUrl, "err", err)
+		return &c
+	}
+	c.Image = img
+
+	// the panel goes over whichever of the image's top and foot is the more uniform, in its dominant colour,
+	// and the text in whichever of the image's prominent colours contrasts with that enough for WCAG AA
+	regions := ftimage.RegionColours(img)
+	top, topShare := band(regions, 0)
+	foot, footShare := band(regions, 2)
+	c.PanelAtTop = topShare > footShare
+	panel := foot
+	if c.PanelAtTop {
+		panel = top
+	}
+
+	palette, err := ftimage.ProminentColoursOf(img)
+	if err != nil {
+		logging.FromContext(ctx).Warn("card: New: could not find the image's colours, so the text is black or white", "url", imageUrl, "err", err)
+	}
+	pair := ftimage.TextColourPair(panel.RGBHex, *palette, ftimage.ContrastAA)
+	if bg, ok := parseHex(pair.BackgroundHex); ok {
+		c.Background = bg
+	}
+	if fg, ok := parseHex(pair.ForegroundHex); ok {
+		c.Foreground = fg
+	}
+	return &c
+}
+
+// band is the most uniform of the image's row of regions, and how uniform, on average, the row is.
+func band(regions []ftimage.RegionColour, row int) (ftimage.RegionColour, float64) {
+	var quietest ftimage.RegionColour
+	total, n := 0.0, 0
+	for _, r := range regions {
+		if r.Row == row {
+			if n == 0 || r.Share > quietest.Share {
+				quietest = r
+			}
+			total += r.Share
+			n++
+		}
+	}
+	if n == 0 {
+		return quietest, 0
+	}
+	return quietest, total / float64(n)
+}
+
+func parseHex(hex string) (color.RGBA, bool) {
+	hex = strings.TrimPrefix(hex, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// layoutText picks the largest scale at which the text fits in maxTextLines, cutting it short at the smallest.
+func layoutText(text string) ([]string, int) {
+	for _, scale := range textScales {
+		lines := wrap(text, (Width-2*margin)/(glyphAdvance*scale))
+		if len(lines) <= maxTextLines {
+			return lines, scale
+		}
+	}
+
+	scale := textScales[len(textScales)-1]
+	maxChars := (Width - 2*margin) / (glyphAdvance * scale)
+	lines := wrap(text, maxChars)[:maxTextLines]
+	last := lines[maxTextLines-1]
+	if len(last) > maxChars-3 {
+		last = last[:maxChars-3]
+	}
+	lines[maxTextLines-1] = last + "..."
+	return lines, scale
+}
+
+// Render draws the card as a PNG.
+func Render(c *Card) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(c.Background), image.ZP, draw.Src)
+	if c.Image != nil {
+		drawCover(dst, c.Image)
+	}
+
+	text := toAscii(c.Text)
+	if c.Image != nil || c.Attribution != "" {
+		text = `"` + strings.TrimSpace(text) + `"`
+	}
+	lines, scale := layoutText(text)
+	attributionScale := 3
+
+	contentHeight := len(lines) * lineAdvance * scale
+	if c.Attribution != "" {
+		contentHeight += 2 * lineAdvance * attributionScale
+	}
+
+	// over an image, the text is on a panel across its foot, or its head, below the logo,
+	// and on a plain card, centred below the logo
+	top := margin/2 + brandSize
+	y := Height - margin - contentHeight
+	switch {
+	case c.Image != nil && c.PanelAtTop:
+		y = top + margin/2
+		drawPanel(dst, image.Rect(0, 0, Width, y+contentHeight+margin), c.Background)
+	case c.Image != nil:
+		drawPanel(dst, image.Rect(0, y-margin, Width, Height), c.Background)
+	default:
+		y = top + (Height-top-contentHeight)/2
+	}
+
+	for _, line := range lines {
+		drawText(dst, margin, y, line, scale, c.Foreground)
+		y += lineAdvance * scale
+	}
+	if c.Attribution != "" {
+		y += lineAdvance * attributionScale
+		drawText(dst, margin, y, "- "+toAscii(c.Attribution), attributionScale, c.Foreground)
+	}
+
+	drawBrand(dst, c.Image == nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawPanel fills the panel behind the text, opaque, so the text has just the contrast checked against its colour.
+func drawPanel(dst draw.Image, panel image.Rectangle, c color.Color) {
+	draw.Draw(dst, panel, image.NewUniform(c), image.ZP, draw.Src)
+}
+
+// drawCover scales the image to cover the card, cropping its overhang equally from either side.
+func drawCover(dst draw.Image, img image.Image) {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return
+	}
+	w, h := uint(Width), uint(0)
+	if b.Dx()*Height < b.Dy()*Width {
+		// too tall, so fit its width
+		h = uint(b.Dy() * Width / b.Dx())
+	} else {
+		w, h = uint(b.Dx()*Height/b.Dy()), uint(Height)
+	}
+	scaled := resize.Resize(w, h, img, resize.Bilinear)
+
+	sb := scaled.Bounds()
+	offset := image.Pt(sb.Min.X+(sb.Dx()-Width)/2, sb.Min.Y+(sb.Dy()-Height)/2)
+	draw.Draw(dst, dst.Bounds(), scaled, offset, draw.Src)
+}
+
+// drawBrand draws the FT's logo in the top left corner, its letters on its paper colour, or, on a plain card, the reverse.
+func drawBrand(dst draw.Image, plain bool) {
+	box := image.Rect(margin, margin/2, margin+brandSize, margin/2+brandSize)
+	paper, ink := ftPaper, ftBlack
+	if plain {
+		paper, ink = ftBlack, ftPaper
+	}
+	draw.Draw(dst, box, image.NewUniform(paper), image.ZP, draw.Src)
+
+	scale := 5
+	x := box.Min.X + (brandSize-textWidth(2, scale))/2
+	y := box.Min.Y + (brandSize-(glyphHeight-1)*scale)/2 // centring the capitals, without the descenders' row
+	drawText(dst, x, y, "FT", scale, ink)
+}
@@ -0,0 +1,90 @@
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	uuid := "d2f40934-1792-11e6-b8d5-4c1fcdbe169f"
+	p := Path(KindHaiku, uuid, "an old silent pond")
+
+	kind, u, fingerprint, ok := ParsePath(p)
+	if !ok || kind != KindHaiku || u != uuid || Path(kind, u, "an old silent pond") != p || !strings.HasSuffix(p, fingerprint+".png") {
+		t.Errorf("expected %q to parse back to its kind, uuid and fingerprint, got %q, %q, %q, %t", p, kind, u, fingerprint, ok)
+	}
+	if Path(KindHaiku, uuid, "An old silent pond!") != p {
+		t.Errorf("expected the same path for the same words, whatever their case and punctuation")
+	}
+
+	for _, bad := range []string{"/cards/poem/" + uuid + "/0123456789abcdef0123456789abcdef.png", "/cards/haiku/" + uuid + "/../secret.png", "/cards/"} {
+		if _, _, _, ok := ParsePath(bad); ok {
+			t.Errorf("expected %q not to be a card's path", bad)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	p := Path(KindPullQuote, "d2f40934-1792-11e6-b8d5-4c1fcdbe169f", "We are not in the business of making excuses")
+	if _, ok := Lookup(p); ok {
+		t.Errorf("expected no card at %q before a feed has produced it", p)
+	}
+	Register(p, &Spec{Text: "We are not in the business of making excuses", Attribution: "Jane Smith"})
+	if spec, ok := Lookup(p); !ok || spec.Attribution != "Jane Smith" {
+		t.Errorf("expected the card registered at %q, got %+v, %t", p, spec, ok)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		text     string
+		maxChars int
+		expected []string
+	}{
+		{"an old silent pond", 10, []string{"an old", "silent", "pond"}},
+		{"an old\nsilent pond", 20, []string{"an old", "silent pond"}},
+		{"unquestionably so", 5, []string{"unque", "stion", "ably", "so"}},
+	}
+	for _, c := range cases {
+		if got := wrap(c.text, c.maxChars); strings.Join(got, "|") != strings.Join(c.expected, "|") {
+			t.Errorf("wrap(%q, %d): expected %q, got %q", c.text, c.maxChars, c.expected, got)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 600, 338))
+	for _, c := range []*Card{
+		{Text: "“We are not in the business of making excuses,” she said.", Attribution: "Jane Smith", Background: ftPaper, Foreground: ftBlack},
+		{Text: strings.Repeat("a long quote ", 100), Image: photo, Background: ftBlack, Foreground: color.White},
+	} {
+		b, err := Render(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("expected a PNG, got %v", err)
+		}
+		if img.Bounds().Dx() != Width || img.Bounds().Dy() != Height {
+			t.Errorf("expected a %dx%d card, got %v", Width, Height, img.Bounds())
+		}
+	}
+
+	// over an image, the panel hides it entirely, so the text's contrast is that with the panel's colour
+	white := image.NewRGBA(image.Rect(0, 0, 600, 338))
+	draw.Draw(white, white.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	b, err := Render(&Card{Text: "short", Image: white, Background: ftBlack, Foreground: color.White})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := png.Decode(bytes.NewReader(b))
+	if r, g, bl, _ := img.At(Width-1, Height-1).RGBA(); r>>8 != uint32(ftBlack.R) || g>>8 != uint32(ftBlack.G) || bl>>8 != uint32(ftBlack.B) {
+		t.Errorf("expected the panel opaque, in %v, got %v", ftBlack, img.At(Width-1, Height-1))
+	}
+}
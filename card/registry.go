@@ -0,0 +1,44 @@
+package card
+
+import "sync"
+
+// at most this many cards are registered, beyond which the oldest are forgotten, to be found again if asked for
+const maxRegistered = 5000
+
+// Spec is what a card is made from, as a feed had it: the text, its attribution, and the url of its article's image.
+type Spec struct {
+	Text        string
+	Attribution string
+	ImageUrl    string
+}
+
+// the cards the feeds have produced, by path, so they are rendered without looking their articles up again
+var registry = struct {
+	sync.RWMutex
+	specs map[string]*Spec
+	order []string // the paths registered, oldest first
+}{specs: map[string]*Spec{}}
+
+// Register records the card at path, as given it by Path, so it can be served.
+func Register(path string, spec *Spec) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.specs[path]; !ok {
+		registry.order = append(registry.order, path)
+	}
+	registry.specs[path] = spec
+
+	for len(registry.order) > maxRegistered {
+		delete(registry.specs, registry.order[0])
+		registry.order = registry.order[1:]
+	}
+}
+
+// Lookup is what the card at path is made from, if a feed has produced it since the server started.
+func Lookup(path string) (*Spec, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	spec, ok := registry.specs[path]
+	return spec, ok
+}
@@ -0,0 +1,357 @@
+// Command scansion runs the meter and haiku detector, and the pieces it is built from, from the command line.
+//
+//	scansion scan [-meter 0101010101 | -form haiku] [-format text|json|csv] [file ...]
+//	scansion pronounce [-format ...] word ...
+//	scansion rhymes [-format ...] word
+//	scansion fetch [-latest] [-format ...] uuid ...
+//	scansion index [-rebuild] [-format ...] [uuid ...]
+//
+// scan reads stdin if no files are given. index adds the articles to the corpus index (CORPUS_INDEX_DIR),
+// or with no uuids lists what is already there. The settings, e.g. SAPI_KEY, come from the config file
+// and environment as for the web server.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/railsagainstignorance/alignment/api"
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/config"
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/corpus"
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+// the config file and environment, with no flags of their own, which would clash with the commands'
+var cfg *config.Config
+
+// results go to stdout, and the packages' logging to stderr
+var out io.Writer = os.Stdout
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: scansion <command> [flags] [args]
+
+commands:
+  scan       find matches for a meter or form in files, or stdin
+  pronounce  show the dictionary pronunciation and stresses of words
+  rhymes     list the words which rhyme with a word
+  fetch      fetch articles by uuid
+  index      add articles to the corpus index, or list it
+
+run "scansion <command> -h" for each command's flags`)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	commands := map[string]func([]string) error{
+		"scan":      scanCommand,
+		"pronounce": pronounceCommand,
+		"rhymes":    rhymesCommand,
+		"fetch":     fetchCommand,
+		"index":     indexCommand,
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	var err error
+	cfg, err = config.Load("scansion", nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "scansion:", err)
+		os.Exit(2)
+	}
+	cfg.Inject(os.Stderr)
+
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "scansion:", os.Args[1]+":", err)
+		os.Exit(1)
+	}
+}
+
+// output holds the flags common to every command
+type output struct {
+	format       string
+	dictionaries string
+}
+
+func newFlagSet(name string, o *output) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.format, "format", "text", "output format: text, json or csv")
+	fs.StringVar(&o.dictionaries, "dict", cfg.Dictionaries, "comma separated list of CMUdict-style dictionary files")
+	return fs
+}
+
+func (o *output) syllabi() *rhyme.Syllabi {
+	filenames := strings.Split(o.dictionaries, ",")
+	return rhyme.ConstructSyllabi(&filenames)
+}
+
+// write renders the results in the chosen format: the value as JSON, the rows as CSV, or the lines as text.
+func (o *output) write(value interface{}, header []string, rows [][]string, lines []string) error {
+	switch o.format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "csv":
+		writer := csv.NewWriter(out)
+		writer.Write(header)
+		writer.WriteAll(rows)
+		return writer.Error()
+	case "text":
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", o.format)
+	}
+}
+
+type scanResult struct {
+	File string `json:"file"`
+	*api.TextResult
+}
+
+func scanCommand(args []string) error {
+	o := output{}
+	fs := newFlagSet("scan", &o)
+	meter := fs.String("meter", rhyme.DefaultMeter, "meter to match, e.g. 0101010101 or 01$")
+	form := fs.String("form", "", "named form to match instead of a meter, e.g. haiku")
+	fs.Parse(args)
+
+	if *form != "" {
+		m, ok := api.Forms[*form]
+		if !ok {
+			return fmt.Errorf("unknown form %q", *form)
+		}
+		*meter = m
+	}
+
+	filenames := fs.Args()
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	syllabi := o.syllabi()
+
+	results := []*scanResult{}
+	header := []string{"file", "sentence", "match", "lines", "final_word", "weak_end", "score"}
+	rows := [][]string{}
+	lines := []string{}
+
+	for _, filename := range filenames {
+		var text []byte
+		var err error
+		if filename == "-" {
+			text, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			text, err = ioutil.ReadFile(filename)
+		}
+		if err != nil {
+			return err
+		}
+
+		result := scanResult{filename, api.DetectInText(context.Background(), string(text), *meter, syllabi)}
+		result.Text = ""
+		results = append(results, &result)
+
+		for _, m := range result.Matches {
+			rows = append(rows, []string{
+				filename,
+				m.Sentence,
+				m.During,
+				strings.Join(m.Lines, " / "),
+				m.FinalWord,
+				strconv.FormatBool(m.EndsOnWeakWord),
+				strconv.FormatFloat(m.Score, 'f', 2, 64),
+			})
+
+			if len(m.Lines) > 0 {
+				lines = append(lines, fmt.Sprintf("%s: (%.2f)", filename, m.Score))
+				for _, line := range m.Lines {
+					lines = append(lines, "    "+line)
+				}
+			} else {
+				lines = append(lines, fmt.Sprintf("%s: (%.2f) %s", filename, m.Score, m.During))
+			}
+		}
+
+		if len(result.UnknownWords) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: unknown words: %s", filename, strings.Join(result.UnknownWords, ", ")))
+		}
+	}
+
+	return o.write(results, header, rows, lines)
+}
+
+type pronunciation struct {
+	Word          string `json:"word"`
+	Known         bool   `json:"known"`
+	Phonemes      string `json:"phonemes"`
+	NumSyllables  int    `json:"numSyllables"`
+	Stresses      string `json:"stresses"`
+	FinalSyllable string `json:"finalSyllable"`
+}
+
+func pronounceCommand(args []string) error {
+	o := output{}
+	fs := newFlagSet("pronounce", &o)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no words given")
+	}
+
+	syllabi := o.syllabi()
+
+	pronunciations := []*pronunciation{}
+	header := []string{"word", "known", "phonemes", "num_syllables", "stresses", "final_syllable"}
+	rows := [][]string{}
+	lines := []string{}
+
+	for _, word := range fs.Args() {
+		w := syllabi.FindMatchingWord(word)
+		p := pronunciation{
+			Word:          word,
+			Known:         !w.Unknown,
+			Phonemes:      w.FragmentsString,
+			NumSyllables:  w.NumSyllables,
+			Stresses:      w.EmphasisPointsString,
+			FinalSyllable: w.FinalSyllable,
+		}
+		pronunciations = append(pronunciations, &p)
+		rows = append(rows, []string{p.Word, strconv.FormatBool(p.Known), p.Phonemes, strconv.Itoa(p.NumSyllables), p.Stresses, p.FinalSyllable})
+
+		if p.Known {
+			lines = append(lines, fmt.Sprintf("%s: %s (%d syllables, stresses %s)", p.Word, p.Phonemes, p.NumSyllables, p.Stresses))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: not in the dictionary", p.Word))
+		}
+	}
+
+	return o.write(pronunciations, header, rows, lines)
+}
+
+func rhymesCommand(args []string) error {
+	o := output{}
+	fs := newFlagSet("rhymes", &o)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected one word")
+	}
+
+	syllabi := o.syllabi()
+	rhymes := syllabi.FindRhymes(fs.Arg(0))
+	sort.Strings(rhymes)
+
+	rows := [][]string{}
+	for _, r := range rhymes {
+		rows = append(rows, []string{r})
+	}
+
+	return o.write(rhymes, []string{"word"}, rows, rhymes)
+}
+
+func fetchCommand(args []string) error {
+	o := output{}
+	fs := newFlagSet("fetch", &o)
+	latest := fs.Bool("latest", false, "bypass the cache and fetch the latest version")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no uuids given")
+	}
+
+	articles := []*content.Article{}
+	header := []string{"uuid", "url", "title", "author", "pub_date", "body"}
+	rows := [][]string{}
+	lines := []string{}
+
+	for _, uuid := range fs.Args() {
+		a, err := content.GetArticle(context.Background(), uuid, *latest)
+		if err != nil {
+			return fmt.Errorf("could not fetch uuid=%s: %v", uuid, err)
+		}
+		if a.Uuid == "" {
+			return fmt.Errorf("could not fetch uuid=%s", uuid)
+		}
+		articles = append(articles, a)
+		rows = append(rows, []string{a.Uuid, a.SiteUrl, a.Title, a.Author, a.PubDateString, a.Body})
+		lines = append(lines, a.Title, "by "+a.Author+", "+a.PubDateString, a.SiteUrl, "", a.Body, "")
+	}
+
+	return o.write(articles, header, rows, lines)
+}
+
+type indexSummary struct {
+	Uuid         string `json:"uuid"`
+	Title        string `json:"title"`
+	PubDate      string `json:"pubDate"`
+	NumSentences int    `json:"numSentences"`
+	DateIndexed  string `json:"dateIndexed"`
+}
+
+func indexCommand(args []string) error {
+	o := output{}
+	fs := newFlagSet("index", &o)
+	rebuild := fs.Bool("rebuild", false, "reprocess every indexed article with the current dictionary, without fetching")
+	fs.Parse(args)
+
+	index := corpus.DefaultIndex()
+	uuids := fs.Args()
+
+	if *rebuild || len(uuids) > 0 {
+		syllabi := o.syllabi()
+		if *rebuild {
+			fmt.Fprintln(os.Stderr, "scansion: index: reprocessed", article.RebuildCorpusIndex(syllabi), "articles")
+		}
+		for _, uuid := range uuids {
+			if article.IndexArticle(context.Background(), uuid, syllabi) == nil {
+				return fmt.Errorf("could not index uuid=%s", uuid)
+			}
+		}
+	} else {
+		uuids = index.Uuids(nil, nil)
+	}
+
+	summaries := []*indexSummary{}
+	header := []string{"uuid", "title", "pub_date", "num_sentences", "date_indexed"}
+	rows := [][]string{}
+	lines := []string{}
+
+	for _, uuid := range uuids {
+		e := index.Get(uuid)
+		if e == nil {
+			continue
+		}
+		s := indexSummary{
+			Uuid:         e.Uuid,
+			PubDate:      e.PubDateString,
+			NumSentences: len(e.Sentences),
+			DateIndexed:  e.DateIndexed,
+		}
+		if e.Article != nil {
+			s.Title = e.Article.Title
+		}
+		summaries = append(summaries, &s)
+		rows = append(rows, []string{s.Uuid, s.Title, s.PubDate, strconv.Itoa(s.NumSentences), s.DateIndexed})
+		lines = append(lines, fmt.Sprintf("%s %s %4d sentences  %s", s.Uuid, s.PubDate, s.NumSentences, s.Title))
+	}
+
+	return o.write(summaries, header, rows, lines)
+}
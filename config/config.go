@@ -0,0 +1,492 @@
+// Package config gathers every setting of the server and tools into one typed Config.
+// Each setting has a default, which can be overridden by a JSON config file (named by CONFIG_FILE or -config),
+// then by an environment variable (or .env file) of the same name, then by a command line flag
+// (the name in lower case, with dashes, e.g. -ontology-max-articles).
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/railsagainstignorance/alignment/auth"
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/corpus"
+	"github.com/railsagainstignorance/alignment/curation"
+	"github.com/railsagainstignorance/alignment/httpcache"
+	ftimage "github.com/railsagainstignorance/alignment/image"
+	"github.com/railsagainstignorance/alignment/ingest"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/quota"
+	"github.com/railsagainstignorance/alignment/rss"
+)
+
+type Config struct {
+	Port            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	LogLevel  string
+	LogFormat string
+
+	SapiKey      string
+	FtApiTimeout time.Duration
+	HaikuJsonUrl string
+	Dictionaries string
+
+	AuthStaff      string
+	AuthApi        string
+	AuthApiKeys    string
+	AuthBasicUsers string
+
+	Quotas            string
+	MaxScans          int
+	TrustForwardedFor bool
+
+	CacheTTLs       string
+	CacheStale      time.Duration
+	CacheMaxEntries int
+
+	ImageFetchTimeout time.Duration
+	ImageMaxBytes     int
+
+	CurationFilename string
+	CorpusIndexDir   string
+
+	IngestEnabled     bool
+	IngestSource      string
+	IngestDir         string
+	IngestInterval    time.Duration
+	IngestMaxArticles int
+	IngestBackfill    time.Duration
+
+	JobsDir     string
+	JobsWorkers int
+
+	OntologyMaxArticles   int
+	OntologyMaxMillis     int
+	PullQuotesMaxArticles int
+	PullQuotesMaxMillis   int
+	FirstFTMaxArticles    int
+	FirstFTMaxMillis      int
+	RssMaxItems           int
+
+	KeywordsCsv        string
+	MeditationMaxItems int
+}
+
+func Defaults() *Config {
+	return &Config{
+		Port:            "8080",
+		ReadTimeout:     15 * time.Second,
+		WriteTimeout:    2 * time.Minute,
+		IdleTimeout:     2 * time.Minute,
+		ShutdownTimeout: time.Minute,
+
+		LogLevel:  "info",
+		LogFormat: logging.FormatText,
+
+		Dictionaries: "rhyme/cmudict-0.7b,rhyme/cmudict-0.7b_my_additions",
+
+		FtApiTimeout: 20 * time.Second,
+
+		AuthStaff: auth.ProviderS3o,
+		AuthApi:   auth.ProviderNone,
+
+		Quotas:   "align:60/1m,detect:120/1m,ontology:30/1m:50,pullquotes:30/1m:20,firstft:60/1m:10,cards:120/1m",
+		MaxScans: 8,
+
+		CacheTTLs:       "rss:5m,pullquotes:15m,firstft:5m,cards:24h",
+		CacheStale:      time.Hour,
+		CacheMaxEntries: 1000,
+
+		ImageFetchTimeout: 10 * time.Second,
+		ImageMaxBytes:     10 << 20,
+
+		CurationFilename: "curation.json",
+		CorpusIndexDir:   "corpus_index",
+
+		IngestSource:      ingest.SourceSearch,
+		IngestDir:         "ingest_data",
+		IngestInterval:    5 * time.Minute,
+		IngestMaxArticles: 20,
+		IngestBackfill:    24 * time.Hour,
+
+		JobsDir:     "jobs_data",
+		JobsWorkers: 1,
+
+		OntologyMaxArticles:   10,
+		OntologyMaxMillis:     30000,
+		PullQuotesMaxArticles: 10,
+		PullQuotesMaxMillis:   30000,
+		FirstFTMaxArticles:    2,
+		FirstFTMaxMillis:      1000,
+		RssMaxItems:           20,
+
+		KeywordsCsv:        "if,and,but,light,you",
+		MeditationMaxItems: 1000,
+	}
+}
+
+// setting describes one field of the Config: Name is its environment variable and config file key.
+type setting struct {
+	Name   string
+	Doc    string
+	Secret bool
+	target interface{} // a pointer to the field
+}
+
+func (c *Config) settings() []*setting {
+	return []*setting{
+		{"PORT", "port for the web server to listen on", false, &c.Port},
+		{"READ_TIMEOUT", "longest the web server waits to read a request", false, &c.ReadTimeout},
+		{"WRITE_TIMEOUT", "longest the web server spends on a response, including any scan", false, &c.WriteTimeout},
+		{"IDLE_TIMEOUT", "longest the web server keeps an idle connection open", false, &c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "longest the web server waits for requests in flight to finish when stopped", false, &c.ShutdownTimeout},
+		{"LOG_LEVEL", "least severe events logged: debug, info, warn or error", false, &c.LogLevel},
+		{"LOG_FORMAT", "how events are logged: text (key=value pairs) or json", false, &c.LogFormat},
+		{"SAPI_KEY", "key for the FT search (SAPI) and content (CAPI) APIs", true, &c.SapiKey},
+		{"FT_API_TIMEOUT", "longest spent on a call to the FT APIs (SAPI, CAPI and pages) before giving up on it", false, &c.FtApiTimeout},
+		{"HAIKU_JSON_URL", `feed of approved haiku for /rss, /carousel and meditation ("" or "local" for the curation store)`, false, &c.HaikuJsonUrl},
+		{"DICTIONARY_FILES", "comma separated CMUdict-style pronunciation dictionaries", false, &c.Dictionaries},
+		{"AUTH_STAFF", "auth providers tried, in order, for the staff pages (/ontology, /curation, /jobs, /config): comma separated none, s3o, apikey or basic", false, &c.AuthStaff},
+		{"AUTH_API", "auth providers tried, in order, for the JSON and feed endpoints (/api, /pullquotes, /firstft/rss)", false, &c.AuthApi},
+		{"AUTH_API_KEYS", "comma separated name:key:scope+scope for the apikey provider, sent in an X-Api-Key header (scope * for all)", true, &c.AuthApiKeys},
+		{"AUTH_BASIC_USERS", "comma separated name:password:scope+scope for the basic provider (scope * for all)", true, &c.AuthBasicUsers},
+		{"QUOTAS", "comma separated route:requests/period:maxCap, each client's quota of requests to a route and the cap on its max param, e.g. pullquotes:30/1m:20", false, &c.Quotas},
+		{"MAX_SCANS", "most requests scanning articles or texts at once, beyond which they get a 429", false, &c.MaxScans},
+		{"CACHE_TTLS", "comma separated route:ttl, how long the responses of each cached route (rss, pullquotes, firstft, cards) are fresh, e.g. rss:5m", false, &c.CacheTTLs},
+		{"CACHE_STALE", "how long past their TTL cached responses are still served while being refreshed", false, &c.CacheStale},
+		{"CACHE_MAX_ENTRIES", "most responses cached, beyond which the oldest are dropped", false, &c.CacheMaxEntries},
+		{"IMAGE_FETCH_TIMEOUT", "longest spent fetching an article's image, for its colours or a card, before doing without it", false, &c.ImageFetchTimeout},
+		{"IMAGE_MAX_BYTES", "largest article image fetched, in bytes, beyond which it is done without", false, &c.ImageMaxBytes},
+		{"TRUST_FORWARDED_FOR", "take clients' IPs from X-Forwarded-For, as set by a proxy in front, e.g. Heroku's router", false, &c.TrustForwardedFor},
+
+		{"CURATION_FILENAME", "JSON file holding the curated haiku", false, &c.CurationFilename},
+		{"CORPUS_INDEX_DIR", "directory of the corpus index of processed articles", false, &c.CorpusIndexDir},
+
+		{"INGEST_ENABLED", "scan new articles for haiku in the background", false, &c.IngestEnabled},
+		{"INGEST_SOURCE", "where the ingester finds new articles: search or news-feed", false, &c.IngestSource},
+		{"INGEST_DIR", "directory of the ingester's candidates and checkpoint", false, &c.IngestDir},
+		{"INGEST_INTERVAL", "time between ingester polls, e.g. 5m", false, &c.IngestInterval},
+		{"INGEST_MAX_ARTICLES", "most articles processed per ingester poll", false, &c.IngestMaxArticles},
+		{"INGEST_BACKFILL", "how far back the ingester starts, if it has no checkpoint, e.g. 24h", false, &c.IngestBackfill},
+
+		{"JOBS_DIR", "directory of the background scan jobs", false, &c.JobsDir},
+		{"JOBS_WORKERS", "number of background scan jobs run at once", false, &c.JobsWorkers},
+
+		{"ONTOLOGY_MAX_ARTICLES", "default number of articles scanned by /ontology", false, &c.OntologyMaxArticles},
+		{"ONTOLOGY_MAX_MILLIS", "time limit for /ontology to scan articles, in milliseconds", false, &c.OntologyMaxMillis},
+		{"PULLQUOTES_MAX_ARTICLES", "default number of articles searched for pull quotes", false, &c.PullQuotesMaxArticles},
+		{"PULLQUOTES_MAX_MILLIS", "time limit for the pull quotes search, in milliseconds", false, &c.PullQuotesMaxMillis},
+		{"FIRSTFT_MAX_ARTICLES", "default number of FirstFT articles followed by /firstft/rss", false, &c.FirstFTMaxArticles},
+		{"FIRSTFT_MAX_MILLIS", "time limit for the FirstFT search, in milliseconds", false, &c.FirstFTMaxMillis},
+		{"RSS_MAX_ITEMS", "number of haiku in /rss and /carousel", false, &c.RssMaxItems},
+
+		{"KEYWORDS_CSV", "comma separated keywords meditation picks haiku by", false, &c.KeywordsCsv},
+		{"MAX_ITEMS", "most haiku read by meditation", false, &c.MeditationMaxItems},
+	}
+}
+
+func (s *setting) flagName() string {
+	return strings.ToLower(strings.Replace(s.Name, "_", "-", -1))
+}
+
+func (s *setting) set(value string) error {
+	switch t := s.target.(type) {
+	case *string:
+		*t = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: not a whole number: %q", s.Name, value)
+		}
+		*t = i
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: not true or false: %q", s.Name, value)
+		}
+		*t = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: not a duration, e.g. 5m: %q", s.Name, value)
+		}
+		*t = d
+	}
+	return nil
+}
+
+func (s *setting) String() string {
+	switch t := s.target.(type) {
+	case *string:
+		return *t
+	case *int:
+		return strconv.Itoa(*t)
+	case *bool:
+		return strconv.FormatBool(*t)
+	case *time.Duration:
+		return t.String()
+	}
+	return ""
+}
+
+// flagValue collects a flag's value, to be applied after the config file and environment.
+type flagValue struct {
+	s     *setting
+	value *string
+}
+
+func (fv *flagValue) String() string {
+	if fv.value == nil {
+		return ""
+	}
+	return *fv.value
+}
+
+func (fv *flagValue) Set(value string) error {
+	fv.value = &value
+	return nil
+}
+
+// Load builds the Config from the defaults, the config file, the environment (and .env), and then args,
+// if not nil, parsed as flags in a flag set called name.
+func Load(name string, args []string) (*Config, error) {
+	c := Defaults()
+	settings := c.settings()
+
+	godotenv.Load()
+	configFile := os.Getenv("CONFIG_FILE")
+
+	flagValues := []*flagValue{}
+	if args != nil {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.StringVar(&configFile, "config", configFile, "JSON file of settings, keyed by their environment variable names (or CONFIG_FILE)")
+		for _, s := range settings {
+			fv := flagValue{s: s}
+			flagValues = append(flagValues, &fv)
+			fs.Var(&fv, s.flagName(), s.Doc+" (or "+s.Name+", default "+strconv.Quote(s.String())+")")
+		}
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if configFile != "" {
+		if err := c.loadFile(configFile, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := os.Getenv(s.Name); value != "" {
+			if err := s.set(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, fv := range flagValues {
+		if fv.value != nil {
+			if err := fv.s.set(*fv.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return c, c.Validate()
+}
+
+func (c *Config) loadFile(filename string, settings []*setting) error {
+	jsonBody, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(jsonBody, &values); err != nil {
+		return fmt.Errorf("config file %s: %v", filename, err)
+	}
+
+	byName := map[string]*setting{}
+	for _, s := range settings {
+		byName[s.Name] = s
+	}
+
+	for name, value := range values {
+		s, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %s", filename, name)
+		}
+		if err := s.set(fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("config file %s: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks every setting is in range, listing all the problems at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT must be a port number")
+	check(c.ReadTimeout > 0, "READ_TIMEOUT must be positive")
+	check(c.WriteTimeout > time.Duration(c.OntologyMaxMillis)*time.Millisecond, "WRITE_TIMEOUT must be longer than ONTOLOGY_MAX_MILLIS")
+	check(c.WriteTimeout > time.Duration(c.PullQuotesMaxMillis)*time.Millisecond, "WRITE_TIMEOUT must be longer than PULLQUOTES_MAX_MILLIS")
+	check(c.IdleTimeout > 0, "IDLE_TIMEOUT must be positive")
+	check(c.FtApiTimeout > 0, "FT_API_TIMEOUT must be positive")
+	_, err = quota.ParseRules(c.Quotas)
+	check(err == nil, "QUOTAS must be comma separated route:requests/period:maxCap")
+	check(c.MaxScans > 0, "MAX_SCANS must be positive")
+	_, err = httpcache.ParseTTLs(c.CacheTTLs)
+	check(err == nil, "CACHE_TTLS must be comma separated route:ttl, e.g. rss:5m")
+	check(c.CacheStale >= 0, "CACHE_STALE must not be negative")
+	check(c.CacheMaxEntries > 0, "CACHE_MAX_ENTRIES must be positive")
+	check(c.ImageFetchTimeout > 0, "IMAGE_FETCH_TIMEOUT must be positive")
+	check(c.ImageMaxBytes > 0, "IMAGE_MAX_BYTES must be positive")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	_, err = logging.ParseLevel(c.LogLevel)
+	check(err == nil, "LOG_LEVEL must be debug, info, warn or error")
+	check(c.LogFormat == logging.FormatText || c.LogFormat == logging.FormatJson, "LOG_FORMAT must be "+logging.FormatText+" or "+logging.FormatJson)
+	check(c.Dictionaries != "", "DICTIONARY_FILES must name at least one file")
+	apiKeys, err := auth.ParseCredentials(c.AuthApiKeys)
+	check(err == nil, "AUTH_API_KEYS must be comma separated name:key:scope+scope")
+	basicUsers, err := auth.ParseCredentials(c.AuthBasicUsers)
+	check(err == nil, "AUTH_BASIC_USERS must be comma separated name:password:scope+scope")
+	for _, policy := range []struct{ name, providers string }{{"AUTH_STAFF", c.AuthStaff}, {"AUTH_API", c.AuthApi}} {
+		names := strings.Split(policy.providers, ",")
+		known := 0
+		for _, name := range names {
+			for _, providerName := range auth.ProviderNames {
+				if strings.TrimSpace(name) == providerName {
+					known++
+				}
+			}
+		}
+		check(known > 0 && known == len(names), policy.name+" must list one or more of "+strings.Join(auth.ProviderNames, ", "))
+		check(!strings.Contains(policy.providers, auth.ProviderApiKey) || len(apiKeys) > 0, policy.name+" lists apikey, so AUTH_API_KEYS must be set")
+		check(!strings.Contains(policy.providers, auth.ProviderBasic) || len(basicUsers) > 0, policy.name+" lists basic, so AUTH_BASIC_USERS must be set")
+	}
+	check(c.CurationFilename != "", "CURATION_FILENAME must be set")
+	check(c.CorpusIndexDir != "", "CORPUS_INDEX_DIR must be set")
+	check(c.IngestSource == ingest.SourceSearch || c.IngestSource == ingest.SourceNewsFeed, "INGEST_SOURCE must be "+ingest.SourceSearch+" or "+ingest.SourceNewsFeed)
+	check(c.IngestDir != "", "INGEST_DIR must be set")
+	check(c.IngestInterval >= time.Second, "INGEST_INTERVAL must be at least 1s")
+	check(c.IngestMaxArticles > 0, "INGEST_MAX_ARTICLES must be positive")
+	check(c.IngestBackfill >= 0, "INGEST_BACKFILL must not be negative")
+	check(c.JobsDir != "", "JOBS_DIR must be set")
+	check(c.JobsWorkers > 0, "JOBS_WORKERS must be positive")
+	check(c.OntologyMaxArticles > 0, "ONTOLOGY_MAX_ARTICLES must be positive")
+	check(c.OntologyMaxMillis > 0, "ONTOLOGY_MAX_MILLIS must be positive")
+	check(c.PullQuotesMaxArticles > 0, "PULLQUOTES_MAX_ARTICLES must be positive")
+	check(c.PullQuotesMaxMillis > 0, "PULLQUOTES_MAX_MILLIS must be positive")
+	check(c.FirstFTMaxArticles > 0, "FIRSTFT_MAX_ARTICLES must be positive")
+	check(c.FirstFTMaxMillis > 0, "FIRSTFT_MAX_MILLIS must be positive")
+	check(c.RssMaxItems > 0, "RSS_MAX_ITEMS must be positive")
+	check(c.MeditationMaxItems > 0, "MAX_ITEMS must be positive")
+
+	if len(problems) > 0 {
+		return errors.New("config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c *Config) DictionaryFiles() *[]string {
+	files := []string{}
+	for _, f := range strings.Split(c.Dictionaries, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			files = append(files, f)
+		}
+	}
+	return &files
+}
+
+// AuthProviders are the auth providers by name, with the AUTH_API_KEYS and AUTH_BASIC_USERS, and s3o,
+// which is passed in as only the server has a use for it.
+func (c *Config) AuthProviders(s3o auth.Provider) map[string]auth.Provider {
+	apiKeys, _ := auth.ParseCredentials(c.AuthApiKeys)
+	basicUsers, _ := auth.ParseCredentials(c.AuthBasicUsers)
+	return map[string]auth.Provider{
+		auth.ProviderNone:   auth.None(),
+		auth.ProviderS3o:    s3o,
+		auth.ProviderApiKey: auth.ApiKeys(apiKeys),
+		auth.ProviderBasic:  auth.Basic(basicUsers, "alignment"),
+	}
+}
+
+// QuotaRules are the QUOTAS, by route.
+func (c *Config) QuotaRules() map[string]*quota.Rule {
+	rules, _ := quota.ParseRules(c.Quotas)
+	return rules
+}
+
+// CacheTTLsByRoute are the CACHE_TTLS, by route.
+func (c *Config) CacheTTLsByRoute() map[string]time.Duration {
+	ttls, _ := httpcache.ParseTTLs(c.CacheTTLs)
+	return ttls
+}
+
+// Logger logs to w at LOG_LEVEL, in LOG_FORMAT.
+func (c *Config) Logger(w io.Writer) *logging.Logger {
+	level, _ := logging.ParseLevel(c.LogLevel)
+	return logging.New(w, level, c.LogFormat)
+}
+
+// Inject hands the settings to the packages which are not otherwise given them by the server and tools,
+// first making the default logger log to logOutput.
+func (c *Config) Inject(logOutput io.Writer) {
+	logging.SetDefault(c.Logger(logOutput))
+	content.SetApiKey(c.SapiKey)
+	content.SetTimeout(c.FtApiTimeout)
+	rss.SetHaikuJsonUrl(c.HaikuJsonUrl)
+	curation.SetDefaultFilename(c.CurationFilename)
+	corpus.SetDefaultDir(c.CorpusIndexDir)
+	ftimage.SetLimits(c.ImageFetchTimeout, c.ImageMaxBytes)
+}
+
+// Setting is one setting's effective value, for display.
+type Setting struct {
+	Name  string
+	Value string
+	Doc   string
+}
+
+const redacted = "[redacted]"
+
+// Redacted lists every setting and its value, in name order, hiding the secrets.
+func (c *Config) Redacted() *[]*Setting {
+	settings := []*Setting{}
+	for _, s := range c.settings() {
+		value := s.String()
+		if s.Secret && value != "" {
+			value = redacted
+		}
+		settings = append(settings, &Setting{s.Name, value, s.Doc})
+	}
+	sort.Sort(byName(settings))
+	return &settings
+}
+
+type byName []*Setting
+
+func (ss byName) Len() int           { return len(ss) }
+func (ss byName) Swap(i, j int)      { ss[i], ss[j] = ss[j], ss[i] }
+func (ss byName) Less(i, j int) bool { return ss[i].Name < ss[j].Name }
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	fileBody := `{"PORT": 9000, "JOBS_WORKERS": 3, "INGEST_INTERVAL": "1m", "ONTOLOGY_MAX_ARTICLES": 5}`
+	if err := ioutil.WriteFile(filename, []byte(fileBody), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("JOBS_WORKERS", "4")
+	os.Setenv("ONTOLOGY_MAX_ARTICLES", "6")
+	defer os.Unsetenv("JOBS_WORKERS")
+	defer os.Unsetenv("ONTOLOGY_MAX_ARTICLES")
+
+	c, err := Load("test", []string{"-config", filename, "-ontology-max-articles", "7", "-ingest-enabled", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.RssMaxItems != 20 {
+		t.Errorf("expected the default RSS_MAX_ITEMS, got %d", c.RssMaxItems)
+	}
+	if c.Port != "9000" || c.IngestInterval != time.Minute {
+		t.Errorf("expected PORT and INGEST_INTERVAL from the file, got %s and %s", c.Port, c.IngestInterval)
+	}
+	if c.JobsWorkers != 4 {
+		t.Errorf("expected the environment to override the file, got JOBS_WORKERS=%d", c.JobsWorkers)
+	}
+	if c.OntologyMaxArticles != 7 || !c.IngestEnabled {
+		t.Errorf("expected the flags to override everything, got ONTOLOGY_MAX_ARTICLES=%d, INGEST_ENABLED=%v", c.OntologyMaxArticles, c.IngestEnabled)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load("test", []string{"-jobs-workers", "many"}); err == nil || !strings.Contains(err.Error(), "JOBS_WORKERS") {
+		t.Errorf("expected an error naming JOBS_WORKERS, got %v", err)
+	}
+
+	_, err := Load("test", []string{"-port", "0", "-ingest-source", "carrier-pigeon"})
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "INGEST_SOURCE") {
+		t.Errorf("expected both problems listed, got %v", err)
+	}
+
+	_, err = Load("test", []string{"-auth-api", "none,apikey", "-auth-staff", "sso"})
+	if err == nil || !strings.Contains(err.Error(), "AUTH_API_KEYS") || !strings.Contains(err.Error(), "AUTH_STAFF") {
+		t.Errorf("expected the missing keys and the unknown provider listed, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Defaults()
+	c.SapiKey = "secret"
+
+	found := false
+	for _, s := range *c.Redacted() {
+		if strings.Contains(s.Value, "secret") {
+			t.Errorf("expected %s to be redacted, got %s", s.Name, s.Value)
+		}
+		if s.Name == "SAPI_KEY" {
+			found = s.Value == redacted
+		}
+	}
+	if !found {
+		t.Error("expected SAPI_KEY listed as redacted")
+	}
+}
@@ -2,53 +2,99 @@ package content
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/joho/godotenv"
 	"io/ioutil"
 	"net/http"
-	"os"
+	neturl "net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/metrics"
 )
 
 const longformPubDate = "2006-01-02T15:04:05Z" // needs to be this exact string, according to http://stackoverflow.com/questions/25845172/parsing-date-string-in-golang
 const baseUriCapi = "https://api.ft.com/content/items/v1/"
 const baseUriSapi = "https://api.ft.com/content/search/v1"
 const newsFeedJsonUri = "http://www.ft-static.com/contentapi/live/latestNews.json"
-const sapiKeyEnvParamName = "SAPI_KEY"
 const maxSapiSearchSize = 100
 
-func getApiKey() string {
-	godotenv.Load()
-	key := os.Getenv(sapiKeyEnvParamName)
+var apiKey string
+
+// shared by every call to the FT APIs, its timeout set by SetTimeout
+var client = &http.Client{Timeout: 20 * time.Second}
+
+var (
+	upstreamRequests = metrics.NewCounter("alignment_upstream_requests_total", "Calls to the FT APIs, by endpoint and response status (or error).", "endpoint", "status")
+	upstreamLatency  = metrics.NewHistogram("alignment_upstream_request_duration_seconds", "How long calls to the FT APIs took, by endpoint.", metrics.LatencyBuckets, "endpoint")
+	cacheRequests    = metrics.NewCounter("alignment_cache_requests_total", "Lookups in the in-memory caches, by cache and result (hit, miss or stale).", "cache", "result")
+)
+
+// observeUpstream counts a call to the FT API endpoint (capi, sapi, pages or newsfeed), begun at start.
+func observeUpstream(endpoint string, start time.Time, resp *http.Response, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequests.With(endpoint, status).Inc()
+	upstreamLatency.With(endpoint).Observe(time.Since(start).Seconds())
+}
 
+// SetApiKey sets the key for the FT search (SAPI) and content (CAPI) APIs, before any are called.
+func SetApiKey(key string) {
 	if key == "" {
-		fmt.Println("content: getApiKey: no such env params: ", sapiKeyEnvParamName)
+		logging.Default().Warn("content: SetApiKey: no api key, so the FT APIs will refuse every request")
 	}
+	apiKey = key
+}
 
-	return key
+// SetTimeout sets how long a call to the FT APIs may take, before any are called.
+func SetTimeout(timeout time.Duration) {
+	client = &http.Client{Timeout: timeout}
 }
 
-var apiKey = getApiKey()
+// callUpstream makes the request of the FT API endpoint (capi, sapi, pages or newsfeed), within the timeout set by SetTimeout,
+// given up as soon as ctx is done, e.g. by the client disconnecting, and reads its body, an error unless a 200.
+func callUpstream(ctx context.Context, endpoint string, req *http.Request) (*[]byte, error) {
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	observeUpstream(endpoint, start, resp, err)
+	if err != nil {
+		// the url.Error would include the api key
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("content: %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	jsonBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("content: %s: reading the response: %v", endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("content: %s: status=%d", endpoint, resp.StatusCode)
+	}
+	return &jsonBody, nil
+}
+
+func getCapiArticleJsonBody(ctx context.Context, uuid string) (*[]byte, error) {
+	defer logging.Time(ctx, "fetch")()
 
-func getCapiArticleJsonBody(uuid string) *[]byte {
 	url := baseUriCapi + uuid + "?apiKey=" + apiKey
-	fmt.Println("content: getCapiArticleJsonBody: uuid=", uuid)
 
 	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	fmt.Println("content: getCapiArticleJsonBody: response Status:", resp.Status)
-	jsonBody, _ := ioutil.ReadAll(resp.Body)
-
-	return &jsonBody
+	jsonBody, err := callUpstream(ctx, "capi", req)
+	logging.FromContext(ctx).Debug("content: getCapiArticleJsonBody", "uuid", uuid, "err", err)
+	return jsonBody, err
 }
 
 func parsePubDateString(pds string) *time.Time {
@@ -57,7 +103,7 @@ func parsePubDateString(pds string) *time.Time {
 		if pd, err := time.Parse(longformPubDate, pds); err == nil {
 			pubDateTime = &pd
 		} else {
-			fmt.Println("WARNING: content.parsePubDateString: could not parse pubdate string: ", pds, ", where longformPubDate=", longformPubDate, "err=", err)
+			logging.Default().Warn("content: parsePubDateString: could not parse pubdate string", "pubDate", pds, "layout", longformPubDate, "err", err)
 		}
 	}
 
@@ -67,9 +113,18 @@ func parsePubDateString(pds string) *time.Time {
 type PullQuoteAsset struct {
 	Body        string
 	Attribution string
+	Source      string // one of the QuoteSources
 }
 
-func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
+// Where a pull quote was found, as its Source.
+const (
+	QuoteSourceAsset    = "asset"    // a pullQuote asset in CAPI
+	QuoteSourceMarkup   = "markup"   // a <pull-quote> or <blockquote> in the body
+	QuoteSourceSpeech   = "speech"   // quoted speech in the body
+	QuoteSourceQuotable = "quotable" // a sentence of the body, picked as quotable
+)
+
+func parseCapiArticleJsonBody(ctx context.Context, jsonBody *[]byte) *Article {
 
 	var data interface{}
 	json.Unmarshal(*jsonBody, &data)
@@ -82,6 +137,8 @@ func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
 	aPubDateString := ""
 	aBrand := ""
 	aGenre := ""
+	aCategories := []string{}
+	aPeople := []string{}
 	var aPubDate *time.Time
 
 	// look for article img, widest promo img, and widest non-promo img
@@ -150,11 +207,32 @@ func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
 						}
 					}
 				}
+				if peopleItems, ok := metadata["people"].([]interface{}); ok {
+					for _, personItem := range peopleItems {
+						if term, ok := personItem.(map[string]interface{})["term"].(map[string]interface{}); ok {
+							if name, ok := term["name"].(string); ok && name != "" {
+								aPeople = append( aPeople, name )
+							}
+						}
+					}
+				}
+				// every term's name, for the categories of the feeds, e.g. "Brexit" or "Analysis"
+				for _, taxonomy := range []string{"brand", "genre", "sections", "topics"} {
+					if taxonomyItems, ok := metadata[taxonomy].([]interface{}); ok {
+						for _, taxonomyItem := range taxonomyItems {
+							if term, ok := taxonomyItem.(map[string]interface{})["term"].(map[string]interface{}); ok {
+								if name, ok := term["name"].(string); ok && name != "" {
+									aCategories = append( aCategories, name )
+								}
+							}
+						}
+					}
+				}
 			}
 
 			if assets, ok := item["assets"].([]interface{}); ok {
 				if len(assets) > 0 {
-					fmt.Println("content: parseCapiArticleJsonBody: found assets, len > 0")
+					logging.FromContext(ctx).Debug("content: parseCapiArticleJsonBody: found assets", "numAssets", len(assets))
 
 					for _, asset := range assets {
 						if assetType, ok := asset.(map[string]interface{})["type"].(string); ok {
@@ -164,6 +242,7 @@ func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
 										pqAsset := PullQuoteAsset{
 											Body:        assetBody,
 											Attribution: "",
+											Source:      QuoteSourceAsset,
 										}
 										if assetAttribution, ok := assetFields.(map[string]interface{})["attribution"].(string); ok {
 											pqAsset.Attribution = assetAttribution
@@ -180,7 +259,7 @@ func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
 
 			if images, ok := item["images"].([]interface{}); ok {
 				if len(images) > 0 {
-					fmt.Println("content: parseCapiArticleJsonBody: found images, len > 0")
+					logging.FromContext(ctx).Debug("content: parseCapiArticleJsonBody: found images", "numImages", len(images))
 
 					for _, image := range images {
 						if imageType, ok := image.(map[string]interface{})["type"].(string); ok {
@@ -250,30 +329,69 @@ func parseCapiArticleJsonBody(jsonBody *[]byte) *Article {
 		NonPromoImageWidth:  aNonPromoImgWidth,
 		NonPromoImageHeight: aNonPromoImgHeight,
 		PullQuoteAssets: &aPullQuoteAssets,
+		Categories:      aCategories,
+		People:          aPeople,
 	}
 
-	fmt.Println("content: parseCapiArticleJsonBody: Uuid=", aUuid, ", ImageUrl=", aArticleImgUrl)
+	logging.FromContext(ctx).Debug("content: parseCapiArticleJsonBody", "uuid", aUuid, "imageUrl", aArticleImgUrl)
 
 	return &article
 }
 
-var uuidJsonBodyCache = map[string]*[]byte{}
+// at most this many articles' CAPI responses are cached, beyond which the oldest are dropped
+const maxCachedArticles = 1000
+
+// the CAPI responses by uuid, shared by the request handlers, jobs, ingester and cache refreshes, so behind a lock
+var (
+	uuidJsonBodyCache      = map[string]*[]byte{}
+	uuidJsonBodyCacheOrder = []string{} // the uuids cached, oldest first
+	uuidJsonBodyCacheMutex sync.RWMutex
+)
+
+func getCachedJsonBody(uuid string) (*[]byte, bool) {
+	uuidJsonBodyCacheMutex.RLock()
+	defer uuidJsonBodyCacheMutex.RUnlock()
+	jsonBody, ok := uuidJsonBodyCache[uuid]
+	return jsonBody, ok
+}
+
+// cacheJsonBody caches the article's CAPI response, dropping the oldest beyond maxCachedArticles.
+func cacheJsonBody(uuid string, jsonBody *[]byte) {
+	uuidJsonBodyCacheMutex.Lock()
+	defer uuidJsonBodyCacheMutex.Unlock()
+
+	if _, ok := uuidJsonBodyCache[uuid]; !ok {
+		uuidJsonBodyCacheOrder = append(uuidJsonBodyCacheOrder, uuid)
+	}
+	uuidJsonBodyCache[uuid] = jsonBody
+
+	for len(uuidJsonBodyCacheOrder) > maxCachedArticles {
+		delete(uuidJsonBodyCache, uuidJsonBodyCacheOrder[0])
+		uuidJsonBodyCacheOrder = uuidJsonBodyCacheOrder[1:]
+	}
+}
 
-func GetArticle(uuid string, latest bool) *Article {
-	var jsonBody *[]byte
+// GetArticle is the article with the uuid, from the cache, unless latest, or else from CAPI, or an error if CAPI can't give it.
+func GetArticle(ctx context.Context, uuid string, latest bool) (*Article, error) {
+	logger := logging.FromContext(ctx)
 
-	if _, ok := uuidJsonBodyCache[uuid]; ok && ! latest {
-		fmt.Println("content.GetArticle: cache hit: uuid=", uuid)
-		jsonBody = uuidJsonBodyCache[uuid]
+	jsonBody, ok := getCachedJsonBody(uuid)
+	if ok && ! latest {
+		logger.Debug("content: GetArticle: cache hit", "uuid", uuid)
+		cacheRequests.With("article", "hit").Inc()
 	} else {
-		fmt.Println("content.GetArticle: cache miss: uuid=", uuid)
-		jsonBody = getCapiArticleJsonBody(uuid)
-		uuidJsonBodyCache[uuid] = jsonBody
+		logger.Info("content: GetArticle: cache miss", "uuid", uuid)
+		cacheRequests.With("article", "miss").Inc()
+		var err error
+		if jsonBody, err = getCapiArticleJsonBody(ctx, uuid); err != nil {
+			return nil, err
+		}
+		cacheJsonBody(uuid, jsonBody)
 	}
 
-	article := parseCapiArticleJsonBody(jsonBody)
+	article := parseCapiArticleJsonBody(ctx, jsonBody)
 
-	return article
+	return article, nil
 }
 
 // now same for SAPI stuff
@@ -284,6 +402,7 @@ type SearchRequest struct {
 	MaxDurationMillis int
 	SearchOnly        bool // i.e. don't bother looking up articles
 	QueryStringValue  string
+	SortAscending     bool // i.e. oldest first, e.g. to work forwards through a date window
 }
 
 func constructQueryString(sr *SearchRequest) string {
@@ -302,6 +421,8 @@ func constructQueryString(sr *SearchRequest) string {
 		} else {
 			queryString = "lastPublishDateTime:<" + sr.QueryText
 		}
+	case "after":
+		queryString = "lastPublishDateTime:>" + sr.QueryText
 	default:
 		queryString = sr.QueryType + `:\"` + sr.QueryText + `\"`
 	}
@@ -322,7 +443,7 @@ func convertStringsToQuotedCSV(sList []string) string {
 
 // var stringJsonBodyCache = map[string]*[]byte{}
 
-func getSapiResponseJsonBody(queryString string, maxResults int, offset int) *[]byte {
+func getSapiResponseJsonBody(ctx context.Context, queryString string, maxResults int, offset int, sortOrder string) (*[]byte, error) {
 	curationsString := convertStringsToQuotedCSV([]string{"ARTICLES", "BLOGS"})
 	aspectsString := convertStringsToQuotedCSV([]string{"title", "location", "summary", "lifecycle", "metadata", "editorial"})
 
@@ -334,12 +455,11 @@ func getSapiResponseJsonBody(queryString string, maxResults int, offset int) *[]
 			`"maxResults" : "` + strconv.Itoa(maxResults) + `",` +
 			`"offset" : "` + strconv.Itoa(offset) + `",` +
 			`"aspects" : [ ` + aspectsString + `],` +
-			`"sortOrder": "DESC",` +
+			`"sortOrder": "` + sortOrder + `",` +
 			`"sortField": "lastPublishDateTime"` +
 			`}` +
 			`}`)
 
-	var jsonBody *[]byte
 	// jsonStrAsKey := string(jsonStr[:])
 	// if _, ok := stringJsonBodyCache[jsonStrAsKey]; ok {
 	// 	fmt.Println("content.getSapiResponseJsonBody: cache hit: jsonStrAsKey=", jsonStrAsKey)
@@ -350,31 +470,45 @@ func getSapiResponseJsonBody(queryString string, maxResults int, offset int) *[]
 	// 	stringJsonBodyCache[jsonStrAsKey] = jsonBody
 	// }
 
-	jsonBody = constructSapiResponseJsonBody(&jsonStr)
-
-
-	return jsonBody
+	return constructSapiResponseJsonBody(ctx, &jsonStr)
 }
 
-func constructSapiResponseJsonBody(jsonStr *[]byte) *[]byte {
-	url := baseUriSapi + "?apiKey=" + apiKey
+// CheckSapi asks the search API for a single result, to see whether it is reachable and accepts the api key.
+func CheckSapi(timeout time.Duration) error {
+	jsonStr := []byte(`{"queryString" : "ft", "resultContext" : {"maxResults" : "1"}}`)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(*jsonStr))
+	req, err := http.NewRequest("POST", baseUriSapi+"?apiKey="+apiKey, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
+	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
+		// the url.Error would include the api key
+		if urlErr, ok := err.(*neturl.Error); ok {
+			return urlErr.Err
+		}
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.Status != "200 OK" {
-		fmt.Println("WARNING: content: getSapiResponseJsonBody: response Status:", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("content: CheckSapi: status=%d", resp.StatusCode)
 	}
+	return nil
+}
+
+func constructSapiResponseJsonBody(ctx context.Context, jsonStr *[]byte) (*[]byte, error) {
+	defer logging.Time(ctx, "search")()
 
-	jsonBody, _ := ioutil.ReadAll(resp.Body)
+	url := baseUriSapi + "?apiKey=" + apiKey
 
-	return &jsonBody
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(*jsonStr))
+	if err != nil {
+		return nil, err
+	}
+	return callUpstream(ctx, "sapi", req)
 }
 
 type SearchResponse struct {
@@ -411,6 +545,8 @@ type Article struct {
 	PromoImageWidth  int
 	PromoImageHeight int
 	PullQuoteAssets *[]PullQuoteAsset
+	Categories      []string // the names of its brand, genre, sections and topics, from CAPI
+	People          []string // the names of the people it is about, from CAPI, e.g. to tell who is quoted
 }
 
 func parseSapiResponseJsonBody(jsonBody *[]byte, sReq *SearchRequest, queryString string) *SearchResponse {
@@ -541,24 +677,33 @@ func parseSapiResponseJsonBody(jsonBody *[]byte, sReq *SearchRequest, queryStrin
 	return &searchResponse
 }
 
-func lookupCapiArticles(sRequest *SearchRequest, sResponse *SearchResponse, startTiming time.Time) *[]*Article {
+func lookupCapiArticles(ctx context.Context, sRequest *SearchRequest, sResponse *SearchResponse, startTiming time.Time) *[]*Article {
 	maxDurationNanoseconds := int64(sRequest.MaxDurationMillis * 1e6)
 	capiArticles := []*Article{}
+	logger := logging.FromContext(ctx)
 
 	latest := false
 	if sRequest.MaxArticles > 0 {
 		for i, sapiA := range *(sResponse.Articles) {
 			articleLookupStartTiming := time.Now()
-			capiA := GetArticle(sapiA.Uuid, latest)
+			capiA, err := GetArticle(ctx, sapiA.Uuid, latest)
+			if ctx.Err() != nil {
+				logger.Info("content: lookupCapiArticles: cancelled", "numArticles", len(capiArticles), "err", ctx.Err())
+				break
+			}
+			if err != nil {
+				logger.Warn("content: lookupCapiArticles: skipping article", "uuid", sapiA.Uuid, "err", err)
+				continue
+			}
 			capiArticles = append(capiArticles, capiA)
 			articleLookupDuration := time.Since(articleLookupStartTiming).Nanoseconds()
-			fmt.Println("content.lookupCapiArticles: articleLookupDuration=", (articleLookupDuration / 1000000))
+			logger.Debug("content: lookupCapiArticles", "uuid", sapiA.Uuid, "millis", articleLookupDuration/1e6)
 			durationNanoseconds := time.Since(startTiming).Nanoseconds()
 			if i > sRequest.MaxArticles {
 				break
 			}
 			if durationNanoseconds > maxDurationNanoseconds {
-				fmt.Println("content.lookupCapiArticles: curtailing CAPI lookups: durationMillis=", durationNanoseconds/1e6)
+				logger.Info("content: lookupCapiArticles: curtailing CAPI lookups", "millis", durationNanoseconds/1e6, "numArticles", len(capiArticles))
 				break
 			}
 		}
@@ -580,13 +725,18 @@ func constructArticlesFromSearchResults(sRequest *SearchRequest, sResponse *Sear
 }
 
 // combine multiple SAPI requests to overcome SAPI's max request size
-func getAndParseMultipleSapiResponses(sRequest *SearchRequest) *SearchResponse {
+func getAndParseMultipleSapiResponses(ctx context.Context, sRequest *SearchRequest) (*SearchResponse, error) {
 	queryString := constructQueryString(sRequest)
 	maxResults := sRequest.MaxArticles
 
 	offset := 0
 	exceededNumPossible := false
 
+	sortOrder := "DESC"
+	if sRequest.SortAscending {
+		sortOrder = "ASC"
+	}
+
 	sResponses := []*SearchResponse{}
 
 	for offset < maxResults && !exceededNumPossible {
@@ -595,9 +745,12 @@ func getAndParseMultipleSapiResponses(sRequest *SearchRequest) *SearchResponse {
 			numRequestedArticles = maxSapiSearchSize
 		}
 
-		fmt.Println("getAndParseMultipleSapiResponses: offset=", offset, ", maxResults=", maxResults, ", numRequestedArticles=", numRequestedArticles)
+		logging.FromContext(ctx).Debug("content: getAndParseMultipleSapiResponses", "offset", offset, "maxResults", maxResults, "numRequestedArticles", numRequestedArticles)
 
-		jsonBody := getSapiResponseJsonBody(queryString, numRequestedArticles, offset)
+		jsonBody, err := getSapiResponseJsonBody(ctx, queryString, numRequestedArticles, offset, sortOrder)
+		if err != nil {
+			return nil, err
+		}
 		sResponse := parseSapiResponseJsonBody(jsonBody, sRequest, queryString)
 		sResponses = append( sResponses, sResponse )
 
@@ -614,68 +767,73 @@ func getAndParseMultipleSapiResponses(sRequest *SearchRequest) *SearchResponse {
 	sResponse.Articles = &articles
 	sResponse.NumArticles = len(articles)
 
-	return sResponse
+	return sResponse, nil
 }
 
-func Search(sRequest *SearchRequest) *SearchResponse {
+// Search finds the articles the request asks for, in SAPI or on an FT page, looking each up in CAPI too, unless SearchOnly,
+// skipping any which can't be had. It is an error if the search itself fails.
+func Search(ctx context.Context, sRequest *SearchRequest) (*SearchResponse, error) {
 	startTiming := time.Now()
+	logger := logging.FromContext(ctx)
 
-	fmt.Println("content.Search: sRequest.QueryType==page")
+	logger.Info("content: Search", "queryType", sRequest.QueryType, "queryText", sRequest.QueryText, "maxArticles", sRequest.MaxArticles)
 
 	var sResponse *SearchResponse
+	var err error
 	if sRequest.QueryType == "pages" {
 		webUrl := sRequest.QueryText
 		if webUrl == "http://www.ft.com/news-feed" {
-			jsonBody := constructGetResponseJsonBody(newsFeedJsonUri)
-			sResponse = parseNewsFeedContentJsonBody(jsonBody, sRequest, webUrl)
+			var jsonBody *[]byte
+			if jsonBody, err = constructGetResponseJsonBody(ctx, "newsfeed", newsFeedJsonUri); err == nil {
+				sResponse = parseNewsFeedContentJsonBody(jsonBody, sRequest, webUrl)
+			}
 		} else {
-			pageId := getPageIdByWebUrl(webUrl)
-			jsonBody := constructMainContentJsonBodyFromId(pageId)
-			sResponse = parseMainContentJsonBody(jsonBody, sRequest, webUrl)
+			var pageId string
+			var jsonBody *[]byte
+			if pageId, err = getPageIdByWebUrl(ctx, webUrl); err == nil {
+				if jsonBody, err = constructMainContentJsonBodyFromId(ctx, pageId); err == nil {
+					sResponse = parseMainContentJsonBody(jsonBody, sRequest, webUrl)
+				}
+			}
 		}
 	} else {
-		sResponse = getAndParseMultipleSapiResponses(sRequest)
+		sResponse, err = getAndParseMultipleSapiResponses(ctx, sRequest)
+	}
+	if err != nil {
+		logger.Warn("content: Search: failed", "queryType", sRequest.QueryType, "queryText", sRequest.QueryText, "err", err)
+		return nil, err
 	}
 
-	fmt.Println("content.Search: found sResponse.NumArticles=", sResponse.NumArticles)
+	logger.Debug("content: Search: found", "numArticles", sResponse.NumArticles, "numPossible", sResponse.NumPossible)
 
 	var articles *[]*Article
 	if !sRequest.SearchOnly {
-		articles = lookupCapiArticles(sRequest, sResponse, startTiming)
+		articles = lookupCapiArticles(ctx, sRequest, sResponse, startTiming)
 	} else {
 		articles = constructArticlesFromSearchResults(sRequest, sResponse)
 	}
 
 	sResponse.SetArticles(articles)
 
-	fmt.Println("content.Search: fleshed out len(articles)=", len(*articles))
+	logger.Info("content: Search: done", "numArticles", len(*articles), "millis", time.Since(startTiming).Nanoseconds()/1e6)
 
-	return sResponse
+	return sResponse, nil
 }
 
-func constructGetResponseJsonBody(url string) *[]byte {
+func constructGetResponseJsonBody(ctx context.Context, endpoint string, url string) (*[]byte, error) {
+	defer logging.Time(ctx, "search")()
+
 	urlWithKey := url + "?apiKey=" + apiKey
 
 	req, err := http.NewRequest("GET", urlWithKey, nil)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-
-	if resp.Status != "200 OK" {
-		fmt.Println("WARNING: content: constructGetResponseJsonBody: response Status:", resp.Status, ", url=", url)
+		return nil, err
 	}
-
-	jsonBody, _ := ioutil.ReadAll(resp.Body)
-
-	return &jsonBody
+	return callUpstream(ctx, endpoint, req)
 }
 
-func constructAllPagesJsonBody() *[]byte {
-	return constructGetResponseJsonBody("https://api.ft.com/site/v1/pages")
+func constructAllPagesJsonBody(ctx context.Context) (*[]byte, error) {
+	return constructGetResponseJsonBody(ctx, "pages", "https://api.ft.com/site/v1/pages")
 }
 
 func parseAllPagesJsonBody(jsonBody *[]byte) *map[string]string {
@@ -705,25 +863,40 @@ func parseAllPagesJsonBody(jsonBody *[]byte) *map[string]string {
 	return &mapWebUrlToId
 }
 
-var allKnownPageIdsByWebUrl *map[string]string
+// the pages, looked up once, by the page handlers or the ingester, whichever is first, so behind a lock
+var (
+	allKnownPageIdsByWebUrl      *map[string]string
+	allKnownPageIdsByWebUrlMutex sync.Mutex
+)
+
+// getAllPages is the pages, looked up the first time they are needed, or again next time if that fails.
+func getAllPages(ctx context.Context) (*map[string]string, error) {
+	allKnownPageIdsByWebUrlMutex.Lock()
+	defer allKnownPageIdsByWebUrlMutex.Unlock()
 
-func getAllPages() *map[string]string {
 	if allKnownPageIdsByWebUrl == nil {
-		jsonBody := constructAllPagesJsonBody()
+		jsonBody, err := constructAllPagesJsonBody(ctx)
+		if err != nil {
+			return nil, err
+		}
 		allKnownPageIdsByWebUrl = parseAllPagesJsonBody(jsonBody)
-		fmt.Println("content.getAllPages: len(allKnownPageIdsByWebUrl)=", len(*allKnownPageIdsByWebUrl))
+		logging.FromContext(ctx).Info("content: getAllPages", "numPages", len(*allKnownPageIdsByWebUrl))
 	}
 
-	return allKnownPageIdsByWebUrl
+	return allKnownPageIdsByWebUrl, nil
 }
 
-func getPageIdByWebUrl(webUrl string) string {
-	return (*getAllPages())[webUrl]
+func getPageIdByWebUrl(ctx context.Context, webUrl string) (string, error) {
+	pages, err := getAllPages(ctx)
+	if err != nil {
+		return "", err
+	}
+	return (*pages)[webUrl], nil
 }
 
-func constructMainContentJsonBodyFromId(id string) *[]byte {
+func constructMainContentJsonBodyFromId(ctx context.Context, id string) (*[]byte, error) {
 	url := "https://api.ft.com/site/v1/pages/" + id + "/main-content"
-	return constructGetResponseJsonBody(url)
+	return constructGetResponseJsonBody(ctx, "pages", url)
 }
 
 func parseMainContentJsonBody(jsonBody *[]byte, sReq *SearchRequest, webUrl string) *SearchResponse {
@@ -871,59 +1044,3 @@ func parseNewsFeedContentJsonBody(jsonBody *[]byte, sReq *SearchRequest, webUrl
 
 	return &searchResponse
 }
-
-func main() {
-	godotenv.Load()
-	uuid := "b57fee24-cb3c-11e5-be0b-b7ece4e953a0"
-	latest := false
-	article := GetArticle(uuid, latest)
-	fmt.Println("main: article.Title=", article.Title)
-
-	sRequest := &SearchRequest{
-		QueryType:         "keyword",    // e.g "keyword", "title", "topicXYZ", etc
-		QueryText:         "a bit of a", // e.g. "tail spin" or "\"tail spin\""
-		MaxArticles:       10,
-		MaxDurationMillis: 3000,
-		SearchOnly:        true, // i.e. don't bother looking up articles
-	}
-
-	fmt.Println("sRequest=", sRequest)
-	sResponse := Search(sRequest)
-
-	fmt.Println("sResponse:",
-		"\nSiteSearchUrl=", sResponse.SiteSearchUrl,
-		"\nNumArticles=", sResponse.NumArticles,
-		"\nNumPossible=", sResponse.NumPossible,
-		"\nQueryString=", sResponse.QueryString,
-		"\nSearchRequest=", sResponse.SearchRequest,
-		"\nArticles:\n",
-	)
-
-	for i, a := range *(sResponse.Articles) {
-		fmt.Println(i, " - ", a.Uuid, ", ", a.Title, ", by ", a.Author)
-	}
-
-	sRequest = &SearchRequest{
-		QueryType:         "keyword",    // e.g "keyword", "title", "topicXYZ", etc
-		QueryText:         "a bit of a", // e.g. "tail spin" or "\"tail spin\""
-		MaxArticles:       100,
-		MaxDurationMillis: 3000,
-		SearchOnly:        false, // i.e.do lookup CAPI
-	}
-
-	fmt.Println("sRequest=", sRequest)
-	sResponse = Search(sRequest)
-
-	fmt.Println("sResponse:",
-		"\nSiteSearchUrl=", sResponse.SiteSearchUrl,
-		"\nNumArticles=", sResponse.NumArticles,
-		"\nNumPossible=", sResponse.NumPossible,
-		"\nQueryString=", sResponse.QueryString,
-		"\nSearchRequest=", sResponse.SearchRequest,
-		"\nArticles:\n",
-	)
-
-	for i, a := range *(sResponse.Articles) {
-		fmt.Println(i, " - ", a.Uuid, ", ", a.Title, ", by ", a.Author, ", body=", a.Body[0:20])
-	}
-}
@@ -0,0 +1,50 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallUpstream(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/slow":
+			<-release
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	get := func(ctx context.Context, path string) (*[]byte, error) {
+		req, _ := http.NewRequest("GET", server.URL+path+"?apiKey=secret", nil)
+		return callUpstream(ctx, "capi", req)
+	}
+
+	if body, err := get(context.Background(), "/ok"); err != nil || string(*body) != `{"ok":true}` {
+		t.Errorf("expected the body, got %v", err)
+	}
+	if _, err := get(context.Background(), "/missing"); err == nil || !strings.Contains(err.Error(), "status=404") {
+		t.Errorf("expected an error for a 404, got %v", err)
+	}
+
+	// as when the client disconnects, the call is given up, not left running
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := get(ctx, "/slow")
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the call given up once its context was done, got %v after %s", err, time.Since(start))
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected the error not to give away the api key, got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package corpus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+// Sentence holds what the meter and haiku detectors need to know about a sentence,
+// so it can be rescanned without re-fetching or re-parsing the article.
+type Sentence struct {
+	Text           string
+	Words          []string
+	Pronunciations []string // the CMUDict fragments of each word, or "X" if unknown
+	StressString   string   // the rhyme.EmphasisPointsDetails.EmphasisPointsCombinedString
+}
+
+type Entry struct {
+	Uuid          string
+	PubDateString string
+	PubDate       *time.Time
+	Article       *content.Article
+	Sentences     []*Sentence
+	Dictionary    string // identifies the dictionary the pronunciations came from
+	DateIndexed   string
+}
+
+func (e *Entry) SentenceTexts() *[]string {
+	texts := []string{}
+	for _, s := range e.Sentences {
+		texts = append(texts, s.Text)
+	}
+	return &texts
+}
+
+// summary is as much of an entry as is needed to tell whether it is stale, and to list it by date, kept in memory for every entry.
+type summary struct {
+	Uuid          string
+	PubDateString string
+	PubDate       *time.Time
+	Dictionary    string
+}
+
+func summaryOf(e *Entry) *summary {
+	return &summary{Uuid: e.Uuid, PubDateString: e.PubDateString, PubDate: e.PubDate, Dictionary: e.Dictionary}
+}
+
+// Index is a local store of processed articles, one JSON file per article in a directory.
+// Only a summary of each is held in memory, and the entries most recently used, the others being read from their files as needed.
+type Index struct {
+	dir         string
+	mutex       sync.RWMutex
+	summaries   map[string]*summary
+	cached      map[string]*Entry
+	cachedOrder []string // the uuids cached, oldest first
+}
+
+const entryFileSuffix = ".json"
+
+// at most this many entries are held in memory, beyond which the oldest are dropped, to be read again if needed
+const maxCachedEntries = 200
+
+func Open(dir string) *Index {
+	idx := Index{
+		dir:       dir,
+		summaries: map[string]*summary{},
+		cached:    map[string]*Entry{},
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logging.Default().Warn("corpus: Open: could not create dir", "dir", dir, "err", err)
+		return &idx
+	}
+
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*"+entryFileSuffix))
+	for _, filename := range filenames {
+		jsonBody, err := ioutil.ReadFile(filename)
+		if err != nil {
+			logging.Default().Warn("corpus: Open: could not read entry", "filename", filename, "err", err)
+			continue
+		}
+		s := summary{}
+		if err := json.Unmarshal(jsonBody, &s); err != nil || s.Uuid == "" {
+			logging.Default().Warn("corpus: Open: could not parse entry", "filename", filename, "err", err)
+			continue
+		}
+		idx.summaries[s.Uuid] = &s
+	}
+
+	logging.Default().Info("corpus: Open: loaded", "numArticles", len(idx.summaries), "dir", dir)
+	return &idx
+}
+
+var defaultDir = "corpus_index"
+var defaultIndex *Index
+var defaultIndexOnce sync.Once
+
+// SetDefaultDir names the directory of the DefaultIndex, before it is first used.
+func SetDefaultDir(dir string) {
+	defaultDir = dir
+}
+
+// DefaultIndex is the index shared by the web server, ingester and command line tool.
+func DefaultIndex() *Index {
+	defaultIndexOnce.Do(func() {
+		defaultIndex = Open(defaultDir)
+	})
+	return defaultIndex
+}
+
+// DictionaryId summarises the syllabi, so entries indexed with a different dictionary can be spotted and reprocessed.
+func DictionaryId(syllabi *rhyme.Syllabi) string {
+	return fmt.Sprintf("words=%d,syllables=%d,fragments=%d", syllabi.Stats.NumWords, syllabi.Stats.NumSyllables, syllabi.Stats.NumFragments)
+}
+
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	return len(idx.summaries)
+}
+
+// Get is the entry of the article with the uuid, from memory or else its file, or nil if it is not in the index.
+func (idx *Index) Get(uuid string) *Entry {
+	idx.mutex.RLock()
+	s, indexed := idx.summaries[uuid]
+	entry := idx.cached[uuid]
+	idx.mutex.RUnlock()
+	if !indexed || entry != nil {
+		return entry
+	}
+
+	entry, err := idx.load(uuid)
+	if err != nil {
+		logging.Default().Warn("corpus: Get: could not read entry", "uuid", uuid, "err", err)
+		return nil
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	// unless replaced while it was being read
+	if idx.summaries[uuid] == s {
+		idx.cache(entry)
+	}
+	return entry
+}
+
+func (idx *Index) load(uuid string) (*Entry, error) {
+	jsonBody, err := ioutil.ReadFile(filepath.Join(idx.dir, uuid+entryFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	entry := Entry{}
+	if err := json.Unmarshal(jsonBody, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// cache holds the entry in memory, dropping the oldest beyond maxCachedEntries. The mutex must be held.
+func (idx *Index) cache(entry *Entry) {
+	if _, ok := idx.cached[entry.Uuid]; !ok {
+		idx.cachedOrder = append(idx.cachedOrder, entry.Uuid)
+	}
+	idx.cached[entry.Uuid] = entry
+
+	for len(idx.cachedOrder) > maxCachedEntries {
+		delete(idx.cached, idx.cachedOrder[0])
+		idx.cachedOrder = idx.cachedOrder[1:]
+	}
+}
+
+// IsStale reports whether the article needs (re)indexing: because it is not in the index,
+// because a newer version has been published since (if pubDateString is known), or because the dictionary has changed.
+func (idx *Index) IsStale(uuid string, pubDateString string, syllabi *rhyme.Syllabi) bool {
+	idx.mutex.RLock()
+	s := idx.summaries[uuid]
+	idx.mutex.RUnlock()
+	return s == nil ||
+		(pubDateString != "" && pubDateString != s.PubDateString) ||
+		s.Dictionary != DictionaryId(syllabi)
+}
+
+func processSentences(sentences []string, syllabi *rhyme.Syllabi) []*Sentence {
+	processed := []*Sentence{}
+	for _, text := range sentences {
+		epd := syllabi.FindAllEmphasisPointsDetails(text)
+		pronunciations := []string{}
+		for _, w := range epd.MatchingWords {
+			pronunciations = append(pronunciations, w.FragmentsString)
+		}
+		processed = append(processed, &Sentence{
+			Text:           text,
+			Words:          epd.PhraseWords,
+			Pronunciations: pronunciations,
+			StressString:   epd.EmphasisPointsCombinedString,
+		})
+	}
+	return processed
+}
+
+// Add processes the article's sentences and stores them, replacing any previous entry for the same uuid.
+func (idx *Index) Add(article *content.Article, sentences []string, syllabi *rhyme.Syllabi) (*Entry, error) {
+	entry := &Entry{
+		Uuid:          article.Uuid,
+		PubDateString: article.PubDateString,
+		PubDate:       article.PubDate,
+		Article:       article,
+		Sentences:     processSentences(sentences, syllabi),
+		Dictionary:    DictionaryId(syllabi),
+		DateIndexed:   time.Now().Format(time.RFC3339),
+	}
+
+	if err := idx.save(entry); err != nil {
+		logging.Default().Warn("corpus: Add: could not save entry", "uuid", article.Uuid, "err", err)
+		return entry, err
+	}
+
+	idx.mutex.Lock()
+	idx.summaries[entry.Uuid] = summaryOf(entry)
+	idx.cache(entry)
+	idx.mutex.Unlock()
+
+	return entry, nil
+}
+
+func (idx *Index) save(entry *Entry) error {
+	if entry.Uuid == "" || strings.ContainsAny(entry.Uuid, `/\.`) {
+		return fmt.Errorf("corpus: invalid uuid=%q", entry.Uuid)
+	}
+
+	jsonBody, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(idx.dir, entry.Uuid+entryFileSuffix)
+	tmpFilename := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, jsonBody, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// Rebuild reprocesses every stored article from its stored body, e.g. after the dictionary has been extended,
+// without fetching anything. splitIntoSentences should be the same function used when the articles were added.
+func (idx *Index) Rebuild(syllabi *rhyme.Syllabi, splitIntoSentences func(*content.Article) *[]string) int {
+	count := 0
+	for _, uuid := range idx.Uuids(nil, nil) {
+		entry := idx.Get(uuid)
+		if entry == nil || entry.Article == nil {
+			continue
+		}
+		if _, err := idx.Add(entry.Article, *splitIntoSentences(entry.Article), syllabi); err == nil {
+			count++
+		}
+	}
+
+	logging.Default().Info("corpus: Rebuild: reprocessed", "numArticles", count)
+	return count
+}
+
+type byPubDate []*summary
+
+func (es byPubDate) Len() int      { return len(es) }
+func (es byPubDate) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
+func (es byPubDate) Less(i, j int) bool {
+	if es[i].PubDateString == es[j].PubDateString {
+		return es[i].Uuid < es[j].Uuid
+	}
+	return es[i].PubDateString > es[j].PubDateString
+}
+
+// Uuids lists the indexed articles published between from and to (either of which may be nil), most recent first.
+func (idx *Index) Uuids(from *time.Time, to *time.Time) []string {
+	idx.mutex.RLock()
+	entries := []*summary{}
+	for _, e := range idx.summaries {
+		if from != nil && (e.PubDate == nil || e.PubDate.Before(*from)) {
+			continue
+		}
+		if to != nil && (e.PubDate == nil || e.PubDate.After(*to)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	idx.mutex.RUnlock()
+
+	sort.Sort(byPubDate(entries))
+
+	uuids := []string{}
+	for _, e := range entries {
+		uuids = append(uuids, e.Uuid)
+	}
+	return uuids
+}
+
+// MatchingSentences returns the entry's sentences whose stored stress string could match the meter,
+// so only those need the full (and slower) rhyme.Syllabi.RhymeAndMetersOfPhrase treatment.
+func (e *Entry) MatchingSentences(emphasisRegexp *regexp.Regexp) *[]string {
+	texts := []string{}
+	for _, s := range e.Sentences {
+		if emphasisRegexp.MatchString(s.StressString) {
+			texts = append(texts, s.Text)
+		}
+	}
+	return &texts
+}
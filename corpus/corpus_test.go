@@ -0,0 +1,68 @@
+package corpus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+var syllabi = rhyme.ConstructSyllabi(&[]string{"../rhyme/cmudict-0.7b", "../rhyme/cmudict-0.7b_my_additions"})
+
+func TestAddReopenAndMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	article := &content.Article{
+		Uuid:          "b57fee24-cb3c-11e5-be0b-b7ece4e953a0",
+		PubDateString: "2016-02-04T10:00:00Z",
+		Body:          "When I do count the clock that tells the time. Bananas",
+	}
+	sentences := []string{"When I do count the clock that tells the time", "Bananas"}
+
+	if _, err := Open(dir).Add(article, sentences, syllabi); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := Open(dir)
+	if len(idx.cached) != 0 {
+		t.Errorf("expected only summaries loaded on opening, got %d entries", len(idx.cached))
+	}
+	entry := idx.Get(article.Uuid)
+	if entry == nil || len(entry.Sentences) != 2 {
+		t.Fatalf("expected the entry to be reloaded with 2 sentences, got %+v", entry)
+	}
+
+	// once dropped from memory, it is read again from its file
+	for i := 0; i < maxCachedEntries; i++ {
+		idx.cache(&Entry{Uuid: fmt.Sprintf("other-%d", i)})
+	}
+	if len(idx.cached) != maxCachedEntries || idx.cached[article.Uuid] != nil {
+		t.Errorf("expected the oldest entry dropped to keep %d in memory, got %d", maxCachedEntries, len(idx.cached))
+	}
+	if reread := idx.Get(article.Uuid); reread == nil || len(reread.Sentences) != 2 {
+		t.Errorf("expected the dropped entry read again, got %+v", reread)
+	}
+	if entry.Sentences[0].StressString == "" || len(entry.Sentences[0].Pronunciations) != 10 {
+		t.Errorf("expected stresses and a pronunciation per word, got %+v", entry.Sentences[0])
+	}
+
+	if idx.IsStale(article.Uuid, article.PubDateString, syllabi) {
+		t.Errorf("freshly indexed article reported as stale")
+	}
+	if !idx.IsStale(article.Uuid, "2016-02-05T10:00:00Z", syllabi) {
+		t.Errorf("republished article not reported as stale")
+	}
+
+	emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp("0101010101")
+	matching := *entry.MatchingSentences(emphasisRegexp)
+	if len(matching) != 1 || matching[0] != sentences[0] {
+		t.Errorf("expected only the iambic sentence to match, got %v", matching)
+	}
+}
@@ -0,0 +1,354 @@
+package curation
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/dedup"
+	"github.com/railsagainstignorance/alignment/logging"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+const (
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+	ActionEdit    = "edit"
+	ActionTag     = "tag"
+)
+
+const dateSelectedFormat = "2006-01-02"
+
+// Haiku is a candidate found by the haiku detector, along with the editor's decisions about it.
+// Text is the haiku as originally found, and is what identifies it; Lines can be re-broken by an editor.
+type Haiku struct {
+	Id            string
+	Uuid          string
+	Url           string
+	Title         string
+	Author        string
+	ImageUrl      string
+	PubDateString string
+	Text          string
+	Lines         []string
+	Themes        []string
+	Status        string
+	DateSelected  string
+	DateUpdated   string
+	DuplicateOf   string
+}
+
+func GetMD5Hash(text string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(text))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+var nonWordRegexp = regexp.MustCompile(`\W+`)
+
+func normaliseWords(text string) string {
+	return strings.TrimSpace(nonWordRegexp.ReplaceAllString(strings.ToLower(text), " "))
+}
+
+// CandidateId identifies a haiku by its article and its words, ignoring line breaks and punctuation.
+func CandidateId(url string, text string) string {
+	return GetMD5Hash(url + "#" + normaliseWords(text))
+}
+
+// Store holds the curated haiku in memory, and persists them as JSON to a local file after every change.
+type Store struct {
+	filename string
+	mutex    sync.Mutex
+	haikus   map[string]*Haiku
+}
+
+func NewStore(filename string) *Store {
+	store := Store{
+		filename: filename,
+		haikus:   map[string]*Haiku{},
+	}
+
+	jsonBody, err := ioutil.ReadFile(filename)
+	if err != nil {
+		logging.Default().Info("curation: NewStore: starting empty store", "filename", filename, "err", err)
+		return &store
+	}
+
+	haikus := []*Haiku{}
+	if err := json.Unmarshal(jsonBody, &haikus); err != nil {
+		logging.Default().Warn("curation: NewStore: could not parse store", "filename", filename, "err", err)
+		return &store
+	}
+
+	for _, h := range haikus {
+		store.haikus[h.Id] = h
+	}
+
+	logging.Default().Info("curation: NewStore: loaded", "numHaiku", len(store.haikus), "filename", filename)
+	return &store
+}
+
+var defaultFilename = "curation.json"
+var defaultStore *Store
+var defaultStoreOnce sync.Once
+
+// SetDefaultFilename names the file of the DefaultStore, before it is first used.
+func SetDefaultFilename(filename string) {
+	defaultFilename = filename
+}
+
+// DefaultStore is the store shared by the web server, rss and meditation.
+func DefaultStore() *Store {
+	defaultStoreOnce.Do(func() {
+		defaultStore = NewStore(defaultFilename)
+	})
+	return defaultStore
+}
+
+// save assumes the mutex is held
+func (s *Store) save() error {
+	haikus := []*Haiku{}
+	for _, h := range s.haikus {
+		haikus = append(haikus, h)
+	}
+	sort.Sort(ByDateUpdated(haikus))
+
+	jsonBody, err := json.MarshalIndent(haikus, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := s.filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, jsonBody, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, s.filename)
+}
+
+type ByDateUpdated []*Haiku
+
+func (hs ByDateUpdated) Len() int      { return len(hs) }
+func (hs ByDateUpdated) Swap(i, j int) { hs[i], hs[j] = hs[j], hs[i] }
+func (hs ByDateUpdated) Less(i, j int) bool {
+	if hs[i].DateUpdated == hs[j].DateUpdated {
+		return hs[i].Id < hs[j].Id
+	}
+	return hs[i].DateUpdated > hs[j].DateUpdated
+}
+
+type ByDateSelected []*Haiku
+
+func (hs ByDateSelected) Len() int           { return len(hs) }
+func (hs ByDateSelected) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+func (hs ByDateSelected) Less(i, j int) bool { return hs[i].DateSelected > hs[j].DateSelected }
+
+// Haikus implements dedup.Documents, dating each haiku by its article
+type Haikus []*Haiku
+
+func (hs Haikus) Len() int          { return len(hs) }
+func (hs Haikus) Text(i int) string { return hs[i].Text }
+func (hs Haikus) Date(i int) *time.Time {
+	if pd, err := time.Parse(time.RFC3339, hs[i].PubDateString); err == nil {
+		return &pd
+	}
+	return nil
+}
+
+// findDuplicateOf looks for a haiku already in the store which is a near duplicate of h, e.g. from a syndicated copy
+// of the same article, and assumes the mutex is held.
+func (s *Store) findDuplicateOf(h *Haiku) string {
+	sig := dedup.MinHash(h.Text)
+	for _, existing := range s.haikus {
+		if existing.Id != h.Id && existing.DuplicateOf == "" && sig.Similarity(dedup.MinHash(existing.Text)) >= dedup.DefaultThreshold {
+			return existing.Id
+		}
+	}
+	return ""
+}
+
+func (s *Store) Get(id string) *Haiku {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if h, ok := s.haikus[id]; ok {
+		copied := *h
+		return &copied
+	}
+	return nil
+}
+
+// List returns copies of the haiku with the given status (or all of them, if status is ""), most recently updated first.
+func (s *Store) List(status string) *[]*Haiku {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	haikus := []*Haiku{}
+	for _, h := range s.haikus {
+		if status == "" || h.Status == status {
+			copied := *h
+			haikus = append(haikus, &copied)
+		}
+	}
+	sort.Sort(ByDateUpdated(haikus))
+
+	return &haikus
+}
+
+var linesSplitRegexp = regexp.MustCompile(`\s*(?:\r?\n|<br>|<BR>)\s*`)
+
+// SplitLines splits an editor's text into non-empty lines.
+func SplitLines(text string) []string {
+	lines := []string{}
+	for _, line := range linesSplitRegexp.Split(strings.TrimSpace(text), -1) {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// SplitThemes splits a comma separated list of themes, dropping duplicates and empty entries.
+func SplitThemes(csv string) []string {
+	themes := []string{}
+	seen := map[string]bool{}
+	for _, theme := range strings.Split(csv, ",") {
+		theme = strings.ToLower(strings.TrimSpace(theme))
+		if theme != "" && !seen[theme] {
+			seen[theme] = true
+			themes = append(themes, theme)
+		}
+	}
+	return themes
+}
+
+var ErrUnknownAction = errors.New("curation: unknown action")
+var ErrWordsChanged = errors.New("curation: edited lines must contain the same words as the original haiku")
+
+// Apply performs an editor's action on a candidate, creating it in the store if it is new.
+// The candidate's Lines and Themes, if set, replace those in the store, so an editor can re-break the lines
+// (but not change the words) or retag the themes as part of approving or rejecting.
+func (s *Store) Apply(action string, candidate *Haiku) (*Haiku, error) {
+	if len(candidate.Lines) > 0 && normaliseWords(strings.Join(candidate.Lines, " ")) != normaliseWords(candidate.Text) {
+		return nil, ErrWordsChanged
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := CandidateId(candidate.Url, candidate.Text)
+	h, ok := s.haikus[id]
+	if !ok {
+		h = &Haiku{
+			Id:            id,
+			Uuid:          candidate.Uuid,
+			Url:           candidate.Url,
+			Title:         candidate.Title,
+			Author:        candidate.Author,
+			ImageUrl:      candidate.ImageUrl,
+			PubDateString: candidate.PubDateString,
+			Text:          candidate.Text,
+			Lines:         SplitLines(candidate.Text),
+			Themes:        []string{},
+			Status:        StatusPending,
+		}
+		h.DuplicateOf = s.findDuplicateOf(h)
+	}
+
+	updated := *h
+	if len(candidate.Lines) > 0 {
+		updated.Lines = candidate.Lines
+	}
+	if candidate.Themes != nil {
+		updated.Themes = candidate.Themes
+	}
+
+	now := time.Now()
+	switch action {
+	case ActionApprove:
+		if updated.Status != StatusApproved || updated.DateSelected == "" {
+			updated.DateSelected = now.Format(dateSelectedFormat)
+		}
+		updated.Status = StatusApproved
+	case ActionReject:
+		updated.Status = StatusRejected
+	case ActionEdit, ActionTag:
+	default:
+		return nil, ErrUnknownAction
+	}
+	updated.DateUpdated = now.Format(time.RFC3339)
+
+	s.haikus[id] = &updated
+	if err := s.save(); err != nil {
+		logging.Default().Warn("curation: Apply: could not save store", "filename", s.filename, "err", err)
+		s.haikus[id] = h
+		if !ok {
+			delete(s.haikus, id)
+		}
+		return nil, err
+	}
+
+	logging.Default().Info("curation: Apply", "action", action, "id", id, "status", updated.Status)
+
+	copied := updated
+	return &copied, nil
+}
+
+// ApprovedJson renders the approved haiku in the same shape as the HAIKU_JSON_URL feed,
+// i.e. what rss.GenerateItems and meditation expect, most recently selected first.
+// Near duplicates are only included once, from the earliest article.
+func (s *Store) ApprovedJson(maxItems int) *[]byte {
+	allApproved := *s.List(StatusApproved)
+	sort.Stable(ByDateSelected(allApproved))
+
+	approved := []*Haiku{}
+	for _, group := range dedup.Group(Haikus(allApproved), dedup.DefaultThreshold) {
+		approved = append(approved, allApproved[group[0]])
+	}
+
+	items := []map[string]interface{}{}
+	for i, h := range approved {
+		if maxItems > 0 && i >= maxItems {
+			break
+		}
+
+		escapedLines := []string{}
+		for _, line := range h.Lines {
+			escapedLines = append(escapedLines, html.EscapeString(line))
+		}
+
+		item := map[string]interface{}{
+			"by":           h.Author,
+			"title":        h.Title,
+			"articleurl":   h.Url,
+			"haikuhtml":    strings.Join(escapedLines, "<br>"),
+			"haiku":        strings.Join(h.Lines, "\n"),
+			"dateselected": h.DateSelected,
+			"imageurl":     h.ImageUrl,
+		}
+		for _, theme := range h.Themes {
+			if _, clashes := item[theme]; !clashes {
+				item[theme] = true
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	jsonBody, _ := json.Marshal(items)
+	return &jsonBody
+}
@@ -0,0 +1,60 @@
+package curation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyPersistsAndServesApproved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "curation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "curation.json")
+
+	store := NewStore(filename)
+	candidate := &Haiku{
+		Url:    "http://www.ft.com/cms/s/2/abc.html",
+		Title:  "a title",
+		Author: "an author",
+		Text:   "an old silent pond\na frog jumps into the pond\nsplash! silence again",
+	}
+
+	if _, err := store.Apply(ActionApprove, &Haiku{Url: candidate.Url, Text: candidate.Text, Lines: []string{"an old frog"}}); err != ErrWordsChanged {
+		t.Errorf("expected ErrWordsChanged when editing the words, got %v", err)
+	}
+
+	candidate.Lines = []string{"an old silent pond a frog", "jumps into the pond", "splash! silence again"}
+	candidate.Themes = []string{"nature"}
+	h, err := store.Apply(ActionApprove, candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Status != StatusApproved || h.DateSelected == "" || len(h.Lines) != 3 {
+		t.Errorf("unexpected approved haiku: %+v", h)
+	}
+
+	reloaded := NewStore(filename)
+	if reloaded.Get(h.Id) == nil {
+		t.Fatalf("haiku not persisted to %s", filename)
+	}
+
+	items := []map[string]interface{}{}
+	if err := json.Unmarshal(*reloaded.ApprovedJson(0), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0]["haiku"] != "an old silent pond a frog\njumps into the pond\nsplash! silence again" || items[0]["nature"] != true {
+		t.Errorf("unexpected approved json: %v", items)
+	}
+
+	if _, err := reloaded.Apply(ActionReject, reloaded.Get(h.Id)); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reloaded.List(StatusApproved)) != 0 {
+		t.Errorf("rejected haiku still listed as approved")
+	}
+}
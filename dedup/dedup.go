@@ -0,0 +1,206 @@
+package dedup
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ShingleSize = 3
+	NumHashes   = 64
+	NumBands    = 16 // NumHashes/NumBands rows per band for the locality sensitive hashing
+	// DefaultThreshold is the estimated Jaccard similarity of shingles above which two texts count as duplicates
+	DefaultThreshold = 0.8
+)
+
+var (
+	quoteReplacer    = strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`, "–", "-", "—", "-")
+	nonWordRegexp    = regexp.MustCompile(`[^\w\s]+`)
+	whitespaceRegexp = regexp.MustCompile(`\s+`)
+)
+
+// Normalise lowercases the text, and drops punctuation and line breaks,
+// so that copies differing only in typography or layout normalise to the same string.
+func Normalise(text string) string {
+	text = strings.ToLower(quoteReplacer.Replace(text))
+	text = nonWordRegexp.ReplaceAllString(text, "")
+	text = whitespaceRegexp.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+// Fingerprint identifies the normalised text, e.g. for use in a guid.
+func Fingerprint(text string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(Normalise(text)))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Shingles returns the set of runs of ShingleSize consecutive words in the normalised text,
+// or the whole text if it is shorter than that.
+func Shingles(text string) map[string]bool {
+	words := strings.Fields(Normalise(text))
+	shingles := map[string]bool{}
+
+	if len(words) < ShingleSize {
+		shingles[strings.Join(words, " ")] = true
+		return shingles
+	}
+
+	for i := 0; i+ShingleSize <= len(words); i++ {
+		shingles[strings.Join(words[i:i+ShingleSize], " ")] = true
+	}
+	return shingles
+}
+
+type Signature [NumHashes]uint32
+
+func hashWithSeed(s string, seed uint32) uint32 {
+	hasher := fnv.New32a()
+	hasher.Write([]byte{byte(seed), byte(seed >> 8), byte(seed >> 16), byte(seed >> 24)})
+	hasher.Write([]byte(s))
+	return hasher.Sum32()
+}
+
+// MinHash summarises the text's shingles such that the proportion of matching entries
+// in two signatures estimates the Jaccard similarity of their shingles.
+func MinHash(text string) *Signature {
+	var sig Signature
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+
+	for shingle := range Shingles(text) {
+		for i := range sig {
+			if h := hashWithSeed(shingle, uint32(i)); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return &sig
+}
+
+func (s *Signature) Similarity(o *Signature) float64 {
+	matches := 0
+	for i := range s {
+		if s[i] == o[i] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(NumHashes)
+}
+
+func (s *Signature) bandKeys() []string {
+	rows := NumHashes / NumBands
+	keys := []string{}
+	for b := 0; b < NumBands; b++ {
+		hasher := fnv.New64a()
+		hasher.Write([]byte{byte(b)})
+		for _, v := range s[b*rows : (b+1)*rows] {
+			hasher.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+		}
+		keys = append(keys, string(hasher.Sum(nil)))
+	}
+	return keys
+}
+
+// Documents is implemented by a collection to be checked for duplicates, in the style of sort.Interface.
+// Date is used to pick the earliest document in each group, and may return nil if unknown.
+type Documents interface {
+	Len() int
+	Text(i int) string
+	Date(i int) *time.Time
+}
+
+// Group clusters the documents whose texts are identical once normalised, or estimated to be at least threshold similar.
+// Each group lists the indexes of its documents, earliest first (undated documents last, otherwise in their original order),
+// and the groups are in order of their first appearance, so a ranked list stays ranked.
+func Group(docs Documents, threshold float64) [][]int {
+	n := docs.Len()
+	parents := make([]int, n)
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	union := func(i, j int) {
+		ri, rj := find(i), find(j)
+		if ri < rj {
+			parents[rj] = ri
+		} else if rj < ri {
+			parents[ri] = rj
+		}
+	}
+
+	signatures := make([]*Signature, n)
+	byFingerprint := map[string]int{}
+	byBand := map[string][]int{}
+
+	for i := 0; i < n; i++ {
+		text := docs.Text(i)
+		fp := Fingerprint(text)
+		if j, ok := byFingerprint[fp]; ok {
+			union(j, i)
+			continue
+		}
+		byFingerprint[fp] = i
+
+		signatures[i] = MinHash(text)
+		checked := map[int]bool{}
+		for _, key := range signatures[i].bandKeys() {
+			for _, j := range byBand[key] {
+				if !checked[j] {
+					checked[j] = true
+					if signatures[i].Similarity(signatures[j]) >= threshold {
+						union(j, i)
+					}
+				}
+			}
+			byBand[key] = append(byBand[key], i)
+		}
+	}
+
+	groupsByRoot := map[int][]int{}
+	roots := []int{}
+	for i := 0; i < n; i++ {
+		r := find(i)
+		if _, ok := groupsByRoot[r]; !ok {
+			roots = append(roots, r)
+		}
+		groupsByRoot[r] = append(groupsByRoot[r], i)
+	}
+
+	groups := [][]int{}
+	for _, r := range roots {
+		group := groupsByRoot[r]
+		sort.Stable(byDate{group, docs})
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+type byDate struct {
+	indexes []int
+	docs    Documents
+}
+
+func (bd byDate) Len() int      { return len(bd.indexes) }
+func (bd byDate) Swap(i, j int) { bd.indexes[i], bd.indexes[j] = bd.indexes[j], bd.indexes[i] }
+func (bd byDate) Less(i, j int) bool {
+	di, dj := bd.docs.Date(bd.indexes[i]), bd.docs.Date(bd.indexes[j])
+	if di == nil {
+		return false
+	}
+	return dj == nil || di.Before(*dj)
+}
@@ -0,0 +1,54 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+)
+
+type testDocs struct {
+	texts []string
+	dates []*time.Time
+}
+
+func (d testDocs) Len() int              { return len(d.texts) }
+func (d testDocs) Text(i int) string     { return d.texts[i] }
+func (d testDocs) Date(i int) *time.Time { return d.dates[i] }
+
+func date(s string) *time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return &t
+}
+
+func TestNormaliseAndFingerprint(t *testing.T) {
+	a := "The market’s fall —\nsharp, sudden."
+	b := "the market's fall - sharp sudden"
+	if Normalise(a) != Normalise(b) {
+		t.Errorf("Normalise: %q != %q", Normalise(a), Normalise(b))
+	}
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Errorf("Fingerprint differs for %q and %q", a, b)
+	}
+}
+
+func TestGroupNearDuplicates(t *testing.T) {
+	docs := testDocs{
+		texts: []string{
+			"the central bank raised interest rates by a quarter of a percentage point on Thursday, its first rise in a decade",
+			"an unrelated sentence about the weather in the north of England being unusually wet this summer",
+			"The central bank raised interest rates by a quarter of a percentage point on Thursday — its first rise in a decade.",
+			"the central bank raised interest rates by a quarter of a percentage point on Thursday, its first rise in ten years",
+		},
+		dates: []*time.Time{date("2017-03-02"), nil, date("2017-03-01"), date("2017-03-03")},
+	}
+
+	groups := Group(docs, 0.6)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %v", groups)
+	}
+	if len(groups[0]) != 3 || groups[0][0] != 2 {
+		t.Errorf("expected the first group to contain all 3 copies, earliest (2) first, got %v", groups[0])
+	}
+	if len(groups[1]) != 1 || groups[1][0] != 1 {
+		t.Errorf("expected the unrelated sentence on its own, got %v", groups[1])
+	}
+}
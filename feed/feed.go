@@ -0,0 +1,476 @@
+// Package feed holds a feed of items, e.g. haiku or pull quotes, and writes it as RSS 2.0, Atom or JSON Feed 1.1,
+// with each item's dates, authors, categories and image.
+// It takes over from gorilla/feeds, which has no categories, nor enclosures apart from the item's link, nor JSON Feed.
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatRss  = "rss"
+	FormatAtom = "atom"
+	FormatJson = "json"
+)
+
+var Formats = []string{FormatRss, FormatAtom, FormatJson}
+
+var ContentTypes = map[string]string{
+	FormatRss:  "application/rss+xml; charset=utf-8",
+	FormatAtom: "application/atom+xml; charset=utf-8",
+	FormatJson: "application/feed+json; charset=utf-8",
+}
+
+// Negotiate picks the format asked for by the format param, if one of Formats, or else by the Accept header, or else RSS.
+func Negotiate(format string, accept string) string {
+	for _, f := range Formats {
+		if format == f {
+			return f
+		}
+	}
+
+	switch {
+	case strings.Contains(accept, "application/atom+xml"):
+		return FormatAtom
+	case strings.Contains(accept, "application/feed+json"), strings.Contains(accept, "application/json"):
+		return FormatJson
+	}
+	return FormatRss
+}
+
+type Person struct {
+	Name  string
+	Email string
+	Url   string
+}
+
+// Image is an item's picture, given as an enclosure in RSS and Atom. Length (in bytes) is 0 if not known.
+type Image struct {
+	Url    string
+	Type   string // e.g. image/jpeg
+	Length int64
+	Width  int
+	Height int
+}
+
+type Item struct {
+	Id          string // permanent and unique, a URL or URN, e.g. urn:uuid:...
+	Title       string
+	Url         string
+	ContentHtml string // escaped when written, rather than trusted
+	Summary     string // plain text
+	Published   time.Time
+	Updated     time.Time // zero if never updated
+	Authors     []Person
+	Categories  []string
+	Image       *Image
+}
+
+type Feed struct {
+	Title       string
+	Description string
+	Url         string // the home page
+	FeedUrl     string // where the feed itself is, if known
+	Language    string // e.g. en-gb
+	Copyright   string
+	Authors     []Person
+	Updated     time.Time
+	Items       []*Item
+}
+
+func (f *Feed) Write(format string) ([]byte, error) {
+	switch format {
+	case FormatAtom:
+		return f.ToAtom()
+	case FormatJson:
+		return f.ToJsonFeed()
+	}
+	return f.ToRss()
+}
+
+// ResolveUrls makes the items' links and images absolute, against base, e.g. the feed's own URL,
+// so those served alongside the feed, e.g. its cards, can be given by their paths.
+func (f *Feed) ResolveUrls(base string) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return
+	}
+	resolve := func(s string) string {
+		u, err := url.Parse(s)
+		if s == "" || err != nil || u.IsAbs() {
+			return s
+		}
+		return b.ResolveReference(u).String()
+	}
+
+	for _, item := range f.Items {
+		item.Url = resolve(item.Url)
+		if item.Image != nil {
+			item.Image.Url = resolve(item.Image.Url)
+		}
+	}
+}
+
+// lastUpdated is when the item last changed, its Updated, or else its Published, or else fallback.
+func (i *Item) lastUpdated(fallback time.Time) time.Time {
+	if !i.Updated.IsZero() {
+		return i.Updated
+	}
+	if !i.Published.IsZero() {
+		return i.Published
+	}
+	return fallback
+}
+
+func marshalXml(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// RSS 2.0, per http://www.rssboard.org/rss-specification, with the Atom self link, Dublin Core creators and Media RSS images
+
+type rssDoc struct {
+	XMLName    xml.Name    `xml:"rss"`
+	Version    string      `xml:"version,attr"`
+	XmlnsAtom  string      `xml:"xmlns:atom,attr"`
+	XmlnsDc    string      `xml:"xmlns:dc,attr"`
+	XmlnsMedia string      `xml:"xmlns:media,attr"`
+	Channel    *rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title          string     `xml:"title"`
+	Link           string     `xml:"link"`
+	Description    string     `xml:"description"`
+	Language       string     `xml:"language,omitempty"`
+	Copyright      string     `xml:"copyright,omitempty"`
+	ManagingEditor string     `xml:"managingEditor,omitempty"`
+	LastBuildDate  string     `xml:"lastBuildDate,omitempty"`
+	AtomLink       *atomLink  `xml:"atom:link"`
+	Items          []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title        string        `xml:"title"`
+	Link         string        `xml:"link,omitempty"`
+	Description  string        `xml:"description"`
+	Author       string        `xml:"author,omitempty"`
+	Creators     []string      `xml:"dc:creator"`
+	Categories   []string      `xml:"category"`
+	Enclosure    *rssEnclosure `xml:"enclosure"`
+	Guid         *rssGuid      `xml:"guid"`
+	PubDate      string        `xml:"pubDate,omitempty"`
+	MediaContent *mediaContent `xml:"media:content"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGuid struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type mediaContent struct {
+	Url    string `xml:"url,attr"`
+	Medium string `xml:"medium,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Width  int    `xml:"width,attr,omitempty"`
+	Height int    `xml:"height,attr,omitempty"`
+}
+
+// rssPerson is how RSS wants a person: an email address, with the name after it in brackets.
+// Without an address, the name goes in a dc:creator instead.
+func rssPerson(p Person) (string, bool) {
+	if p.Email == "" {
+		return p.Name, false
+	}
+	if p.Name == "" {
+		return p.Email, true
+	}
+	return p.Email + " (" + p.Name + ")", true
+}
+
+func (f *Feed) ToRss() ([]byte, error) {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        f.Url,
+		Description: f.Description,
+		Language:    f.Language,
+		Copyright:   f.Copyright,
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	if len(f.Authors) > 0 {
+		if editor, isEmail := rssPerson(f.Authors[0]); isEmail {
+			channel.ManagingEditor = editor
+		}
+	}
+	if f.FeedUrl != "" {
+		channel.AtomLink = &atomLink{Href: f.FeedUrl, Rel: "self", Type: "application/rss+xml"}
+	}
+
+	for _, i := range f.Items {
+		item := rssItem{
+			Title:       i.Title,
+			Link:        i.Url,
+			Description: i.ContentHtml,
+			Categories:  i.Categories,
+			Guid:        &rssGuid{IsPermaLink: strconv.FormatBool(i.Id == i.Url), Value: i.Id},
+		}
+		if item.Description == "" {
+			item.Description = i.Summary
+		}
+		if !i.Published.IsZero() {
+			item.PubDate = i.Published.Format(time.RFC1123Z)
+		}
+		// RSS has one author per item, so any others are creators
+		for _, a := range i.Authors {
+			if a.Email != "" && item.Author == "" {
+				item.Author, _ = rssPerson(a)
+			} else if a.Name != "" {
+				item.Creators = append(item.Creators, a.Name)
+			}
+		}
+		if i.Image != nil {
+			item.Enclosure = &rssEnclosure{Url: i.Image.Url, Length: strconv.FormatInt(i.Image.Length, 10), Type: i.Image.Type}
+			item.MediaContent = &mediaContent{Url: i.Image.Url, Medium: "image", Type: i.Image.Type, Width: i.Image.Width, Height: i.Image.Height}
+		}
+		channel.Items = append(channel.Items, &item)
+	}
+
+	return marshalXml(&rssDoc{
+		Version:    "2.0",
+		XmlnsAtom:  atomNs,
+		XmlnsDc:    "http://purl.org/dc/elements/1.1/",
+		XmlnsMedia: "http://search.yahoo.com/mrss/",
+		Channel:    &channel,
+	})
+}
+
+// Atom, per RFC 4287
+
+const atomNs = "http://www.w3.org/2005/Atom"
+
+type atomFeed struct {
+	XMLName  xml.Name      `xml:"feed"`
+	Xmlns    string        `xml:"xmlns,attr"`
+	XmlLang  string        `xml:"xml:lang,attr,omitempty"`
+	Id       string        `xml:"id"`
+	Title    string        `xml:"title"`
+	Subtitle string        `xml:"subtitle,omitempty"`
+	Updated  string        `xml:"updated"`
+	Links    []*atomLink   `xml:"link"`
+	Rights   string        `xml:"rights,omitempty"`
+	Authors  []*atomPerson `xml:"author"`
+	Entries  []*atomEntry  `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+	Uri   string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Id         string          `xml:"id"`
+	Title      string          `xml:"title"`
+	Updated    string          `xml:"updated"`
+	Published  string          `xml:"published,omitempty"`
+	Links      []*atomLink     `xml:"link"`
+	Authors    []*atomPerson   `xml:"author"`
+	Categories []*atomCategory `xml:"category"`
+	Summary    *atomText       `xml:"summary"`
+	Content    *atomText       `xml:"content"`
+}
+
+func atomPeople(people []Person) []*atomPerson {
+	aps := []*atomPerson{}
+	for _, p := range people {
+		if p.Name != "" {
+			aps = append(aps, &atomPerson{Name: p.Name, Email: p.Email, Uri: p.Url})
+		}
+	}
+	return aps
+}
+
+func (f *Feed) ToAtom() ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	id := f.FeedUrl
+	if id == "" {
+		id = f.Url
+	}
+	af := atomFeed{
+		Xmlns:    atomNs,
+		XmlLang:  f.Language,
+		Id:       id,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  updated.Format(time.RFC3339),
+		Links:    []*atomLink{{Href: f.Url, Rel: "alternate", Type: "text/html"}},
+		Rights:   f.Copyright,
+		Authors:  atomPeople(f.Authors),
+	}
+	if f.FeedUrl != "" {
+		af.Links = append(af.Links, &atomLink{Href: f.FeedUrl, Rel: "self", Type: "application/atom+xml"})
+	}
+
+	for _, i := range f.Items {
+		entry := atomEntry{
+			Id:      i.Id,
+			Title:   i.Title,
+			Updated: i.lastUpdated(updated).Format(time.RFC3339),
+			Authors: atomPeople(i.Authors),
+		}
+		if !i.Published.IsZero() {
+			entry.Published = i.Published.Format(time.RFC3339)
+		}
+		if i.Url != "" {
+			entry.Links = append(entry.Links, &atomLink{Href: i.Url, Rel: "alternate", Type: "text/html"})
+		}
+		if i.Image != nil {
+			entry.Links = append(entry.Links, &atomLink{Href: i.Image.Url, Rel: "enclosure", Type: i.Image.Type, Length: i.Image.Length})
+		}
+		for _, c := range i.Categories {
+			entry.Categories = append(entry.Categories, &atomCategory{Term: c})
+		}
+		if i.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Value: i.Summary}
+		}
+		if i.ContentHtml != "" {
+			entry.Content = &atomText{Type: "html", Value: i.ContentHtml}
+		}
+		af.Entries = append(af.Entries, &entry)
+	}
+
+	return marshalXml(&af)
+}
+
+// JSON Feed 1.1, per https://jsonfeed.org/version/1.1
+
+type jsonFeed struct {
+	Version     string            `json:"version"`
+	Title       string            `json:"title"`
+	HomePageUrl string            `json:"home_page_url,omitempty"`
+	FeedUrl     string            `json:"feed_url,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Language    string            `json:"language,omitempty"`
+	Authors     []*jsonFeedPerson `json:"authors,omitempty"`
+	Items       []*jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedPerson struct {
+	Name string `json:"name,omitempty"`
+	Url  string `json:"url,omitempty"`
+}
+
+type jsonFeedItem struct {
+	Id            string            `json:"id"`
+	Url           string            `json:"url,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	ContentHtml   string            `json:"content_html,omitempty"`
+	ContentText   string            `json:"content_text,omitempty"`
+	Summary       string            `json:"summary,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	DatePublished string            `json:"date_published,omitempty"`
+	DateModified  string            `json:"date_modified,omitempty"`
+	Authors       []*jsonFeedPerson `json:"authors,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+}
+
+func jsonFeedPeople(people []Person) []*jsonFeedPerson {
+	jps := []*jsonFeedPerson{}
+	for _, p := range people {
+		jp := jsonFeedPerson{Name: p.Name, Url: p.Url}
+		if jp.Url == "" && p.Email != "" {
+			jp.Url = "mailto:" + p.Email
+		}
+		if jp.Name != "" || jp.Url != "" {
+			jps = append(jps, &jp)
+		}
+	}
+	return jps
+}
+
+func (f *Feed) ToJsonFeed() ([]byte, error) {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageUrl: f.Url,
+		FeedUrl:     f.FeedUrl,
+		Description: f.Description,
+		Language:    f.Language,
+		Authors:     jsonFeedPeople(f.Authors),
+		Items:       []*jsonFeedItem{},
+	}
+
+	for _, i := range f.Items {
+		item := jsonFeedItem{
+			Id:          i.Id,
+			Url:         i.Url,
+			Title:       i.Title,
+			ContentHtml: i.ContentHtml,
+			Summary:     i.Summary,
+			Authors:     jsonFeedPeople(i.Authors),
+			Tags:        i.Categories,
+		}
+		if item.ContentHtml == "" {
+			// an item must have some content
+			item.ContentText = i.Summary
+		}
+		if i.Image != nil {
+			item.Image = i.Image.Url
+		}
+		if !i.Published.IsZero() {
+			item.DatePublished = i.Published.Format(time.RFC3339)
+		}
+		if !i.Updated.IsZero() {
+			item.DateModified = i.Updated.Format(time.RFC3339)
+		}
+		jf.Items = append(jf.Items, &item)
+	}
+
+	body, err := json.MarshalIndent(&jf, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("feed: ToJsonFeed: %s", err)
+	}
+	return append(body, '\n'), nil
+}
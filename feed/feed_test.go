@@ -0,0 +1,168 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() *Feed {
+	published := time.Date(2017, 3, 1, 9, 30, 0, 0, time.UTC)
+	return &Feed{
+		Title:    "Haiku & more",
+		Url:      "http://www.ft.com/hidden-haiku",
+		FeedUrl:  "https://example.com/rss?format=rss",
+		Language: "en-gb",
+		Authors:  []Person{{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"}},
+		Updated:  published.Add(time.Hour),
+		Items: []*Item{{
+			Id:          "urn:uuid:d2f40934-1792-11e6-b8d5-4c1fcdbe169f",
+			Title:       "Markets <rally>",
+			Url:         "http://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f",
+			ContentHtml: "<strong>an old silent pond</strong>",
+			Published:   published,
+			Authors:     []Person{{Name: "Jane Smith"}},
+			Categories:  []string{"nature", "Markets"},
+			Image:       &Image{Url: "http://example.com/pond.jpg", Type: "image/jpeg", Width: 600, Height: 338},
+		}},
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		format, accept, expected string
+	}{
+		{"", "", FormatRss},
+		{"atom", "application/feed+json", FormatAtom},
+		{"nonsense", "application/atom+xml,application/xml;q=0.9", FormatAtom},
+		{"", "application/feed+json", FormatJson},
+		{"", "text/html", FormatRss},
+	}
+	for _, c := range cases {
+		if got := Negotiate(c.format, c.accept); got != c.expected {
+			t.Errorf("Negotiate(%q, %q): expected %q, got %q", c.format, c.accept, c.expected, got)
+		}
+	}
+}
+
+func TestToRss(t *testing.T) {
+	b, err := testFeed().ToRss()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				Title       string   `xml:"title"`
+				Description string   `xml:"description"`
+				Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+				Categories  []string `xml:"category"`
+				Guid        string   `xml:"guid"`
+				PubDate     string   `xml:"pubDate"`
+				Enclosure   struct {
+					Url  string `xml:"url,attr"`
+					Type string `xml:"type,attr"`
+				} `xml:"enclosure"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("expected well formed XML, got %s in\n%s", err, b)
+	}
+
+	if doc.Channel.Title != "Haiku & more" || len(doc.Channel.Items) != 1 {
+		t.Fatalf("expected the channel and its item, got\n%s", b)
+	}
+	item := doc.Channel.Items[0]
+	if item.Title != "Markets <rally>" || item.Description != "<strong>an old silent pond</strong>" {
+		t.Errorf("expected the title and content to survive escaping, got %q and %q", item.Title, item.Description)
+	}
+	if item.Creator != "Jane Smith" || len(item.Categories) != 2 || item.Guid != "urn:uuid:d2f40934-1792-11e6-b8d5-4c1fcdbe169f" {
+		t.Errorf("expected the creator, categories and guid, got %+v", item)
+	}
+	if item.PubDate != "Wed, 01 Mar 2017 09:30:00 +0000" {
+		t.Errorf("expected an RFC 822 pubDate, got %q", item.PubDate)
+	}
+	if item.Enclosure.Url != "http://example.com/pond.jpg" || item.Enclosure.Type != "image/jpeg" {
+		t.Errorf("expected the image as an enclosure, got %+v", item.Enclosure)
+	}
+}
+
+func TestToAtom(t *testing.T) {
+	b, err := testFeed().ToAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Id      string   `xml:"id"`
+		Entries []struct {
+			Id      string `xml:"id"`
+			Updated string `xml:"updated"`
+			Links   []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("expected a well formed Atom feed, got %s in\n%s", err, b)
+	}
+
+	if doc.Id != "https://example.com/rss?format=rss" || len(doc.Entries) != 1 {
+		t.Fatalf("expected the feed's id and its entry, got\n%s", b)
+	}
+	entry := doc.Entries[0]
+	if entry.Updated != "2017-03-01T09:30:00Z" {
+		t.Errorf("expected an entry without an update to be updated when published, got %q", entry.Updated)
+	}
+	if len(entry.Links) != 2 || entry.Links[1].Rel != "enclosure" {
+		t.Errorf("expected alternate and enclosure links, got %+v", entry.Links)
+	}
+	if entry.Content != "<strong>an old silent pond</strong>" {
+		t.Errorf("expected the escaped content, got %q", entry.Content)
+	}
+}
+
+func TestToJsonFeed(t *testing.T) {
+	b, err := testFeed().ToJsonFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["version"] != "https://jsonfeed.org/version/1.1" || doc["feed_url"] != "https://example.com/rss?format=rss" {
+		t.Errorf("expected a JSON Feed 1.1 with its feed_url, got %s", b)
+	}
+
+	items := doc["items"].([]interface{})
+	item := items[0].(map[string]interface{})
+	if item["image"] != "http://example.com/pond.jpg" || item["date_published"] != "2017-03-01T09:30:00Z" || len(item["tags"].([]interface{})) != 2 {
+		t.Errorf("expected the item's image, date and tags, got %v", item)
+	}
+	if !strings.Contains(string(b), `"authors"`) {
+		t.Errorf("expected authors, got %s", b)
+	}
+}
+
+func TestResolveUrls(t *testing.T) {
+	f := testFeed()
+	f.Items[0].Image.Url = "/cards/haiku/d2f40934-1792-11e6-b8d5-4c1fcdbe169f/0123456789abcdef0123456789abcdef.png"
+	f.ResolveUrls(f.FeedUrl)
+
+	if f.Items[0].Image.Url != "https://example.com/cards/haiku/d2f40934-1792-11e6-b8d5-4c1fcdbe169f/0123456789abcdef0123456789abcdef.png" {
+		t.Errorf("expected the image's path resolved against the feed's url, got %q", f.Items[0].Image.Url)
+	}
+	if f.Items[0].Url != "http://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f" {
+		t.Errorf("expected the absolute link left as it was, got %q", f.Items[0].Url)
+	}
+}
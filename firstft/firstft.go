@@ -1,51 +1,43 @@
 package firstft
 
 import (
-	"fmt"
-	"os"
+	"context"
 	// "regexp"
 	// "strings"
 	"time"
 	// "encoding/json"
-	"github.com/joho/godotenv"
-	. "github.com/gorilla/feeds"
 	"regexp"
     "github.com/railsagainstignorance/alignment/content"
+    "github.com/railsagainstignorance/alignment/feed"
+    "github.com/railsagainstignorance/alignment/logging"
 )
 
-func getEnvParam(key string, defaultValue string) string {
-	godotenv.Load()
-	value := os.Getenv(key)
-
-	if value == "" {
-		value = defaultValue
-	}
-
-	return value
-}
-
-func getFirstFTArticles(maxArticles int, includeActualFirstFTArticle bool) *[]*content.Article {
+func getFirstFTArticles(ctx context.Context, maxArticles int, maxMillis int, includeActualFirstFTArticle bool) *[]*content.Article {
 
 	sRequest := &content.SearchRequest{
 		QueryType:         "brand",
 		QueryText:         "FirstFT",
 		MaxArticles:       maxArticles,
-		MaxDurationMillis: 1000,
+		MaxDurationMillis: maxMillis,
 		SearchOnly:        false, // i.e. return full article details too from CAPI
 	}
 
-	fmt.Println("getFirstFTArticles: sRequest=", sRequest, ", maxArticles=", maxArticles)
-
-	sapiResult := content.Search(sRequest)
+	logger := logging.FromContext(ctx)
+	logger.Info("firstft: getFirstFTArticles", "maxArticles", maxArticles, "maxMillis", maxMillis)
 
 	articles := []*content.Article {}
 
+	sapiResult, err := content.Search(ctx, sRequest)
+	if err != nil {
+		return &articles
+	}
+
 	hrefRegexp := regexp.MustCompile(`href="https:\/\/www\.ft\.com\/content\/([0-9a-f\-]+)"`)
 
 	latest := true
 
-	for i, article := range *(sapiResult.Articles) {
-		fmt.Println("getFirstFTArticles: ", i, ") ", article.Title)
+	for _, article := range *(sapiResult.Articles) {
+		logger.Debug("firstft: getFirstFTArticles: article", "uuid", article.Uuid, "title", article.Title)
 
 		if includeActualFirstFTArticle {
 			articles = append( articles, article )
@@ -57,10 +49,14 @@ func getFirstFTArticles(maxArticles int, includeActualFirstFTArticle bool) *[]*c
 
 		matches := hrefRegexp.FindAllStringSubmatch(article.Body, -1)
 		if matches != nil {
-			fmt.Println("getFirstFTArticles: found ", len(matches), " references to FT articles) ")
+			logger.Debug("firstft: getFirstFTArticles: found references to FT articles", "uuid", article.Uuid, "numReferences", len(matches))
 			for _,m := range matches {
 				uuid := m[1]
-				a := content.GetArticle(uuid, latest)
+				a, err := content.GetArticle(ctx, uuid, latest)
+				if err != nil {
+					logger.Warn("firstft: getFirstFTArticles: skipping referenced article", "uuid", uuid, "err", err)
+					continue
+				}
 				if a.Title != "" {
 					articles = append( articles, a )
 				}
@@ -93,44 +89,47 @@ func getFirstFTArticles(maxArticles int, includeActualFirstFTArticle bool) *[]*c
 // 	PullQuoteAssets *[]PullQuoteAsset
 // }
 
-func articlesToRss(articles *[]*content.Article) *string {
+func articlesToFeed(articles *[]*content.Article) *feed.Feed {
 	const siteUrl = "http://www.ft.com/"
 	now := time.Now()
 
-	feed := &Feed{
+	f := &feed.Feed{
 		Title:       "Articles for narration from the Financial Times: FT Labs experiment",
-		Link:        &Link{Href: siteUrl},
+		Url:         siteUrl,
 		Description: "Exploring the text to speech possibilities.",
-		Author:      &Author{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"},
-		Created:     now,
+		Language:    "en-gb",
+		Authors:     []feed.Person{{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"}},
+		Updated:     now,
 		Copyright:   "This work is copyright © Financial Times",
 	}
 
-	feed.Items = []*Item{}
+	f.Items = []*feed.Item{}
 
 	for _, article := range *articles {
-		feed.Items = append(feed.Items, &Item{
-			Title:       article.Title,
-			Link:        &Link{Href: article.SiteUrl},
-			Description: article.Body,
-			Created:     *article.PubDate,
+		item := &feed.Item{
 			Id:          article.SiteUrl,
-			Author:      &Author{Name: article.Author},
-		})
-
+			Title:       article.Title,
+			Url:         article.SiteUrl,
+			ContentHtml: article.Body,
+			Categories:  article.Categories,
+		}
+		if article.PubDate != nil {
+			item.Published = *article.PubDate
+		}
+		if article.Author != "" {
+			item.Authors = []feed.Person{{Name: article.Author}}
+		}
+		if article.ImageUrl != "" {
+			item.Image = &feed.Image{Url: article.ImageUrl, Type: "image/jpeg", Width: article.ImageWidth, Height: article.ImageHeight}
+		}
+		f.Items = append(f.Items, item)
 	}
 
-	rss, _ := feed.ToRss()
-	return &rss
-}
-
-func GenerateRss(maxArticles int, includeActualFirstFTArticle bool) *string {
-	articles := getFirstFTArticles( maxArticles, includeActualFirstFTArticle )
-	rssString := articlesToRss( articles )
-	return rssString
+	return f
 }
 
-func main() {
-	godotenv.Load()
-	// ...
+// GenerateFeed is the latest FirstFT articles, or those they link to, as a feed, to be written as RSS, Atom or JSON Feed.
+func GenerateFeed(ctx context.Context, maxArticles int, maxMillis int, includeActualFirstFTArticle bool) *feed.Feed {
+	articles := getFirstFTArticles( ctx, maxArticles, maxMillis, includeActualFirstFTArticle )
+	return articlesToFeed( articles )
 }
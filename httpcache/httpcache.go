@@ -0,0 +1,319 @@
+// Package httpcache caches the responses of the feed and JSON routes, keyed on their path and normalised query,
+// so feed readers polling every few minutes don't each cost a search, CAPI lookups and image downloads.
+// Responses carry an ETag and Last-Modified, for conditional GETs, and once older than their route's TTL
+// are served stale for a while longer, while a fresh copy is made in the background.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/auth"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/metrics"
+)
+
+var cacheRequests = metrics.NewCounter("alignment_cache_requests_total", "Lookups in the in-memory caches, by cache and result (hit, miss or stale).", "cache", "result")
+
+// ParseTTLs parses a comma separated list of route:ttl, e.g. "rss:5m,pullquotes:15m".
+func ParseTTLs(s string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("httpcache: ParseTTLs: expected route:ttl, got %q", entry)
+		}
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("httpcache: ParseTTLs: expected a positive duration, e.g. 5m, got %q", entry)
+		}
+		ttls[parts[0]] = ttl
+	}
+	return ttls, nil
+}
+
+type entry struct {
+	header       http.Header
+	body         []byte
+	etag         string
+	lastModified time.Time // when the body last changed, which a refresh may not
+	storedAt     time.Time
+	refreshing   bool
+}
+
+type Cache struct {
+	ttls       map[string]time.Duration
+	stale      time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+	filling map[string]chan struct{} // closed once the key's first fill is done
+}
+
+// New caches the responses of the routes in ttls for their TTL, then serves them stale for up to stale longer
+// while refreshing them, keeping at most maxEntries.
+func New(ttls map[string]time.Duration, stale time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttls:       ttls,
+		stale:      stale,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*entry{},
+		filling:    map[string]chan struct{}{},
+	}
+}
+
+// Key is the request's path and its query, sorted, without empty params, so the same request written differently hits.
+func Key(r *http.Request) string {
+	r.ParseForm()
+	values := url.Values{}
+	for name, vs := range r.Form {
+		for _, v := range vs {
+			if v != "" {
+				values.Add(name, v)
+			}
+		}
+	}
+	return r.URL.Path + "?" + values.Encode() // Encode sorts by name
+}
+
+// recorder keeps a response, to be cached.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header         { return rec.header }
+func (rec *recorder) WriteHeader(status int)      { rec.status = status }
+func (rec *recorder) Write(b []byte) (int, error) { return rec.body.Write(b) }
+
+func (c *Cache) record(next http.HandlerFunc, r *http.Request) *recorder {
+	rec := recorder{header: http.Header{}, status: http.StatusOK}
+	next(&rec, r)
+	return &rec
+}
+
+// store caches a successful response, keeping its Last-Modified if the body hasn't changed.
+func (c *Cache) store(key string, rec *recorder) *entry {
+	sum := sha1.Sum(rec.body.Bytes())
+	e := entry{
+		header:   rec.header,
+		body:     rec.body.Bytes(),
+		etag:     `"` + hex.EncodeToString(sum[:8]) + `"`,
+		storedAt: c.now(),
+	}
+	e.lastModified = e.storedAt
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if old, ok := c.entries[key]; ok && old.etag == e.etag {
+		e.lastModified = old.lastModified
+	}
+	c.entries[key] = &e
+
+	// evict the oldest, beyond maxEntries
+	if len(c.entries) > c.maxEntries {
+		keyed := byStoredAt{}
+		for k, e := range c.entries {
+			keyed = append(keyed, keyedEntry{k, e})
+		}
+		sort.Sort(keyed)
+		for _, ke := range keyed[:len(keyed)-c.maxEntries] {
+			delete(c.entries, ke.key)
+		}
+	}
+
+	return &e
+}
+
+type keyedEntry struct {
+	key string
+	*entry
+}
+
+type byStoredAt []keyedEntry
+
+func (s byStoredAt) Len() int           { return len(s) }
+func (s byStoredAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStoredAt) Less(i, j int) bool { return s[i].storedAt.Before(s[j].storedAt) }
+
+// Handler serves route's responses from the cache, if it has a TTL, filling it from next. Only GETs (and HEADs) are cached.
+func (c *Cache) Handler(route string, next http.HandlerFunc) http.HandlerFunc {
+	ttl, ok := c.ttls[route]
+	if !ok {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			next(w, r)
+			return
+		}
+
+		key := Key(r)
+		logger := logging.FromContext(r.Context())
+
+		for {
+			c.mutex.Lock()
+			e, cached := c.entries[key]
+			age := time.Duration(0)
+			if cached {
+				age = c.now().Sub(e.storedAt)
+			}
+
+			switch {
+			case cached && age < ttl:
+				c.mutex.Unlock()
+				cacheRequests.With("response", "hit").Inc()
+				c.serve(w, r, e, ttl, "HIT")
+				return
+
+			case cached && age < ttl+c.stale:
+				refresh := !e.refreshing
+				e.refreshing = true
+				c.mutex.Unlock()
+				cacheRequests.With("response", "stale").Inc()
+				if refresh {
+					go c.refresh(key, next, r)
+				}
+				c.serve(w, r, e, ttl, "STALE")
+				return
+			}
+
+			// wait for any fill already under way, then look again
+			if filling, ok := c.filling[key]; ok {
+				c.mutex.Unlock()
+				<-filling
+				continue
+			}
+			filling := make(chan struct{})
+			c.filling[key] = filling
+			c.mutex.Unlock()
+
+			cacheRequests.With("response", "miss").Inc()
+			rec := func() *recorder {
+				// even if next panics, so those waiting aren't left waiting
+				defer func() {
+					c.mutex.Lock()
+					delete(c.filling, key)
+					c.mutex.Unlock()
+					close(filling)
+				}()
+				return c.record(next, r)
+			}()
+
+			if rec.status != http.StatusOK {
+				// not cached, so those waiting make their own attempts
+				logger.Debug("httpcache: Handler: not caching", "key", key, "status", rec.status)
+				for name, values := range rec.header {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(rec.status)
+				w.Write(rec.body.Bytes())
+				return
+			}
+
+			c.serve(w, r, c.store(key, rec), ttl, "MISS")
+			return
+		}
+	}
+}
+
+// refresh makes a fresh copy of a stale response, apart from the request which found it stale,
+// as that request's context is done once it has been answered.
+func (c *Cache) refresh(key string, next http.HandlerFunc, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	ctx := logging.NewContext(context.Background(), logger)
+
+	defer func() {
+		c.mutex.Lock()
+		if e, ok := c.entries[key]; ok {
+			e.refreshing = false
+		}
+		c.mutex.Unlock()
+
+		if err := recover(); err != nil {
+			logger.Warn("httpcache: refresh: panic", "key", key, "err", err)
+		}
+	}()
+
+	// next takes a scan slot, as a fill does, so a refresh finding them all taken is tried again by the next stale request
+	rec := c.record(next, r.WithContext(ctx))
+	if rec.status == http.StatusTooManyRequests {
+		logger.Debug("httpcache: refresh: busy, still serving the stale response", "key", key)
+		return
+	}
+	if rec.status != http.StatusOK {
+		logger.Warn("httpcache: refresh: failed, still serving the stale response", "key", key, "status", rec.status)
+		return
+	}
+	c.store(key, rec)
+	logger.Debug("httpcache: refresh: done", "key", key)
+}
+
+// serve answers from e, or with a 304 if the client's copy, by its If-None-Match or If-Modified-Since, is current.
+// Responses to a route behind an auth policy are private, so shared caches in between don't give them to anyone else.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry, ttl time.Duration, result string) {
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+
+	// in whole seconds, so Age and max-age add up to the TTL while fresh
+	age := int(c.now().Sub(e.storedAt).Seconds())
+	maxAge := int(ttl.Seconds()) - age
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("ETag", e.etag)
+	w.Header().Set("Last-Modified", e.lastModified.UTC().Format(http.TimeFormat))
+	visibility := "public"
+	if auth.FromContext(r.Context()) != nil {
+		visibility = "private"
+		w.Header().Set("Vary", "Authorization, "+auth.ApiKeyHeader)
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, stale-while-revalidate=%d", visibility, maxAge, int(c.stale.Seconds())))
+	w.Header().Set("Age", strconv.Itoa(age))
+	w.Header().Set("X-Cache", result)
+
+	if notModified(r, e) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(e.body)
+}
+
+func notModified(r *http.Request, e *entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, etag := range strings.Split(inm, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == e.etag || etag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !e.lastModified.Truncate(time.Second).After(ims)
+	}
+	return false
+}
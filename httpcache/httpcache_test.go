@@ -0,0 +1,118 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/auth"
+)
+
+func TestKey(t *testing.T) {
+	a := Key(httptest.NewRequest("GET", "/pullquotes/json?value=x&ontology=authors&max=", nil))
+	b := Key(httptest.NewRequest("GET", "/pullquotes/json?ontology=authors&value=x", nil))
+	if a != b {
+		t.Errorf("expected the same key, got %s and %s", a, b)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := New(map[string]time.Duration{"rss": time.Minute}, time.Hour, 10)
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	var calls int32
+	refreshed := make(chan bool, 1)
+	h := c.Handler("rss", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, "feed %d", n)
+		if n > 1 {
+			refreshed <- true
+		}
+	})
+	get := func(header string, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/rss", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	first := get("", "")
+	if first.Body.String() != "feed 1" || first.Header().Get("X-Cache") != "MISS" || first.Header().Get("Content-Type") != "application/rss+xml" {
+		t.Errorf("expected the first response made, got %s, %v", first.Body.String(), first.Header())
+	}
+	if w := get("", ""); w.Body.String() != "feed 1" || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the cached response, got %s, %s", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	if w := get("If-None-Match", first.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("expected a 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := get("If-Modified-Since", first.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Errorf("expected a 304 when not modified since, got %d", w.Code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if w := get("", ""); w.Body.String() != "feed 1" || w.Header().Get("X-Cache") != "STALE" {
+		t.Errorf("expected the stale response while refreshing, got %s, %s", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	<-refreshed
+
+	// the refresh is stored just after it is made
+	w := get("", "")
+	for i := 0; i < 100 && w.Body.String() != "feed 2"; i++ {
+		time.Sleep(time.Millisecond)
+		w = get("", "")
+	}
+	if w.Body.String() != "feed 2" || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the refreshed response, got %s, %s", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
+
+func TestHandlerErrorsNotCached(t *testing.T) {
+	c := New(map[string]time.Duration{"firstft": time.Minute}, time.Hour, 10)
+	calls := 0
+	h := c.Handler("firstft", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "upstream down", http.StatusBadGateway)
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/firstft/rss", nil))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("expected the error passed on, got %d", w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected errors not to be cached, got %d calls", calls)
+	}
+}
+
+func TestHandlerPrivate(t *testing.T) {
+	c := New(map[string]time.Duration{"pullquotes": time.Minute}, time.Hour, 10)
+	h := c.Handler("pullquotes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "quotes")
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/pullquotes/json", nil))
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "public,") {
+		t.Errorf("expected a public response outside any auth policy, got %s", cc)
+	}
+
+	r := httptest.NewRequest("GET", "/pullquotes/json", nil)
+	r = r.WithContext(auth.NewContext(r.Context(), &auth.Principal{Name: "reader", Provider: auth.ProviderApiKey}))
+	w = httptest.NewRecorder()
+	h(w, r)
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private,") || w.Header().Get("Vary") == "" || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the cached response, private and varying by credentials, behind an auth policy, got %v", w.Header())
+	}
+}
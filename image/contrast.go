@@ -0,0 +1,107 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	sadcolor "github.com/generaltso/sadbox/color"
+)
+
+// The WCAG 2.0 minimum contrast ratios, https://www.w3.org/TR/WCAG20/#visual-audio-contrast-contrast,
+// for text (AA), large text, i.e. 18pt or 14pt bold (AALarge), and text at the enhanced level (AAA).
+const (
+	ContrastAA      = 4.5
+	ContrastAALarge = 3.0
+	ContrastAAA     = 7.0
+)
+
+// how far the lightness of a colour is moved at a time, in search of the contrast asked for
+const lightnessStep = 0.02
+
+// ColourPair is a background for text over an image, and the text's colour, from the image's prominent colours,
+// with their contrast ratio, from 1 to 21.
+type ColourPair struct {
+	BackgroundHex string
+	ForegroundHex string
+	ContrastRatio float64
+}
+
+// RelativeLuminance is the colour's brightness as WCAG 2.0 defines it,
+// https://www.w3.org/TR/WCAG20/#relativeluminancedef, from 0 for black to 1 for white.
+func RelativeLuminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	linear := func(v uint32) float64 {
+		s := float64(v) / 0xffff
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+// ContrastRatio is that of the two colours, https://www.w3.org/TR/WCAG20/#contrast-ratiodef, from 1 to 21, whichever is the lighter.
+func ContrastRatio(a color.Color, b color.Color) float64 {
+	la, lb := RelativeLuminance(a), RelativeLuminance(b)
+	return (math.Max(la, lb) + 0.05) / (math.Min(la, lb) + 0.05)
+}
+
+// withContrast moves the colour's lightness, keeping its hue and saturation, away from that of against,
+// until the two have minContrast, or it is black or white.
+func withContrast(c color.Color, against color.Color, minContrast float64) color.Color {
+	r, g, b, _ := c.RGBA()
+	h, s, l := sadcolor.RGBToHSL(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+
+	// lighter on a dark colour, and darker on a light one, whichever contrasts more at the extreme
+	step := lightnessStep
+	if ContrastRatio(color.Black, against) > ContrastRatio(color.White, against) {
+		step = -lightnessStep
+	}
+
+	for ContrastRatio(c, against) < minContrast && ((step > 0 && l < 1) || (step < 0 && l > 0)) {
+		l = math.Min(1, math.Max(0, l+step))
+		c = sadcolor.HSL{H: h, S: s, L: l}
+	}
+	return c
+}
+
+// toHex is the colour as e.g. #1a2b3c, as vibrant gives the prominent colours.
+func toHex(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+// RecommendColourPair picks, from an image's prominent colours, sorted by population, a background, the most prominent,
+// and text over it, as TextColourPair. Without any colours, it is black on white.
+func RecommendColourPair(palette []ProminentColour, minContrast float64) ColourPair {
+	if len(palette) == 0 {
+		return ColourPair{BackgroundHex: "#ffffff", ForegroundHex: "#000000", ContrastRatio: ContrastRatio(color.White, color.Black)}
+	}
+	return TextColourPair(palette[0].RGBHex, palette[1:], minContrast)
+}
+
+// TextColourPair picks, for text over the background, e.g. the dominant colour of the region the text is to go in,
+// the colour of the palette which contrasts with it most, made lighter or darker until the two have minContrast,
+// as do black and white on any colour for ContrastAA. Failing that, the background is made darker or lighter too.
+func TextColourPair(backgroundHex string, palette []ProminentColour, minContrast float64) ColourPair {
+	var bg color.Color = sadcolor.Hex(backgroundHex)
+	var fg color.Color = color.White
+	if ContrastRatio(color.Black, bg) > ContrastRatio(color.White, bg) {
+		fg = color.Black
+	}
+	best := 0.0
+	for _, pc := range palette {
+		c := sadcolor.Hex(pc.RGBHex)
+		if ratio := ContrastRatio(c, bg); ratio > best {
+			fg, best = c, ratio
+		}
+	}
+
+	fg = withContrast(fg, bg, minContrast)
+	if ContrastRatio(fg, bg) < minContrast {
+		bg = withContrast(bg, fg, minContrast)
+	}
+
+	return ColourPair{BackgroundHex: toHex(bg), ForegroundHex: toHex(fg), ContrastRatio: ContrastRatio(fg, bg)}
+}
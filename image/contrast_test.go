@@ -0,0 +1,76 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	sadcolor "github.com/generaltso/sadbox/color"
+)
+
+func TestContrastRatio(t *testing.T) {
+	cases := []struct {
+		a, b     color.Color
+		expected float64
+	}{
+		{color.White, color.Black, 21},
+		{color.Black, color.White, 21},
+		{color.White, color.White, 1},
+		{sadcolor.Hex("#767676"), color.White, 4.54}, // the lightest grey passing AA on white
+	}
+	for _, c := range cases {
+		if got := ContrastRatio(c.a, c.b); math.Abs(got-c.expected) > 0.01 {
+			t.Errorf("ContrastRatio(%v, %v): expected %.2f, got %.2f", c.a, c.b, c.expected, got)
+		}
+	}
+}
+
+func TestRecommendColourPair(t *testing.T) {
+	palettes := [][]ProminentColour{
+		{{Name: "Muted", RGBHex: "#777777"}, {Name: "Vibrant", RGBHex: "#888888"}},
+		{{Name: "DarkMuted", RGBHex: "#1e2a3a"}, {Name: "DarkVibrant", RGBHex: "#0a3d62"}},
+		{{Name: "LightMuted", RGBHex: "#f5e6d3"}},
+		{},
+	}
+	for _, palette := range palettes {
+		for _, minContrast := range []float64{ContrastAALarge, ContrastAA, ContrastAAA} {
+			pair := RecommendColourPair(palette, minContrast)
+			got := ContrastRatio(sadcolor.Hex(pair.BackgroundHex), sadcolor.Hex(pair.ForegroundHex))
+			if got < minContrast || math.Abs(got-pair.ContrastRatio) > 0.01 {
+				t.Errorf("%v at %.1f: expected at least that contrast, got %+v (%.2f)", palette, minContrast, pair, got)
+			}
+		}
+	}
+
+	if pair := RecommendColourPair(palettes[1], ContrastAA); pair.BackgroundHex != "#1e2a3a" {
+		t.Errorf("expected the most prominent colour to stay the background when the text alone can meet AA, got %+v", pair)
+	}
+}
+
+func TestRegionColours(t *testing.T) {
+	// a white image, red across its top third and with a busy chequer across its foot
+	m := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(m, image.Rect(0, 0, 300, 100), image.NewUniform(color.RGBA{0xcc, 0, 0, 0xff}), image.ZP, draw.Src)
+	for y := 200; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 7), uint8(y * 13), uint8(x * y), 0xff})
+		}
+	}
+
+	regions := RegionColours(m)
+	if len(regions) != regionRows*regionCols {
+		t.Fatalf("expected %d regions, got %d", regionRows*regionCols, len(regions))
+	}
+	if regions[0].Name != "top-left" || regions[0].RGBHex != "#cc0000" || regions[0].Share != 1 {
+		t.Errorf("expected a uniform red top left, got %+v", regions[0])
+	}
+	if regions[4].Name != "centre" || regions[4].RGBHex != "#ffffff" || regions[4].Luminance != 1 {
+		t.Errorf("expected a white centre, got %+v", regions[4])
+	}
+	if regions[7].Name != "bottom" || regions[7].Share > 0.5 {
+		t.Errorf("expected a busy bottom, got %+v", regions[7])
+	}
+}
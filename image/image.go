@@ -1,43 +1,113 @@
 package image
 
 import (
+        "bytes"
+        "context"
         "fmt"
         "image"
         _ "image/gif"
         _ "image/jpeg"
         _ "image/png"
-        "log"
+        "io"
+        "io/ioutil"
         "net/http"
         "sort"
+        "strings"
+        "sync"
+        "time"
         "github.com/generaltso/vibrant"
+        "github.com/nfnt/resize"
+        "github.com/railsagainstignorance/alignment/logging"
+        "github.com/railsagainstignorance/alignment/metrics"
 )
 
-var checkErr = func(err error) { 
-        if err != nil { 
-                panic(err) 
-                log.Fatal(err)
-                } 
-        }
+const (
+        maxPixels      = 40 * 1000 * 1000 // more, and decoding it would take too much memory, e.g. for a decompression bomb
+        maxPaletteSide = 200              // the image is scaled down to fit within this many pixels square before its colours are found
+)
+
+// the limits on fetching an image, set by SetLimits
+var (
+        client   = &http.Client{Timeout: 10 * time.Second}
+        maxBytes = 10 << 20
+)
+
+// SetLimits sets how long fetching an image may take, and how large, in bytes, it may be, before any are fetched.
+func SetLimits(timeout time.Duration, size int) {
+        client   = &http.Client{Timeout: timeout}
+        maxBytes = size
+}
 
-func getDecodedImageByUrl(url string) *image.Image {
-        fmt.Println("image: getDecodedImageByUrl: url=", url)
+var imageFetches = metrics.NewCounter("alignment_image_fetches_total", "Images fetched, for their colours or cards, by result (ok, or why not: error, status, type, size or decode).", "result")
+
+// getDecodedImageByUrl fetches and decodes the image at url, within the timeout and size set by SetLimits,
+// as long as it says it is an image, and isn't too big to decode.
+func getDecodedImageByUrl(ctx context.Context, url string) (image.Image, error) {
+        defer logging.Time(ctx, "image")()
+
+        fail := func(result string, format string, args ...interface{}) (image.Image, error) {
+                imageFetches.With(result).Inc()
+                return nil, fmt.Errorf("image: getDecodedImageByUrl: %s: " + format, append([]interface{}{url}, args...)...)
+        }
 
         req, err := http.NewRequest("GET", url, nil)
-        client := &http.Client{}
-        resp, err := client.Do(req)
-        checkErr( err )
+        if err != nil {
+                return fail("error", "%v", err)
+        }
+        resp, err := client.Do(req.WithContext(ctx))
+        if err != nil {
+                return fail("error", "%v", err)
+        }
         defer resp.Body.Close()
 
-        fmt.Println("image: getDecodedImageByUrl: response Status:", resp.Status)
+        logging.FromContext(ctx).Debug("image: getDecodedImageByUrl", "url", url, "status", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"), "contentLength", resp.ContentLength)
+
+        if resp.StatusCode != http.StatusOK {
+                return fail("status", "status %d", resp.StatusCode)
+        }
+        if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(strings.ToLower(contentType), "image/") {
+                return fail("type", "not an image, but %q", contentType)
+        }
+        if resp.ContentLength > int64(maxBytes) {
+                return fail("size", "%d bytes, more than %d", resp.ContentLength, maxBytes)
+        }
+
+        // one more than allowed, to tell if there were more, as the Content-Length may not have said
+        body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxBytes) + 1))
+        if err != nil {
+                return fail("error", "%v", err)
+        }
+        if len(body) > maxBytes {
+                return fail("size", "more than %d bytes", maxBytes)
+        }
+
+        config, _, err := image.DecodeConfig(bytes.NewReader(body))
+        if err != nil {
+                return fail("decode", "%v", err)
+        }
+        if config.Width * config.Height > maxPixels {
+                return fail("size", "%dx%d, more than %d pixels", config.Width, config.Height, maxPixels)
+        }
+
+        m, _, err := image.Decode(bytes.NewReader(body))
+        if err != nil {
+                return fail("decode", "%v", err)
+        }
+        imageFetches.With("ok").Inc()
+        return m, nil
+}
 
-        m, _, err := image.Decode(resp.Body)
-        checkErr( err )
-        return &m
+// GetImage is the decoded image at url, or an error, e.g. for the cards, which can do without.
+func GetImage(ctx context.Context, url string) (image.Image, error) {
+        return getDecodedImageByUrl( ctx, url )
 }
 
 // taken from https://gist.github.com/tristanwietsma/c552e838f21f6fbb5800
 func calcHistogram(url string) *[16][4]int {
-        m := *getDecodedImageByUrl( url )
+        m, err := getDecodedImageByUrl( context.Background(), url )
+        if err != nil {
+                return nil
+        }
         bounds := m.Bounds()
 
         var histogram [16][4]int
@@ -75,7 +145,10 @@ func (s ByCount) Less(i, j int) bool {
 }
 
 func calcColourFrequencies(url string) *[]ColourStat {
-        m := *getDecodedImageByUrl( url )
+        m, err := getDecodedImageByUrl( context.Background(), url )
+        if err != nil {
+                return nil
+        }
         bounds := m.Bounds()
 
         var colourCounts = make(map[string]int)
@@ -129,62 +202,92 @@ func (s ByPopulation) Less(i, j int) bool {
         return s[i].Population > s[j].Population
 }
 
-var imgProminentColoursCache = map[string]*[]ProminentColour{}
-
-// via https://github.com/generaltso/vibrant
-func GetProminentColours(url string) *[]ProminentColour {
-    var prominentColours *[]ProminentColour
-    
-    if _, ok := imgProminentColoursCache[url]; ok {
-        fmt.Println("image.GetProminentColours: cache hit: url=", url)
-        prominentColours = imgProminentColoursCache[url]
-    } else {
-        fmt.Println("image.GetProminentColours: cache miss: url=", url)
+// at most this many images' colours are cached, beyond which the oldest are dropped
+const maxCachedColours = 1000
 
-        prominentColours = &([]ProminentColour {})
-        img := *getDecodedImageByUrl( url )
+// the colours by image url, shared by the request handlers and cache refreshes, so behind a lock
+var (
+        imgProminentColoursCache      = map[string]*[]ProminentColour{}
+        imgProminentColoursCacheOrder = []string{} // the urls cached, oldest first
+        imgProminentColoursCacheMutex sync.RWMutex
+)
 
-        palette, err := vibrant.NewPaletteFromImage(img)
-        checkErr(err)
+func getCachedColours(url string) (*[]ProminentColour, bool) {
+        imgProminentColoursCacheMutex.RLock()
+        defer imgProminentColoursCacheMutex.RUnlock()
+        prominentColours, ok := imgProminentColoursCache[url]
+        return prominentColours, ok
+}
 
-        swatches := palette.ExtractAwesome()
+// cacheColours caches the image's colours, dropping the oldest beyond maxCachedColours.
+func cacheColours(url string, prominentColours *[]ProminentColour) {
+        imgProminentColoursCacheMutex.Lock()
+        defer imgProminentColoursCacheMutex.Unlock()
 
-        for name, swatch := range swatches {
-          // fmt.Printf("/* %s (population: %d) */\n%s\n\n", name, swatch.Population, swatch)
-          prominentColour := ProminentColour{
-                Name:       name,
-                Population: swatch.Population,
-                RGBHex:     swatch.Color.RGBHex(),
-          }
+        if _, ok := imgProminentColoursCache[url]; !ok {
+                imgProminentColoursCacheOrder = append(imgProminentColoursCacheOrder, url)
+        }
+        imgProminentColoursCache[url] = prominentColours
 
-          *prominentColours = append( *prominentColours, prominentColour )
+        for len(imgProminentColoursCacheOrder) > maxCachedColours {
+                delete(imgProminentColoursCache, imgProminentColoursCacheOrder[0])
+                imgProminentColoursCacheOrder = imgProminentColoursCacheOrder[1:]
         }
+}
 
-        sort.Sort(ByPopulation(*prominentColours))
+var cacheRequests = metrics.NewCounter("alignment_cache_requests_total", "Lookups in the in-memory caches, by cache and result (hit, miss or stale).", "cache", "result")
 
-        imgProminentColoursCache[url] = prominentColours
+// GetProminentColours is the prominent colours of the image at url, as ProminentColoursOf,
+// or none if it can't be had, e.g. being too big, which isn't cached, so is tried again next time.
+func GetProminentColours(ctx context.Context, url string) *[]ProminentColour {
+    logger := logging.FromContext(ctx)
+    
+    prominentColours, ok := getCachedColours(url)
+    if ok {
+        logger.Debug("image: GetProminentColours: cache hit", "url", url)
+        cacheRequests.With("colour", "hit").Inc()
+    } else {
+        logger.Info("image: GetProminentColours: cache miss", "url", url)
+        cacheRequests.With("colour", "miss").Inc()
+
+        img, err := getDecodedImageByUrl( ctx, url )
+        if err == nil {
+                prominentColours, err = ProminentColoursOf( img )
+        }
+        if err != nil {
+                logger.Warn("image: GetProminentColours: could not find the colours, so leaving them out", "url", url, "err", err)
+                return &([]ProminentColour {})
+        }
+
+        cacheColours(url, prominentColours)
     }
 
     return prominentColours
 }
 
-func main() {
-        url := "http://im.ft-static.com/content/images/2a7f93c1-0276-4e3e-992d-d14a60bf60f4.img"
+// ProminentColoursOf is the image's prominent colours, sorted by population, via https://github.com/generaltso/vibrant,
+// found in a copy scaled down to maxPaletteSide, as they don't need every pixel.
+func ProminentColoursOf(img image.Image) (*[]ProminentColour, error) {
+    prominentColours := &([]ProminentColour {})
 
-        // histogram := calcHistogram(url)
-        // // Print the results.
-        // fmt.Printf("%-14s %6s %6s %6s %6s\n", "bin", "red", "green", "blue", "alpha")
-        // for i, x := range histogram {
-        //         fmt.Printf("0x%04x-0x%04x: %6d %6d %6d %6d\n", i<<12, (i+1)<<12-1, x[0], x[1], x[2], x[3])
-        // }
+    palette, err := vibrant.NewPaletteFromImage( resize.Thumbnail(maxPaletteSide, maxPaletteSide, img, resize.Bilinear) )
+    if err != nil {
+        return prominentColours, err
+    }
+
+    swatches := palette.ExtractAwesome()
+
+    for name, swatch := range swatches {
+      prominentColour := ProminentColour{
+            Name:       name,
+            Population: swatch.Population,
+            RGBHex:     swatch.Color.RGBHex(),
+      }
+
+      *prominentColours = append( *prominentColours, prominentColour )
+    }
 
-        // colourStats := calcColourFrequencies( url )
-        // for i,c := range *colourStats{
-        //         fmt.Printf("%3d) %s %5.2f %6d\n", i, c.RgbaCsv, c.Percentage, c.Count)
-        // }
+    sort.Sort(ByPopulation(*prominentColours))
 
-        prominentColours1 := GetProminentColours( url )
-        fmt.Println( "image: main: prominentColours1=", prominentColours1)      
-        prominentColours2 := GetProminentColours( url )
-        fmt.Println( "image: main: prominentColours2=", prominentColours2)      
+    return prominentColours, nil
 }
\ No newline at end of file
@@ -0,0 +1,94 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPng(t *testing.T, width int, height int) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(m, image.Rect(0, 0, width, height/2), image.NewUniform(color.RGBA{0x1e, 0x90, 0xff, 0xff}), image.ZP, draw.Src)
+	draw.Draw(m, image.Rect(0, height/2, width, height), image.NewUniform(color.RGBA{0xff, 0x8c, 0x00, 0xff}), image.ZP, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetImage(t *testing.T) {
+	photo := testPng(t, 800, 600)
+
+	// a small png whose header says it is 100,000 pixels square
+	bomb := testPng(t, 1, 1)
+	binary.BigEndian.PutUint32(bomb[16:], 100000)
+	binary.BigEndian.PutUint32(bomb[20:], 100000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(photo)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(photo)
+	})
+	mux.HandleFunc("/huge.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0}, 2<<20))
+	})
+	mux.HandleFunc("/corrupt.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(photo[:100])
+	})
+	mux.HandleFunc("/bomb.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bomb)
+	})
+	mux.HandleFunc("/slow.png", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(photo)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	SetLimits(200*time.Millisecond, 1<<20)
+	defer SetLimits(10*time.Second, 10<<20)
+
+	m, err := GetImage(context.Background(), server.URL+"/photo.png")
+	if err != nil || m.Bounds().Dx() != 800 {
+		t.Fatalf("expected the photo, got %v, %v", m, err)
+	}
+
+	for path, expected := range map[string]string{
+		"/missing.png": "status 404",
+		"/page.html":   "not an image",
+		"/huge.png":    "more than 1048576 bytes",
+		"/corrupt.png": "png: ",
+		"/bomb.png":    "more than 40000000 pixels",
+		"/slow.png":    "Timeout",
+	} {
+		if _, err := GetImage(context.Background(), server.URL+path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error saying %q, got %v", path, expected, err)
+		}
+	}
+
+	if colours := GetProminentColours(context.Background(), server.URL+"/page.html"); len(*colours) != 0 {
+		t.Errorf("expected no colours for an image which can't be had, got %v", *colours)
+	}
+	if colours := GetProminentColours(context.Background(), server.URL+"/photo.png"); len(*colours) == 0 || (*colours)[0].RGBHex == "" {
+		t.Errorf("expected the photo's colours, got %v", *colours)
+	}
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"image"
+	"image/color"
+)
+
+// The image is divided into a grid of regions, three by three, for deciding where over it text would best go.
+const (
+	regionRows = 3
+	regionCols = 3
+	// at most this many pixels, across and down, are sampled in each region, as its colours don't need every one
+	maxRegionSamples = 64
+)
+
+var regionNames = [regionRows][regionCols]string{
+	{"top-left", "top", "top-right"},
+	{"left", "centre", "right"},
+	{"bottom-left", "bottom", "bottom-right"},
+}
+
+// RegionColour is the dominant colour of a region of an image, e.g. "top-left" or "centre",
+// with the share of the region's pixels of about that colour, from 0 to 1, so the more uniform the region the higher,
+// and its relative luminance.
+type RegionColour struct {
+	Name      string
+	Row       int
+	Col       int
+	RGBHex    string
+	Share     float64
+	Luminance float64
+}
+
+type colourTotal struct {
+	count   int
+	r, g, b uint64
+}
+
+// dominantColour is the average of the region's sampled pixels in its most common colour, with its share of them,
+// the colours being quantised to 4 bits a channel, as calcColourFrequencies does, so near shades count together.
+func dominantColour(m image.Image, region image.Rectangle) (color.Color, float64) {
+	stepX := region.Dx()/maxRegionSamples + 1
+	stepY := region.Dy()/maxRegionSamples + 1
+
+	totals := map[uint32]*colourTotal{}
+	samples := 0
+	var dominant *colourTotal
+	for y := region.Min.Y; y < region.Max.Y; y += stepY {
+		for x := region.Min.X; x < region.Max.X; x += stepX {
+			r, g, b, _ := m.At(x, y).RGBA()
+			key := (r>>12)<<8 | (g>>12)<<4 | b>>12
+			t, ok := totals[key]
+			if !ok {
+				t = &colourTotal{}
+				totals[key] = t
+			}
+			t.count++
+			t.r += uint64(r >> 8)
+			t.g += uint64(g >> 8)
+			t.b += uint64(b >> 8)
+			samples++
+			if dominant == nil || t.count > dominant.count {
+				dominant = t
+			}
+		}
+	}
+
+	if dominant == nil {
+		return color.Black, 0
+	}
+	n := uint64(dominant.count)
+	return color.RGBA{uint8(dominant.r / n), uint8(dominant.g / n), uint8(dominant.b / n), 0xff}, float64(dominant.count) / float64(samples)
+}
+
+// RegionColours is the dominant colour of each region of the image, row by row, from the top left.
+func RegionColours(m image.Image) []RegionColour {
+	bounds := m.Bounds()
+	regions := []RegionColour{}
+	for row := 0; row < regionRows; row++ {
+		for col := 0; col < regionCols; col++ {
+			region := image.Rect(
+				bounds.Min.X+col*bounds.Dx()/regionCols,
+				bounds.Min.Y+row*bounds.Dy()/regionRows,
+				bounds.Min.X+(col+1)*bounds.Dx()/regionCols,
+				bounds.Min.Y+(row+1)*bounds.Dy()/regionRows,
+			)
+			c, share := dominantColour(m, region)
+			regions = append(regions, RegionColour{
+				Name:      regionNames[row][col],
+				Row:       row,
+				Col:       col,
+				RGBHex:    toHex(c),
+				Share:     share,
+				Luminance: RelativeLuminance(c),
+			})
+		}
+	}
+	return regions
+}
@@ -0,0 +1,485 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/curation"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/rhyme"
+	"github.com/railsagainstignorance/alignment/scoring"
+)
+
+const (
+	SourceSearch   = "search"    // poll SAPI for articles published after the checkpoint, oldest first
+	SourceNewsFeed = "news-feed" // poll the news-feed page for its latest articles
+)
+
+const (
+	newsFeedUrl        = "http://www.ft.com/news-feed"
+	sapiDateFormat     = "2006-01-02T15:04:05Z"
+	candidatesFilename = "candidates.jsonl"
+	checkpointFilename = "checkpoint.json"
+	maxCheckpointUuids = 1000
+)
+
+// Candidate is a haiku found by the ingester, one per line of the candidates file.
+// Its Id matches the curation.CandidateId of the same haiku, so it can be fed straight into the curation store.
+type Candidate struct {
+	Id            string
+	Uuid          string
+	Url           string
+	Title         string
+	Author        string
+	ImageUrl      string
+	PubDateString string
+	Text          string
+	Lines         []string
+	Score         float64
+	DateFound     string
+}
+
+// Checkpoint records how far the ingester has got, and is saved after every article so a restart resumes from there.
+type Checkpoint struct {
+	PubDate       *time.Time // of the most recently published article processed so far
+	Uuids         []string   // the articles most recently processed, so those published at the same time are not processed twice
+	NumArticles   int
+	NumCandidates int
+}
+
+// isNew reports whether the article is published (or republished) after the checkpoint,
+// or at the same time (or undated) but not yet processed.
+func (cp *Checkpoint) isNew(a *content.Article) bool {
+	if a.Uuid == "" {
+		return false
+	}
+	if cp.PubDate == nil || (a.PubDate != nil && a.PubDate.After(*cp.PubDate)) {
+		return true
+	}
+	for _, uuid := range cp.Uuids {
+		if uuid == a.Uuid {
+			return false
+		}
+	}
+	return a.PubDate == nil || !a.PubDate.Before(*cp.PubDate)
+}
+
+func (cp *Checkpoint) advance(a *content.Article, numCandidates int) {
+	if a.PubDate != nil && (cp.PubDate == nil || a.PubDate.After(*cp.PubDate)) {
+		pubDate := *a.PubDate
+		cp.PubDate = &pubDate
+	}
+	cp.Uuids = append(cp.Uuids, a.Uuid)
+	if len(cp.Uuids) > maxCheckpointUuids {
+		cp.Uuids = cp.Uuids[len(cp.Uuids)-maxCheckpointUuids:]
+	}
+	cp.NumArticles++
+	cp.NumCandidates = cp.NumCandidates + numCandidates
+}
+
+// Status is a snapshot of the ingester's progress, for the status endpoint.
+type Status struct {
+	Running          bool
+	Source           string
+	PollInterval     string
+	NumPolls         int
+	LastPollStarted  string
+	LastPollFinished string
+	LastError        string
+	NumArticles      int    // processed since the very first poll, across restarts
+	NumCandidates    int    // found since the very first poll, across restarts
+	CheckpointDate   string // publication date of the most recent article processed
+	LagSeconds       int    // how far behind now the checkpoint is
+	NumPending       int    // articles known to be waiting, as of the last poll
+}
+
+// Ingester periodically fetches new articles, runs them through the haiku detector, and appends any candidates to a local file.
+type Ingester struct {
+	syllabi     *rhyme.Syllabi
+	source      string
+	dir         string
+	interval    time.Duration
+	maxArticles int
+
+	mutex      sync.Mutex
+	checkpoint Checkpoint
+	status     Status
+	polling    sync.Mutex
+	stop       chan struct{}
+	stopped    bool // so a poll in progress gives up between articles
+}
+
+// New creates an ingester keeping its candidates and checkpoint in dir, resuming from any checkpoint already there.
+// If there is no checkpoint, the first poll of SourceSearch goes back as far as backfill.
+func New(syllabi *rhyme.Syllabi, source string, dir string, interval time.Duration, maxArticles int, backfill time.Duration) *Ingester {
+	ing := Ingester{
+		syllabi:     syllabi,
+		source:      source,
+		dir:         dir,
+		interval:    interval,
+		maxArticles: maxArticles,
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logging.Default().Warn("ingest: New: could not create dir", "dir", dir, "err", err)
+	}
+
+	jsonBody, err := ioutil.ReadFile(filepath.Join(dir, checkpointFilename))
+	if err == nil {
+		if err := json.Unmarshal(jsonBody, &ing.checkpoint); err != nil {
+			logging.Default().Warn("ingest: New: could not parse checkpoint", "dir", dir, "err", err)
+		}
+	}
+
+	if ing.checkpoint.PubDate == nil {
+		start := time.Now().Add(-backfill)
+		ing.checkpoint.PubDate = &start
+		logging.Default().Info("ingest: New: no checkpoint", "from", start.Format(time.RFC3339))
+	} else {
+		logging.Default().Info("ingest: New: resuming from checkpoint", "from", ing.checkpoint.PubDate.Format(time.RFC3339), "numArticles", ing.checkpoint.NumArticles)
+	}
+
+	ing.status.Source = source
+	ing.status.PollInterval = interval.String()
+
+	return &ing
+}
+
+// Start polls immediately and then every interval, in the background, until Stop is called.
+func (ing *Ingester) Start() {
+	ing.mutex.Lock()
+	if ing.stop != nil {
+		ing.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	ing.stop = stop
+	ing.stopped = false
+	ing.status.Running = true
+	ing.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ing.interval)
+		defer ticker.Stop()
+		for {
+			ing.Poll()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the background polling, after any poll in progress.
+func (ing *Ingester) Stop() {
+	ing.mutex.Lock()
+	if ing.stop != nil {
+		close(ing.stop)
+		ing.stop = nil
+	}
+	ing.stopped = true
+	ing.status.Running = false
+	ing.mutex.Unlock()
+
+	ing.polling.Lock()
+	ing.polling.Unlock()
+}
+
+func (ing *Ingester) Status() *Status {
+	ing.mutex.Lock()
+	defer ing.mutex.Unlock()
+
+	status := ing.status
+	status.NumArticles = ing.checkpoint.NumArticles
+	status.NumCandidates = ing.checkpoint.NumCandidates
+	if ing.checkpoint.PubDate != nil {
+		status.CheckpointDate = ing.checkpoint.PubDate.Format(time.RFC3339)
+		status.LagSeconds = int(time.Since(*ing.checkpoint.PubDate).Seconds())
+	}
+	return &status
+}
+
+var ErrUnknownSource = errors.New("ingest: unknown source")
+
+// search finds the articles to consider next, oldest first, along with how many more are known to be waiting.
+func (ing *Ingester) search(ctx context.Context) (*[]*content.Article, int, error) {
+	ing.mutex.Lock()
+	from := *ing.checkpoint.PubDate
+	ing.mutex.Unlock()
+
+	var sRequest *content.SearchRequest
+	switch ing.source {
+	case SourceSearch:
+		sRequest = &content.SearchRequest{
+			QueryType:     "after",
+			QueryText:     from.UTC().Format(sapiDateFormat),
+			MaxArticles:   ing.maxArticles,
+			SearchOnly:    true,
+			SortAscending: true,
+		}
+	case SourceNewsFeed:
+		sRequest = &content.SearchRequest{
+			QueryType:   "pages",
+			QueryText:   newsFeedUrl,
+			MaxArticles: ing.maxArticles,
+			SearchOnly:  true,
+		}
+	default:
+		return nil, 0, ErrUnknownSource
+	}
+
+	sResponse, err := content.Search(ctx, sRequest)
+	if err != nil {
+		return nil, 0, err
+	}
+	if sResponse.Articles == nil {
+		return nil, 0, errors.New("ingest: no search response")
+	}
+
+	articles := []*content.Article{}
+	for _, a := range *sResponse.Articles {
+		if a != nil {
+			articles = append(articles, a)
+		}
+	}
+	sort.Stable(byPubDate(articles))
+
+	numPending := 0
+	if sResponse.NumPossible > sResponse.NumArticles {
+		numPending = sResponse.NumPossible - sResponse.NumArticles
+	}
+
+	return &articles, numPending, nil
+}
+
+type byPubDate []*content.Article
+
+func (as byPubDate) Len() int      { return len(as) }
+func (as byPubDate) Swap(i, j int) { as[i], as[j] = as[j], as[i] }
+func (as byPubDate) Less(i, j int) bool {
+	if as[i].PubDate == nil || as[j].PubDate == nil {
+		return as[j].PubDate == nil && as[i].PubDate != nil
+	}
+	return as[i].PubDate.Before(*as[j].PubDate)
+}
+
+// Poll processes any new articles, oldest first, saving the checkpoint after each.
+// A candidate can be appended twice if the process dies between writing it and saving the checkpoint,
+// so readers of the candidates file should treat Id as a key.
+func (ing *Ingester) Poll() error {
+	ing.polling.Lock()
+	defer ing.polling.Unlock()
+
+	start := time.Now()
+	ing.mutex.Lock()
+	ing.status.NumPolls++
+	numPoll := ing.status.NumPolls
+	ing.status.LastPollStarted = start.Format(time.RFC3339)
+	ing.mutex.Unlock()
+
+	logger := logging.Default().With("ingestPoll", numPoll, "source", ing.source)
+	ctx, timings := logging.WithTimings(logging.NewContext(context.Background(), logger))
+
+	numProcessed, numPending, err := ing.safeIngest(ctx)
+
+	ing.mutex.Lock()
+	ing.status.LastPollFinished = time.Now().Format(time.RFC3339)
+	ing.status.NumPending = numPending
+	ing.status.LastError = ""
+	if err != nil {
+		ing.status.LastError = err.Error()
+	}
+	ing.mutex.Unlock()
+
+	fields := append([]interface{}{"numProcessed", numProcessed, "numPending", numPending, "millis", time.Since(start).Nanoseconds() / 1e6}, timings.Fields()...)
+	if err != nil {
+		logger.Warn("ingest: Poll: failed", append(fields, "err", err)...)
+	} else {
+		logger.Info("ingest: Poll: done", fields...)
+	}
+
+	return err
+}
+
+// safeIngest searches for new articles and processes them, turning a panic, e.g. of a failed SAPI or CAPI call,
+// into the poll failing, rather than the server dying, as the poll runs in the background.
+func (ing *Ingester) safeIngest(ctx context.Context) (numProcessed int, numPending int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ingest: poll panicked: %v", r)
+		}
+	}()
+
+	articles, numPending, err := ing.search(ctx)
+	if err != nil {
+		return 0, numPending, err
+	}
+
+	for _, a := range *articles {
+		ing.mutex.Lock()
+		isNew := ing.checkpoint.isNew(a)
+		stopped := ing.stopped
+		ing.mutex.Unlock()
+
+		if stopped {
+			break
+		}
+		if !isNew {
+			continue
+		}
+
+		if err = ing.processArticle(ctx, a); err != nil {
+			break
+		}
+		numProcessed++
+	}
+	return numProcessed, numPending, err
+}
+
+func (ing *Ingester) processArticle(ctx context.Context, a *content.Article) error {
+	// the news-feed's dates are not the same as those in the index, so it can't be used to spot republished articles
+	pubDateString := a.PubDateString
+	if ing.source == SourceNewsFeed {
+		pubDateString = ""
+	}
+
+	awsam := article.GetArticleWithSentencesAndMeterPublishedAt(ctx, a.Uuid, pubDateString, rhyme.HaikuMeter, ing.syllabi)
+	candidates := ing.findCandidates(awsam)
+
+	if err := appendCandidates(filepath.Join(ing.dir, candidatesFilename), candidates); err != nil {
+		return err
+	}
+
+	ing.mutex.Lock()
+	defer ing.mutex.Unlock()
+
+	ing.checkpoint.advance(a, len(*candidates))
+	return ing.saveCheckpoint()
+}
+
+// findCandidates keeps the haiku which don't end any line on a weak word, as the /ontology page does.
+func (ing *Ingester) findCandidates(awsam *article.ArticleWithSentencesAndMeter) *[]*Candidate {
+	candidates := []*Candidate{}
+	scorer := scoring.NewScorer(*awsam.Sentences, ing.syllabi.PhraseWordsRegexp)
+	now := time.Now().Format(time.RFC3339)
+
+	for _, ram := range *awsam.MatchedPhrases {
+		sm := ram.MatchesOnMeter.SecondaryMatch
+		if sm == nil {
+			continue
+		}
+
+		isBadEnd := false
+		for _, w := range *sm.FinalWordWordInEachMatch {
+			if w == nil || w.IsBadEnd {
+				isBadEnd = true
+				break
+			}
+		}
+		if isBadEnd {
+			continue
+		}
+
+		lines := *sm.PhraseInEachMatch
+		text := strings.Join(lines, "\n")
+		candidates = append(candidates, &Candidate{
+			Id:            curation.CandidateId(awsam.SiteUrl, text),
+			Uuid:          awsam.Uuid,
+			Url:           awsam.SiteUrl,
+			Title:         awsam.Title,
+			Author:        awsam.Author,
+			ImageUrl:      awsam.ImageUrl,
+			PubDateString: awsam.PubDateString,
+			Text:          text,
+			Lines:         lines,
+			Score:         scorer.ScoreRhymeAndMeter(ram).Total,
+			DateFound:     now,
+		})
+	}
+
+	return &candidates
+}
+
+func appendCandidates(filename string, candidates *[]*Candidate) error {
+	if len(*candidates) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	for _, c := range *candidates {
+		if err := encoder.Encode(c); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// saveCheckpoint assumes the mutex is held
+func (ing *Ingester) saveCheckpoint() error {
+	jsonBody, err := json.Marshal(ing.checkpoint)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(ing.dir, checkpointFilename)
+	tmpFilename := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, jsonBody, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// ReadCandidates returns the candidates in the ingester's file, oldest first, skipping any repeats and unparseable lines.
+func (ing *Ingester) ReadCandidates() (*[]*Candidate, error) {
+	return readCandidates(filepath.Join(ing.dir, candidatesFilename))
+}
+
+func readCandidates(filename string) (*[]*Candidate, error) {
+	candidates := []*Candidate{}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return &candidates, nil
+	} else if err != nil {
+		return &candidates, err
+	}
+	defer f.Close()
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		c := Candidate{}
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil || c.Id == "" {
+			logging.Default().Warn("ingest: readCandidates: skipping unparseable line", "filename", filename, "err", err)
+			continue
+		}
+		if !seen[c.Id] {
+			seen[c.Id] = true
+			candidates = append(candidates, &c)
+		}
+	}
+
+	return &candidates, scanner.Err()
+}
@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/content"
+)
+
+func articleAt(uuid string, pubDate time.Time) *content.Article {
+	return &content.Article{Uuid: uuid, PubDate: &pubDate}
+}
+
+func TestCheckpoint(t *testing.T) {
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	cp := Checkpoint{PubDate: &start}
+
+	older := articleAt("older", start.Add(-time.Minute))
+	same := articleAt("same", start)
+	newer := articleAt("newer", start.Add(time.Minute))
+
+	if cp.isNew(older) {
+		t.Error("expected an article published before the checkpoint not to be new")
+	}
+	if !cp.isNew(same) || !cp.isNew(newer) {
+		t.Error("expected articles published at or after the checkpoint to be new")
+	}
+
+	cp.advance(same, 2)
+	if cp.isNew(same) {
+		t.Error("expected a processed article not to be new")
+	}
+
+	cp.advance(newer, 1)
+	if !cp.PubDate.Equal(*newer.PubDate) {
+		t.Errorf("expected checkpoint to advance to %s, got %s", newer.PubDate, cp.PubDate)
+	}
+	if cp.NumArticles != 2 || cp.NumCandidates != 3 {
+		t.Errorf("expected 2 articles and 3 candidates, got %d and %d", cp.NumArticles, cp.NumCandidates)
+	}
+
+	republished := articleAt("same", start.Add(time.Hour))
+	if !cp.isNew(republished) {
+		t.Error("expected a processed article republished after the checkpoint to be new")
+	}
+}
+
+func TestCandidatesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, candidatesFilename)
+
+	candidates, err := readCandidates(filename)
+	if err != nil || len(*candidates) != 0 {
+		t.Fatalf("expected no candidates from a missing file, got %d, err=%v", len(*candidates), err)
+	}
+
+	first := []*Candidate{{Id: "a", Text: "one"}, {Id: "b", Text: "two"}}
+	second := []*Candidate{{Id: "a", Text: "one"}, {Id: "c", Text: "three"}}
+	if err := appendCandidates(filename, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendCandidates(filename, &second); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err = readCandidates(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for _, c := range *candidates {
+		ids = ids + c.Id
+	}
+	if ids != "abc" {
+		t.Errorf("expected candidates abc, oldest first without repeats, got %s", ids)
+	}
+}
@@ -0,0 +1,461 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/api"
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/ontology"
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+const (
+	DefaultMaxArticles = 1000
+	DefaultMaxMillis   = 60 * 60 * 1000
+	MaxMaxMillis       = 6 * 60 * 60 * 1000
+
+	jobFileSuffix = ".json"
+	saveInterval  = 2 * time.Second // between saves of a running job's progress
+)
+
+// Request describes a scan, as for the /ontology page but without its limits.
+// Form, if set, names one of the api.Forms and takes precedence over Meter.
+type Request struct {
+	Ontology    string `json:"ontology"`
+	Value       string `json:"value"`
+	Meter       string `json:"meter,omitempty"`
+	Form        string `json:"form,omitempty"`
+	MaxArticles int    `json:"maxArticles"`
+	MaxMillis   int    `json:"maxMillis"`
+}
+
+var (
+	ErrNoQuery     = errors.New("jobs: ontology and value are required")
+	ErrUnknownForm = errors.New("jobs: unknown form")
+	ErrNotFound    = errors.New("jobs: no such job")
+	ErrFinished    = errors.New("jobs: job has already finished")
+	ErrNotDone     = errors.New("jobs: job has no results yet")
+)
+
+// normalise fills in the defaults, caps the limits, and resolves the Form into a Meter.
+func (req *Request) normalise() error {
+	if req.Ontology == "" || req.Value == "" {
+		return ErrNoQuery
+	}
+	if req.Form != "" {
+		meter, ok := api.Forms[strings.ToLower(req.Form)]
+		if !ok {
+			return ErrUnknownForm
+		}
+		req.Meter = meter
+	}
+
+	if req.MaxArticles < 1 {
+		req.MaxArticles = DefaultMaxArticles
+	} else if req.MaxArticles > ontology.MaxArchiveArticles {
+		req.MaxArticles = ontology.MaxArchiveArticles
+	}
+
+	if req.MaxMillis < 1 {
+		req.MaxMillis = DefaultMaxMillis
+	} else if req.MaxMillis > MaxMaxMillis {
+		req.MaxMillis = MaxMaxMillis
+	}
+
+	return nil
+}
+
+// Job is a scan and its progress. Partial holds the (unranked) matches found so far while it runs,
+// and Result the ranked results once it is done.
+type Job struct {
+	Id            string              `json:"id"`
+	Request       Request             `json:"request"`
+	Status        string              `json:"status"`
+	DateSubmitted string              `json:"dateSubmitted"`
+	DateStarted   string              `json:"dateStarted,omitempty"`
+	DateFinished  string              `json:"dateFinished,omitempty"`
+	NumDone       int                 `json:"numDone"`
+	NumTotal      int                 `json:"numTotal"`
+	Error         string              `json:"error,omitempty"`
+	Partial       []*api.ArticleMatch `json:"partial,omitempty"`
+	Result        *api.OntologyResult `json:"result,omitempty"`
+}
+
+func (j *Job) isFinished() bool {
+	return j.Status == StatusDone || j.Status == StatusCancelled || j.Status == StatusFailed
+}
+
+// summary is a copy of the job without its matches, for listing.
+func (j *Job) summary() *Job {
+	copied := *j
+	copied.Partial = nil
+	copied.Result = nil
+	return &copied
+}
+
+// ScanFunc runs a scan, reporting each article to progress, and stopping early if ctx is cancelled.
+type ScanFunc func(ctx context.Context, req *Request, progress article.ArticleProgress) (*api.OntologyResult, error)
+
+// OntologyScan is the ScanFunc which scans articles with ontology.GetArchiveDetails.
+func OntologyScan(syllabi *rhyme.Syllabi) ScanFunc {
+	return func(ctx context.Context, req *Request, progress article.ArticleProgress) (*api.OntologyResult, error) {
+		details, _ := ontology.GetArchiveDetails(ctx, syllabi, req.Ontology, req.Value, req.Meter, req.MaxArticles, req.MaxMillis, progress)
+		return api.NewOntologyResult(details), nil
+	}
+}
+
+// Manager runs the submitted jobs in the background, a few at a time, persisting each as a JSON file in dir.
+// Jobs which were queued or running when the process stopped are run again (from scratch) when it restarts.
+type Manager struct {
+	dir        string
+	scan       ScanFunc
+	numWorkers int
+
+	mutex    sync.Mutex
+	jobs     map[string]*Job
+	cancels  map[string]context.CancelFunc
+	stopping bool
+	wake     chan struct{}
+	done     chan struct{}
+	workers  sync.WaitGroup
+}
+
+func NewManager(dir string, scan ScanFunc, numWorkers int) *Manager {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	m := Manager{
+		dir:        dir,
+		scan:       scan,
+		numWorkers: numWorkers,
+		jobs:       map[string]*Job{},
+		cancels:    map[string]context.CancelFunc{},
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logging.Default().Warn("jobs: NewManager: could not create dir", "dir", dir, "err", err)
+		return &m
+	}
+
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*"+jobFileSuffix))
+	for _, filename := range filenames {
+		jsonBody, err := ioutil.ReadFile(filename)
+		if err != nil {
+			logging.Default().Warn("jobs: NewManager: could not read job", "filename", filename, "err", err)
+			continue
+		}
+		job := Job{}
+		if err := json.Unmarshal(jsonBody, &job); err != nil || job.Id == "" {
+			logging.Default().Warn("jobs: NewManager: could not parse job", "filename", filename, "err", err)
+			continue
+		}
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+			job.NumDone = 0
+			job.Partial = nil
+		}
+		m.jobs[job.Id] = &job
+	}
+
+	logging.Default().Info("jobs: NewManager: loaded", "numJobs", len(m.jobs), "dir", dir)
+	return &m
+}
+
+func (m *Manager) Start() {
+	for i := 0; i < m.numWorkers; i++ {
+		m.workers.Add(1)
+		go m.work()
+	}
+	m.signal()
+}
+
+// Stop cancels the running jobs, leaving them queued to be run again on restart, and waits for the workers to finish.
+func (m *Manager) Stop() {
+	m.mutex.Lock()
+	m.stopping = true
+	for _, cancel := range m.cancels {
+		cancel()
+	}
+	m.mutex.Unlock()
+
+	close(m.done)
+	m.workers.Wait()
+}
+
+// signal wakes an idle worker, if there is one, to look for a queued job
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *Manager) Submit(req Request) (*Job, error) {
+	if err := req.normalise(); err != nil {
+		return nil, err
+	}
+
+	job := Job{
+		Id:            newId(),
+		Request:       req,
+		Status:        StatusQueued,
+		DateSubmitted: time.Now().Format(time.RFC3339),
+	}
+
+	m.mutex.Lock()
+	err := m.save(&job)
+	if err == nil {
+		m.jobs[job.Id] = &job
+	}
+	copied := job
+	m.mutex.Unlock()
+
+	if err != nil {
+		logging.Default().Warn("jobs: Submit: could not save job", "jobId", job.Id, "err", err)
+		return nil, err
+	}
+
+	logging.Default().Info("jobs: Submit", "jobId", job.Id, "ontology", req.Ontology, "value", req.Value, "maxArticles", req.MaxArticles)
+	m.signal()
+	return &copied, nil
+}
+
+// Get returns a copy of the job, including its partial or final results.
+func (m *Manager) Get(id string) *Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil
+	}
+	copied := *job
+	copied.Partial = append([]*api.ArticleMatch{}, job.Partial...)
+	return &copied
+}
+
+type byDateSubmitted []*Job
+
+func (js byDateSubmitted) Len() int      { return len(js) }
+func (js byDateSubmitted) Swap(i, j int) { js[i], js[j] = js[j], js[i] }
+func (js byDateSubmitted) Less(i, j int) bool {
+	if js[i].DateSubmitted == js[j].DateSubmitted {
+		return js[i].Id < js[j].Id
+	}
+	return js[i].DateSubmitted > js[j].DateSubmitted
+}
+
+// List summarises every job, without their matches, most recently submitted first.
+func (m *Manager) List() *[]*Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := []*Job{}
+	for _, job := range m.jobs {
+		jobs = append(jobs, job.summary())
+	}
+	sort.Sort(byDateSubmitted(jobs))
+	return &jobs
+}
+
+// Cancel stops a running job (keeping its partial results) or drops a queued one.
+func (m *Manager) Cancel(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if job.isFinished() {
+		return ErrFinished
+	}
+
+	if cancel, ok := m.cancels[id]; ok {
+		cancel() // the worker marks it cancelled when the scan returns
+		return nil
+	}
+
+	job.Status = StatusCancelled
+	job.DateFinished = time.Now().Format(time.RFC3339)
+	return m.save(job)
+}
+
+// Result returns the final results of a job which is done.
+func (m *Manager) Result(id string) (*api.OntologyResult, error) {
+	job := m.Get(id)
+	if job == nil {
+		return nil, ErrNotFound
+	}
+	if job.Status != StatusDone || job.Result == nil {
+		return nil, ErrNotDone
+	}
+	return job.Result, nil
+}
+
+// save assumes the mutex is held
+func (m *Manager) save(job *Job) error {
+	jsonBody, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(m.dir, job.Id+jobFileSuffix)
+	tmpFilename := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, jsonBody, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+	return nil
+}
+
+// next claims the oldest queued job, if any, assuming the mutex is held.
+func (m *Manager) next() *Job {
+	var oldest *Job
+	for _, job := range m.jobs {
+		if job.Status == StatusQueued && (oldest == nil || job.DateSubmitted < oldest.DateSubmitted) {
+			oldest = job
+		}
+	}
+	return oldest
+}
+
+func (m *Manager) work() {
+	defer m.workers.Done()
+
+	for {
+		m.mutex.Lock()
+		if m.stopping {
+			m.mutex.Unlock()
+			return
+		}
+		job := m.next()
+		var ctx context.Context
+		if job != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(context.Background())
+			m.cancels[job.Id] = cancel
+			job.Status = StatusRunning
+			job.DateStarted = time.Now().Format(time.RFC3339)
+			m.save(job)
+		}
+		m.mutex.Unlock()
+
+		if job == nil {
+			select {
+			case <-m.wake:
+			case <-m.done:
+				return
+			}
+			continue
+		}
+
+		// in case there are more queued jobs for any other idle workers
+		m.signal()
+
+		m.run(ctx, job)
+	}
+}
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	logger := logging.Default().With("jobId", job.Id)
+	ctx, timings := logging.WithTimings(logging.NewContext(ctx, logger))
+	start := time.Now()
+	logger.Info("jobs: run: starting")
+
+	lastSaved := time.Now()
+	progress := func(numDone int, numTotal int, a *article.ArticleWithSentencesAndMeter) {
+		matches := api.NewArticleProgress(numDone, numTotal, a).Matches
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		job.NumDone = numDone
+		job.NumTotal = numTotal
+		job.Partial = append(job.Partial, matches...)
+		if time.Since(lastSaved) > saveInterval {
+			m.save(job)
+			lastSaved = time.Now()
+		}
+	}
+
+	result, err := m.safeScan(ctx, job, progress)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch {
+	case m.stopping:
+		// leave it to be run again on restart
+		job.Status = StatusQueued
+		job.NumDone = 0
+		job.Partial = nil
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	case ctx.Err() != nil:
+		job.Status = StatusCancelled
+	default:
+		job.Status = StatusDone
+		job.Result = result
+		job.Partial = nil
+	}
+
+	m.cancels[job.Id]()
+	delete(m.cancels, job.Id)
+
+	if job.isFinished() {
+		job.DateFinished = time.Now().Format(time.RFC3339)
+	}
+	if err := m.save(job); err != nil {
+		logger.Warn("jobs: run: could not save job", "err", err)
+	}
+
+	logger.Info("jobs: run: finished", append([]interface{}{"status", job.Status, "numDone", job.NumDone, "millis", time.Since(start).Nanoseconds() / 1e6}, timings.Fields()...)...)
+}
+
+// safeScan turns a panic in the scan into the job failing, rather than the server dying.
+func (m *Manager) safeScan(ctx context.Context, job *Job, progress article.ArticleProgress) (result *api.OntologyResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: scan panicked: %v", r)
+		}
+	}()
+
+	m.mutex.Lock()
+	req := job.Request
+	m.mutex.Unlock()
+
+	return m.scan(ctx, &req, progress)
+}
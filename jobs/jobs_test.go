@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/api"
+	"github.com/railsagainstignorance/alignment/article"
+)
+
+// blockingScan finishes straight away if release is closed, otherwise waits for it or for the job to be cancelled.
+func blockingScan(started chan<- string, release <-chan struct{}) ScanFunc {
+	return func(ctx context.Context, req *Request, progress article.ArticleProgress) (*api.OntologyResult, error) {
+		started <- req.Value
+		select {
+		case <-release:
+			return &api.OntologyResult{Value: req.Value}, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+func waitForStatus(t *testing.T, m *Manager, id string, status string) *Job {
+	for i := 0; i < 200; i++ {
+		if job := m.Get(id); job != nil && job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s never reached status %s, got %+v", id, status, m.Get(id))
+	return nil
+}
+
+func TestJobLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	m := NewManager(dir, blockingScan(started, release), 1)
+	m.Start()
+	defer m.Stop()
+
+	if _, err := m.Submit(Request{Ontology: "authors"}); err != ErrNoQuery {
+		t.Errorf("expected ErrNoQuery, got %v", err)
+	}
+	if _, err := m.Submit(Request{Ontology: "authors", Value: "x", Form: "sonnet"}); err != ErrUnknownForm {
+		t.Errorf("expected ErrUnknownForm, got %v", err)
+	}
+
+	cancelled, err := m.Submit(Request{Ontology: "authors", Value: "first", Form: "haiku"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Request.Meter != api.Forms["haiku"] || cancelled.Request.MaxArticles != DefaultMaxArticles {
+		t.Errorf("expected the form and defaults to be filled in, got %+v", cancelled.Request)
+	}
+	<-started
+	waitForStatus(t, m, cancelled.Id, StatusRunning)
+	if err := m.Cancel(cancelled.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, m, cancelled.Id, StatusCancelled)
+	if _, err := m.Result(cancelled.Id); err != ErrNotDone {
+		t.Errorf("expected ErrNotDone for a cancelled job, got %v", err)
+	}
+
+	close(release)
+	done, err := m.Submit(Request{Ontology: "authors", Value: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	waitForStatus(t, m, done.Id, StatusDone)
+	result, err := m.Result(done.Id)
+	if err != nil || result.Value != "second" {
+		t.Errorf("expected the result of the second scan, got %+v, err=%v", result, err)
+	}
+
+	if jobs := *m.List(); len(jobs) != 2 {
+		t.Errorf("expected 2 jobs listed, got %d", len(jobs))
+	}
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	started := make(chan string, 10)
+	m := NewManager(dir, blockingScan(started, make(chan struct{})), 1)
+	m.Start()
+	job, err := m.Submit(Request{Ontology: "authors", Value: "interrupted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	m.Stop()
+
+	release := make(chan struct{})
+	close(release)
+	restarted := NewManager(dir, blockingScan(started, release), 1)
+	if j := restarted.Get(job.Id); j == nil || j.Status != StatusQueued {
+		t.Fatalf("expected the interrupted job to be queued again, got %+v", j)
+	}
+	restarted.Start()
+	defer restarted.Stop()
+
+	if value := <-started; value != "interrupted" {
+		t.Errorf("expected the interrupted job to be rerun, got %s", value)
+	}
+	waitForStatus(t, restarted, job.Id, StatusDone)
+}
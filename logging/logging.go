@@ -0,0 +1,302 @@
+// Package logging is a small leveled, structured logger: each event is one line of key=value pairs (or a JSON object),
+// starting with the time, level and message. A Logger carrying a request id, and the Timings of the request's stages,
+// travel with the request's context.Context through the packages which serve it.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return "level(" + strconv.Itoa(int(level)) + ")"
+	}
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("logging: unknown level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+const (
+	FormatText = "text" // key=value pairs, quoted where needed
+	FormatJson = "json" // one JSON object per line
+)
+
+// output is shared by a Logger and those derived from it With more fields, so their lines don't interleave.
+type output struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+type Logger struct {
+	out    *output
+	level  Level
+	format string
+	fields []interface{}
+}
+
+// New logs events at level or above to w, in format (FormatText or FormatJson).
+func New(w io.Writer, level Level, format string) *Logger {
+	return &Logger{
+		out:    &output{w: w},
+		level:  level,
+		format: format,
+	}
+}
+
+// With returns a Logger which adds the key, value pairs to every event.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		format: l.format,
+		fields: fields,
+	}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := make([]interface{}, 0, 6+len(l.fields)+len(keyvals)+1)
+	all = append(all, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	all = append(all, l.fields...)
+	all = append(all, keyvals...)
+	if len(all)%2 == 1 {
+		all = append(all, "(missing)")
+	}
+
+	var line bytes.Buffer
+	if l.format == FormatJson {
+		writeJson(&line, all)
+	} else {
+		writeText(&line, all)
+	}
+	line.WriteByte('\n')
+
+	l.out.mutex.Lock()
+	l.out.w.Write(line.Bytes())
+	l.out.mutex.Unlock()
+}
+
+// value simplifies errors, durations and the like to what is worth logging
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.Nanoseconds() / 1e6
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func writeText(line *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(fmt.Sprint(keyvals[i]))
+		line.WriteByte('=')
+
+		s := fmt.Sprint(value(keyvals[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = strconv.Quote(s)
+		}
+		line.WriteString(s)
+	}
+}
+
+func writeJson(line *bytes.Buffer, keyvals []interface{}) {
+	line.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		line.Write(key)
+		line.WriteByte(':')
+
+		v, err := json.Marshal(value(keyvals[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(keyvals[i+1]))
+		}
+		line.Write(v)
+	}
+	line.WriteByte('}')
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(New(os.Stdout, LevelInfo, FormatText))
+}
+
+// Default is the logger for everything not done on behalf of a request, and for requests with no logger of their own.
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIdKey
+	timingsKey
+)
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext is the logger carried by ctx, or the Default one.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+			return l
+		}
+	}
+	return Default()
+}
+
+// NewRequestId is a random 16 hex digits.
+func NewRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestId carries the request id, and a logger which adds it to every event.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	ctx = context.WithValue(ctx, requestIdKey, requestId)
+	return NewContext(ctx, FromContext(ctx).With("requestId", requestId))
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx != nil {
+		if requestId, ok := ctx.Value(requestIdKey).(string); ok {
+			return requestId
+		}
+	}
+	return ""
+}
+
+// Timings adds up the time a request spends in each of its stages, e.g. search, fetch, parse and scan.
+// Stages run at once, e.g. by several goroutines, are added together, so can total more than the request took.
+type Timings struct {
+	mutex     sync.Mutex
+	stages    []string
+	durations map[string]time.Duration
+	counts    map[string]int
+}
+
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := Timings{
+		durations: map[string]time.Duration{},
+		counts:    map[string]int{},
+	}
+	return context.WithValue(ctx, timingsKey, &t), &t
+}
+
+func TimingsFromContext(ctx context.Context) *Timings {
+	if ctx != nil {
+		if t, ok := ctx.Value(timingsKey).(*Timings); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// Time starts timing a stage of the request in ctx, if it has Timings, returning the func which stops it, e.g.
+//
+//	defer logging.Time(ctx, "fetch")()
+func Time(ctx context.Context, stage string) func() {
+	t := TimingsFromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		t.Add(stage, time.Since(start))
+	}
+}
+
+func (t *Timings) Add(stage string, d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.durations[stage]; !ok {
+		t.stages = append(t.stages, stage)
+	}
+	t.durations[stage] += d
+	t.counts[stage]++
+}
+
+// Fields are the key, value pairs for logging each stage, in the order they were first timed,
+// e.g. "fetchMillis", 120, "fetchCount", 3.
+func (t *Timings) Fields() []interface{} {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	fields := []interface{}{}
+	for _, stage := range t.stages {
+		fields = append(fields, stage+"Millis", t.durations[stage].Nanoseconds()/1e6, stage+"Count", t.counts[stage])
+	}
+	return fields
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo, FormatText).With("requestId", "abc")
+
+	l.Debug("not shown")
+	l.Warn("content: GetArticle: failed", "uuid", "123", "err", errors.New("no such host"), "took", 1500*time.Millisecond)
+
+	line := buf.String()
+	if strings.Contains(line, "not shown") {
+		t.Errorf("expected debug to be filtered out, got %s", line)
+	}
+	for _, expected := range []string{`level=warn`, `msg="content: GetArticle: failed"`, `requestId=abc`, `uuid=123`, `err="no such host"`, `took=1500`} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("expected %s in %s", expected, line)
+		}
+	}
+}
+
+func TestJsonFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelDebug, FormatJson)
+
+	l.Info("rss: Generate", "numItems", 20, "odd")
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("expected a JSON line, got %s, err=%v", buf.String(), err)
+	}
+	if event["msg"] != "rss: Generate" || event["numItems"] != float64(20) || event["odd"] != "(missing)" {
+		t.Errorf("unexpected event %v", event)
+	}
+}
+
+func TestContext(t *testing.T) {
+	var buf bytes.Buffer
+	SetDefault(New(&buf, LevelInfo, FormatText))
+	defer SetDefault(New(&bytes.Buffer{}, LevelInfo, FormatText))
+
+	FromContext(context.Background()).Info("no request")
+	ctx := WithRequestId(context.Background(), "r1")
+	ctx, timings := WithTimings(ctx)
+	FromContext(ctx).Info("a request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "requestId") || !strings.Contains(lines[1], "requestId=r1") {
+		t.Errorf("expected only the second line to have the request id, got %q", lines)
+	}
+	if RequestId(ctx) != "r1" {
+		t.Errorf("expected request id r1, got %s", RequestId(ctx))
+	}
+
+	Time(ctx, "fetch")()
+	Time(ctx, "scan")()
+	Time(ctx, "fetch")()
+	Time(context.Background(), "ignored")()
+
+	fields := timings.Fields()
+	if len(fields) != 8 || fields[0] != "fetchMillis" || fields[3] != 2 || fields[4] != "scanMillis" {
+		t.Errorf("expected fetch (twice) then scan timings, got %v", fields)
+	}
+}
@@ -1,32 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"log"
 	"regexp"
 	"strings"
-	"strconv"
 	"encoding/json"
-	"github.com/joho/godotenv"
+    "github.com/railsagainstignorance/alignment/config"
     "github.com/railsagainstignorance/alignment/rss"
     "github.com/railsagainstignorance/alignment/content"
     "github.com/railsagainstignorance/alignment/image"
+    "github.com/railsagainstignorance/alignment/logging"
 )
 
-func getEnvParam(key string, defaultValue string) string {
-	godotenv.Load()
-	value := os.Getenv(key)
-
-	if value == "" {
-		value = defaultValue
-	}
-
-	return value
-}
-
-var keywordsCsv = getEnvParam("KEYWORDS_CSV", "if,and,but,light,you")
-var keywords    = strings.Split(keywordsCsv, ",")
+var keywords    = []string{}
 var defaultImageUrl    = `https://www.ft.com/__origami/service/image/v2/images/raw/http%3A%2F%2Fprod-upp-image-read.ft.com%2F69f10230-2272-11e6-aa98-db1e01fabc0c?source=next&fit=scale-down&compression=best&width=600`
 var defaultImageWidth  = 600
 var defaultImageHeight = 338
@@ -68,16 +57,18 @@ type MeditationHaiku struct {
 }
 
 
-func GetHaikusWithImages(maxItems int) *[]*MeditationHaiku {
+func GetHaikusWithImages(ctx context.Context, maxItems int) *[]*MeditationHaiku {
 	rssItemsIncludingMissingImages := rss.GenerateItems( maxItems )
 	items := []*MeditationHaiku {}
 
 	reHaikuPieces := regexp.MustCompile("(?i)[a-z]")
 	reLineBreaks  := regexp.MustCompile("\r?\n")
 
-	for i, rssItem := range *rssItemsIncludingMissingImages {
+	logger := logging.FromContext(ctx)
+
+	for _, rssItem := range *rssItemsIncludingMissingImages {
 		if rssItem.Uuid == "" {
-			fmt.Println("meditation: GetHaikusWithImages: discarding (no Uuid) rssItem=", rssItem)
+			logger.Warn("meditation: GetHaikusWithImages: discarding haiku with no uuid", "url", rssItem.Url, "title", rssItem.Title)
 		} else {
 			item := &MeditationHaiku{
 				Author:       rssItem.Author,
@@ -89,7 +80,12 @@ func GetHaikusWithImages(maxItems int) *[]*MeditationHaiku {
 				Uuid:         rssItem.Uuid,
 			}
 
-			capiArticle := content.GetArticle(item.Uuid)
+			latest := false
+			capiArticle, err := content.GetArticle(ctx, item.Uuid, latest)
+			if err != nil {
+				logger.Warn("meditation: GetHaikusWithImages: discarding haiku whose article can't be had", "uuid", item.Uuid, "err", err)
+				continue
+			}
 			item.ImageUrl      = capiArticle.ImageUrl
 			item.ImageWidth    = capiArticle.ImageWidth
 			item.ImageHeight   = capiArticle.ImageHeight
@@ -105,7 +101,7 @@ func GetHaikusWithImages(maxItems int) *[]*MeditationHaiku {
 			item.Title = capiArticle.Title // cos is sometimes missing from the rss feed
 
 			if item.ImageUrl == "" {
-				fmt.Println("meditation: GetHaikusWithImages: no ImageUrl: item=", item)
+				logger.Info("meditation: GetHaikusWithImages: no image, using the default", "uuid", item.Uuid)
 				item.ImageUrl    = defaultImageUrl
 				item.ImageWidth  = defaultImageWidth
 				item.ImageHeight = defaultImageHeight
@@ -128,21 +124,23 @@ func GetHaikusWithImages(maxItems int) *[]*MeditationHaiku {
 			}
 
 			item.Themes = &themes
-			fmt.Println("meditation: GetHaikusWithImages: item.Themes=", item.Themes)
+			item.ProminentColours = image.GetProminentColours( ctx, item.ImageUrl )
 
-			item.ProminentColours = image.GetProminentColours( item.ImageUrl )
-
-			fmt.Println("meditation: GetHaikusWithImages: ", i, ") ", item.Title, ", imageUrl=", item.ImageUrl )
+			logger.Debug("meditation: GetHaikusWithImages", "uuid", item.Uuid, "title", item.Title, "imageUrl", item.ImageUrl, "themes", strings.Join(themes, ","))
 		}
 	}
 	return &items
 }
 
 func main() {
-	godotenv.Load()
-	var maxItems, _ = strconv.Atoi( getEnvParam("MAX_ITEMS", "1000") )
+	cfg, err := config.Load("meditation", os.Args[1:])
+	if err != nil {
+		log.Fatal("meditation:main: ", err)
+	}
+	cfg.Inject(os.Stdout)
+	keywords = strings.Split(cfg.KeywordsCsv, ",")
 
-	haikus := GetHaikusWithImages( maxItems )
+	haikus := GetHaikusWithImages( context.Background(), cfg.MeditationMaxItems )
 	haikusB, _ := json.Marshal(haikus)
 
     ofile, err := os.Create("meditation_haiku.json")
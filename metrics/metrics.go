@@ -0,0 +1,331 @@
+// Package metrics keeps counters and histograms, each split by labels, and writes them in the Prometheus text
+// exposition format, for scraping from /metrics. Packages declare their metrics once, as package variables,
+// and resolve the series for a set of label values With, e.g.
+//
+//	var cacheRequests = metrics.NewCounter("alignment_cache_requests_total", "Cache lookups.", "cache", "result")
+//
+//	cacheRequests.With("article", "hit").Inc()
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is that of the text exposition format written by WriteText.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// LatencyBuckets are the upper bounds, in seconds, for histograms of how long things take,
+// from a cache hit up to a scan of many articles.
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type metric interface {
+	name() string
+	signature() string
+	write(buf *bytes.Buffer)
+}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Default is the registry for the package level NewCounter and NewHistogram, written by the package level WriteText.
+var Default = NewRegistry()
+
+// register returns m, or the metric already registered by its name, e.g. by another package counting the same thing,
+// so long as they are alike.
+func (r *Registry) register(m metric) metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.metrics[m.name()]; ok {
+		if existing.signature() != m.signature() {
+			panic("metrics: " + m.name() + " registered twice, as " + existing.signature() + " and " + m.signature())
+		}
+		return existing
+	}
+	r.metrics[m.name()] = m
+	return m
+}
+
+// WriteText writes every metric, sorted by name, in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, len(names))
+	for i, name := range names {
+		ms[i] = r.metrics[name]
+	}
+	r.mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range ms {
+		m.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func WriteText(w io.Writer) error {
+	return Default.WriteText(w)
+}
+
+// desc is what counters and histograms have in common: their name, help and labels, and a series per set of label values.
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+
+	mutex  sync.RWMutex
+	series map[string]interface{}
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) signature() string {
+	return d.kind + "(" + strings.Join(d.labelNames, ",") + ")"
+}
+
+// get returns the series for the label values, making it if need be.
+func (d *desc) get(labelValues []string, newSeries func() interface{}) interface{} {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got values %v", d.metricName, d.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	d.mutex.RLock()
+	s, ok := d.series[key]
+	d.mutex.RUnlock()
+	if ok {
+		return s
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if s, ok = d.series[key]; !ok {
+		s = newSeries()
+		d.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series in the order of their label values.
+func (d *desc) sorted() []interface{} {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	keys := make([]string, 0, len(d.series))
+	for key := range d.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]interface{}, len(keys))
+	for i, key := range keys {
+		series[i] = d.series[key]
+	}
+	return series
+}
+
+func (d *desc) writeHeader(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, d.kind)
+}
+
+// writeSample writes one line, e.g. name{label="value",le="0.5"} 3
+func (d *desc) writeSample(buf *bytes.Buffer, suffix string, labelValues []string, extraName string, extraValue string, v float64) {
+	buf.WriteString(d.metricName)
+	buf.WriteString(suffix)
+
+	if len(labelValues) > 0 || extraName != "" {
+		buf.WriteByte('{')
+		for i, labelValue := range labelValues {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, `%s="%s"`, d.labelNames[i], escapeLabelValue(labelValue))
+		}
+		if extraName != "" {
+			if len(labelValues) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, `%s="%s"`, extraName, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(v))
+	buf.WriteByte('\n')
+}
+
+// Counter only goes up, e.g. the number of requests.
+type Counter struct {
+	desc
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := Counter{desc{metricName: name, help: help, kind: "counter", labelNames: labelNames, series: map[string]interface{}{}}}
+	return r.register(&c).(*Counter)
+}
+
+type CounterSeries struct {
+	bits        uint64 // first, for its 64 bit alignment, and a float64 held as its bits, for atomic adds
+	labelValues []string
+}
+
+// With is the series for the label values, one for each of the counter's label names.
+// It is worth keeping, rather than looking up again, for counters incremented in a tight loop.
+func (c *Counter) With(labelValues ...string) *CounterSeries {
+	return c.get(labelValues, func() interface{} {
+		return &CounterSeries{labelValues: append([]string{}, labelValues...)}
+	}).(*CounterSeries)
+}
+
+func (s *CounterSeries) Inc() {
+	s.Add(1)
+}
+
+// Add adds v, which must not be negative.
+func (s *CounterSeries) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters cannot go down")
+	}
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.bits, old, sum) {
+			return
+		}
+	}
+}
+
+func (s *CounterSeries) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	c.writeHeader(buf)
+	for _, s := range c.sorted() {
+		cs := s.(*CounterSeries)
+		c.writeSample(buf, "", cs.labelValues, "", "", cs.Value())
+	}
+}
+
+// Histogram counts observations, e.g. of how long requests take, into buckets, and keeps their sum.
+type Histogram struct {
+	desc
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	h := Histogram{
+		desc:    desc{metricName: name, help: help, kind: "histogram", labelNames: labelNames, series: map[string]interface{}{}},
+		buckets: sorted,
+	}
+	return r.register(&h).(*Histogram)
+}
+
+type HistogramSeries struct {
+	mutex       sync.Mutex
+	buckets     []float64
+	counts      []uint64 // per bucket, not yet cumulative
+	count       uint64
+	sum         float64
+	labelValues []string
+}
+
+func (h *Histogram) With(labelValues ...string) *HistogramSeries {
+	return h.get(labelValues, func() interface{} {
+		return &HistogramSeries{
+			buckets:     h.buckets,
+			counts:      make([]uint64, len(h.buckets)),
+			labelValues: append([]string{}, labelValues...),
+		}
+	}).(*HistogramSeries)
+}
+
+func (s *HistogramSeries) Observe(v float64) {
+	i := sort.SearchFloat64s(s.buckets, v) // the first bucket whose upper bound is >= v
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.writeHeader(buf)
+	for _, s := range h.sorted() {
+		hs := s.(*HistogramSeries)
+
+		hs.mutex.Lock()
+		counts := append([]uint64{}, hs.counts...)
+		count, sum := hs.count, hs.sum
+		hs.mutex.Unlock()
+
+		cumulative := uint64(0)
+		for i, upperBound := range hs.buckets {
+			cumulative += counts[i]
+			h.writeSample(buf, "_bucket", hs.labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		h.writeSample(buf, "_bucket", hs.labelValues, "le", "+Inf", float64(count))
+		h.writeSample(buf, "_sum", hs.labelValues, "", "", sum)
+		h.writeSample(buf, "_count", hs.labelValues, "", "", float64(count))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(labelValue string) string {
+	return labelValueReplacer.Replace(labelValue)
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests, by route.", "route")
+	latency := r.NewHistogram("test_latency_seconds", "How long requests took.", []float64{1, .1}, "route")
+
+	requests.With("/align").Inc()
+	requests.With("/align").Add(2)
+	requests.With(`/a"b`).Inc()
+	latency.With("/align").Observe(.05)
+	latency.With("/align").Observe(.5)
+	latency.With("/align").Observe(5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_latency_seconds How long requests took.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/align",le="0.1"} 1
+test_latency_seconds_bucket{route="/align",le="1"} 2
+test_latency_seconds_bucket{route="/align",le="+Inf"} 3
+test_latency_seconds_sum{route="/align"} 5.55
+test_latency_seconds_count{route="/align"} 3
+# HELP test_requests_total Requests, by route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b"} 1
+test_requests_total{route="/align"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Once.", "cache")
+	if r.NewCounter("test_total", "Twice.", "cache") != c {
+		t.Error("expected the same counter when registered twice alike")
+	}
+
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(err.(string), "test_total") {
+			t.Errorf("expected a panic naming test_total, got %v", err)
+		}
+	}()
+	r.NewHistogram("test_total", "Unalike.", LatencyBuckets, "cache")
+}
@@ -1,10 +1,13 @@
 package ontology
 
 import (
+    "context"
     // "fmt"
     "sort"
     "github.com/railsagainstignorance/alignment/article"
+    "github.com/railsagainstignorance/alignment/dedup"
     "github.com/railsagainstignorance/alignment/rhyme"
+    "github.com/railsagainstignorance/alignment/scoring"
 )
 
 type MatchedPhraseWithUrlWithFirst struct {
@@ -31,12 +34,17 @@ type Details struct {
 type FSandCount struct {
     FinalSyllable string
     Count int
+    BestScore float64
 }
 type FSandCounts []*FSandCount
 
 func (fsc FSandCounts) Len()          int  { return len(fsc) }
 func (fsc FSandCounts) Swap(i, j int)      { fsc[i], fsc[j] = fsc[j], fsc[i] }
-func (fsc FSandCounts) Less(i, j int) bool { return (fsc[j].FinalSyllable == "") || ((fsc[i].FinalSyllable != "") && (fsc[i].Count > fsc[j].Count)) }
+func (fsc FSandCounts) Less(i, j int) bool {
+    return (fsc[j].FinalSyllable == "") ||
+        ((fsc[i].FinalSyllable != "") && (fsc[i].Count > fsc[j].Count)) ||
+        ((fsc[i].FinalSyllable != "") && (fsc[i].Count == fsc[j].Count) && (fsc[i].BestScore > fsc[j].BestScore))
+}
 
 type ArticleAndMPWUs struct {
     Article *article.ArticleWithSentencesAndMeter
@@ -45,15 +53,81 @@ type ArticleAndMPWUs struct {
 
 const maxMaxArticles = 1000
 
+// MaxArchiveArticles limits the background scans of GetArchiveDetails, which are not bound by a page load
+const MaxArchiveArticles = 20000
+
 func GetDetails(syllabi *rhyme.Syllabi, ontologyName string, ontologyValue string, meter string, maxArticles int, maxMillis int) (*Details, bool) {
+    return GetDetailsContext(context.Background(), syllabi, ontologyName, ontologyValue, meter, maxArticles, maxMillis, nil)
+}
+
+// GetDetailsContext is GetDetails over only those articles processed before ctx is cancelled,
+// reporting each of them to progress (if not nil) as soon as it has been processed.
+func GetDetailsContext(ctx context.Context, syllabi *rhyme.Syllabi, ontologyName string, ontologyValue string, meter string, maxArticles int, maxMillis int, progress article.ArticleProgress) (*Details, bool) {
+    return getDetails(ctx, syllabi, ontologyName, ontologyValue, meter, maxArticles, maxMillis, progress, maxMaxArticles)
+}
+
+// GetArchiveDetails is GetDetailsContext for long running background scans, e.g. of an author's whole career,
+// allowing up to MaxArchiveArticles.
+func GetArchiveDetails(ctx context.Context, syllabi *rhyme.Syllabi, ontologyName string, ontologyValue string, meter string, maxArticles int, maxMillis int, progress article.ArticleProgress) (*Details, bool) {
+    return getDetails(ctx, syllabi, ontologyName, ontologyValue, meter, maxArticles, maxMillis, progress, MaxArchiveArticles)
+}
+
+// dedupeMatches folds the same match appearing in several articles (updates, syndicated copy) under the earliest of them.
+// A match is only folded under one of the same (near-duplicate) sentence, matching the same words, from another article,
+// so distinct sentences, and different matches in the same sentence, are never hidden.
+func dedupeMatches(mpwus []*article.MatchedPhraseWithUrl) []*article.MatchedPhraseWithUrl {
+    urlOf := func(mpwu *article.MatchedPhraseWithUrl) string {
+        if mpwu.Url == nil {
+            return ""
+        }
+        return *mpwu.Url
+    }
+
+    deduped := []*article.MatchedPhraseWithUrl{}
+    for _, group := range dedup.Group(article.MatchedPhrasesWithUrl(mpwus), dedup.DefaultThreshold) {
+        kept := []*article.MatchedPhraseWithUrl{}
+        for _, i := range group {
+            mpwu := mpwus[i]
+            folded := false
+            for _, k := range kept {
+                if urlOf(k) != urlOf(mpwu) && dedup.Normalise(k.MatchesOnMeter.During) == dedup.Normalise(mpwu.MatchesOnMeter.During) {
+                    k.Duplicates = append(k.Duplicates, mpwu)
+                    folded = true
+                    break
+                }
+            }
+            if !folded {
+                mpwu.Duplicates = []*article.MatchedPhraseWithUrl{}
+                kept = append(kept, mpwu)
+            }
+        }
+        deduped = append(deduped, kept...)
+    }
+    return deduped
+}
+
+func getDetails(ctx context.Context, syllabi *rhyme.Syllabi, ontologyName string, ontologyValue string, meter string, maxArticles int, maxMillis int, progress article.ArticleProgress, maxArticlesLimit int) (*Details, bool) {
 
     if maxArticles < 1 {
         maxArticles = 1
-    } else if maxArticles > maxMaxArticles {
-        maxArticles = maxMaxArticles 
+    } else if maxArticles > maxArticlesLimit {
+        maxArticles = maxArticlesLimit
     }
 
-    articles, matchedPhrasesWithUrl := article.GetArticlesByOntologyWithSentencesAndMeter(ontologyName, ontologyValue, meter, syllabi, maxArticles, maxMillis )
+    articles, matchedPhrasesWithUrl := article.GetArticlesByOntologyWithSentencesAndMeterContext(ctx, ontologyName, ontologyValue, meter, syllabi, maxArticles, maxMillis, progress)
+
+    // score each match against all the sentences scanned, so the best rise to the top of each list
+    sentences := []string{}
+    for _, a := range *articles {
+        sentences = append(sentences, *a.Sentences...)
+    }
+    scorer := scoring.NewScorer(sentences, syllabi.PhraseWordsRegexp)
+    for _, mpwu := range *matchedPhrasesWithUrl {
+        mpwu.Score = scorer.ScoreRhymeAndMeter(mpwu.RhymeAndMeter)
+    }
+
+    dedupedMpwus := dedupeMatches(*matchedPhrasesWithUrl)
+    matchedPhrasesWithUrl = &dedupedMpwus
 
     finalSyllablesMap    := &map[string][]*(article.MatchedPhraseWithUrl){}
     badFinalSyllablesMap := &map[string][]*(article.MatchedPhraseWithUrl){}
@@ -110,7 +184,12 @@ func GetDetails(syllabi *rhyme.Syllabi, ontologyName string, ontologyValue strin
         fsCounts := []*FSandCount{}
 
         for fs, list := range (*fsMap) {
-            fsCounts = append(fsCounts, &FSandCount{fs, len(list)} )
+            sort.Stable(article.MatchedPhrasesWithUrlByScore(list))
+            bestScore := 0.0
+            if list[0].Score != nil {
+                bestScore = list[0].Score.Total
+            }
+            fsCounts = append(fsCounts, &FSandCount{fs, len(list), bestScore} )
         }
 
         sort.Sort(FSandCounts(fsCounts))
@@ -134,6 +213,12 @@ func GetDetails(syllabi *rhyme.Syllabi, ontologyName string, ontologyValue strin
     sortedMpwus    := processFSMapIntoSortedMPWUs(finalSyllablesMap)
     sortedBadMpwus := processFSMapIntoSortedMPWUs(badFinalSyllablesMap)
 
+    sort.Stable(article.MatchedPhrasesWithUrlByScore(secondaryMatchedPhrasesWithUrl))
+    sort.Stable(article.MatchedPhrasesWithUrlByScore(badSecondaryMatchedPhrasesWithUrl))
+    for _, mpwus := range secondaryMatchedPhrasesWithUrlByUrl {
+        sort.Stable(article.MatchedPhrasesWithUrlByScore(*mpwus))
+    }
+
     listOfArticleAndMPWUs := []*(ArticleAndMPWUs){}
 
     if len(secondaryMatchedPhrasesWithUrlByUrl) > 0 {
@@ -0,0 +1,43 @@
+package ontology
+
+import (
+	"testing"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+func match(url string, phrase string, during string, daysAgo int) *article.MatchedPhraseWithUrl {
+	pubDate := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -daysAgo)
+	return &article.MatchedPhraseWithUrl{
+		RhymeAndMeter: &rhyme.RhymeAndMeter{Phrase: phrase, MatchesOnMeter: &rhyme.MatchesOnMeter{During: during}},
+		Url:           &url,
+		PubDate:       &pubDate,
+	}
+}
+
+func TestDedupeMatches(t *testing.T) {
+	mpwus := []*article.MatchedPhraseWithUrl{
+		match("a", "Shares in the bank fell sharply on Tuesday after the results.", "the results", 0),
+		match("b", "Voters will not be swayed by the results.", "the results", 1),
+		// the first, syndicated, a day earlier
+		match("c", "Shares in the bank fell sharply on Tuesday after the results.", "the results", 2),
+		// a second match in the first sentence, of its own
+		match("a", "Shares in the bank fell sharply on Tuesday after the results.", "on Tuesday", 0),
+	}
+
+	deduped := dedupeMatches(mpwus)
+	if len(deduped) != 3 {
+		t.Fatalf("expected 3 matches, the two sentences sharing %q kept apart, got %d", "the results", len(deduped))
+	}
+	if *deduped[0].Url != "c" || len(deduped[0].Duplicates) != 1 || *deduped[0].Duplicates[0].Url != "a" {
+		t.Errorf("expected the syndicated copy folded under the earliest, got %s with %d duplicates", *deduped[0].Url, len(deduped[0].Duplicates))
+	}
+	if deduped[1].MatchesOnMeter.During != "on Tuesday" || len(deduped[1].Duplicates) != 0 {
+		t.Errorf("expected the sentence's other match kept, got %q", deduped[1].MatchesOnMeter.During)
+	}
+	if *deduped[2].Url != "b" || len(deduped[2].Duplicates) != 0 {
+		t.Errorf("expected the other sentence kept, got %s", *deduped[2].Url)
+	}
+}
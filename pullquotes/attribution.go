@@ -0,0 +1,130 @@
+package pullquotes
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/railsagainstignorance/alignment/content"
+)
+
+var (
+	// a name of up to four capitalised words, perhaps after a title, or a pronoun standing for the last one named,
+	// as a whole word, so e.g. the “her” of “said her spokesman” isn't “he”
+	namePattern = `((?:(?:Mr|Ms|Mrs|Miss|Dr|Sir|Dame|Lord|Lady|Professor)\.?\s+)?\p{Lu}[\p{L}'’-]+(?:\s+\p{Lu}[\p{L}'’-]+){0,3}|\b(?:[Hh]e|[Ss]he|[Tt]hey)\b)`
+	// e.g. the “, chief executive of Acme,” in “Jane Smith, chief executive of Acme, said”
+	rolePattern = `(?:,[^,"“”.!?]{1,100},)?`
+
+	// e.g. “...,” said Jane Smith
+	verbThenNameAfterRegexp = regexp.MustCompile(`^[\s,]*(?:` + speechVerbs + `|according to)\s+` + namePattern)
+	// e.g. “...,” Jane Smith, chief executive of Acme, said
+	nameThenVerbAfterRegexp = regexp.MustCompile(`^[\s,]*` + namePattern + rolePattern + `\s+` + speechVerbs + `\b`)
+	// e.g. Jane Smith told the FT: “...”
+	nameThenVerbBeforeRegexp = regexp.MustCompile(namePattern + rolePattern + `\s+` + speechVerbs + `\b[^"“”.!?]{0,40}[:,]\s*$`)
+
+	titleRegexp = regexp.MustCompile(`^(?:Mr|Ms|Mrs|Miss|Dr|Sir|Dame|Lord|Lady|Professor)\.?\s+`)
+)
+
+// speakers tells who said the quotes of an article, read in order, normalising their names against the people
+// the article is about, in its metadata, and those already named, so a later “Mr Smith” or “she” is the Jane Smith named before.
+type speakers struct {
+	people []string
+	named  []string
+	last   string
+}
+
+func newSpeakers(people []string) *speakers {
+	return &speakers{people: people}
+}
+
+// attribute is who said the quote between before and after, its neighbouring text in the paragraph, or "" if not known.
+func (s *speakers) attribute(before string, after string) string {
+	for _, m := range [][]string{
+		verbThenNameAfterRegexp.FindStringSubmatch(after),
+		nameThenVerbAfterRegexp.FindStringSubmatch(after),
+		nameThenVerbBeforeRegexp.FindStringSubmatch(before),
+	} {
+		if m != nil {
+			if speaker := s.resolve(m[1]); speaker != "" {
+				return speaker
+			}
+		}
+	}
+	return ""
+}
+
+// resolve is the full name of the speaker, a pronoun being the last one named, remembering them for later quotes.
+func (s *speakers) resolve(name string) string {
+	switch strings.ToLower(name) {
+	case "he", "she", "they":
+		return s.last
+	}
+
+	speaker := s.normalise(name)
+	if speaker == "" {
+		return ""
+	}
+	found := false
+	for _, n := range s.named {
+		found = found || n == speaker
+	}
+	if !found {
+		s.named = append(s.named, speaker)
+	}
+	s.last = speaker
+	return speaker
+}
+
+// normalise is the name as in the article's metadata, or as first named, if it is, ends with (e.g. “Acme boss Jane Smith”),
+// or is the surname of (e.g. “Mr Smith”), one of them, or else the name without its title.
+func (s *speakers) normalise(name string) string {
+	name = strings.TrimSpace(titleRegexp.ReplaceAllString(name, ""))
+	if name == "" {
+		return ""
+	}
+
+	for _, known := range [][]string{s.people, s.named} {
+		for _, k := range known {
+			if strings.EqualFold(k, name) || strings.HasSuffix(name, " "+k) {
+				return k
+			}
+		}
+	}
+
+	if !strings.Contains(name, " ") {
+		for _, known := range [][]string{s.people, s.named} {
+			for _, k := range known {
+				if strings.HasSuffix(k, " "+name) {
+					return k
+				}
+			}
+		}
+	}
+	return name
+}
+
+// normaliseAttribution normalises the name at the start of an attribution, e.g. the “Mr Smith” of “Mr Smith, Acme”.
+func (s *speakers) normaliseAttribution(attribution string) string {
+	parts := strings.SplitN(attribution, ",", 2)
+	parts[0] = s.normalise(parts[0])
+	if parts[0] == "" {
+		return strings.TrimSpace(attribution)
+	}
+	return strings.Join(parts, ",")
+}
+
+// SaidBy says if the attribution names the speaker, in whole words and ignoring case, e.g. “Smith” or “jane smith” for “Jane Smith, Acme”.
+func SaidBy(attribution string, speaker string) bool {
+	a := " " + strings.ToLower(strings.Join(strings.Fields(attribution), " ")) + " "
+	sp := strings.ToLower(strings.Join(strings.Fields(speaker), " "))
+	return sp != "" && (strings.Contains(a, " "+sp+" ") || strings.Contains(a, " "+sp+","))
+}
+
+func filterBySpeaker(quotes []content.PullQuoteAsset, speaker string) []content.PullQuoteAsset {
+	filtered := []content.PullQuoteAsset{}
+	for _, q := range quotes {
+		if SaidBy(q.Attribution, speaker) {
+			filtered = append(filtered, q)
+		}
+	}
+	return filtered
+}
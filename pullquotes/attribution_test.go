@@ -0,0 +1,54 @@
+package pullquotes
+
+import (
+	"testing"
+)
+
+func TestAttribution(t *testing.T) {
+	people := []string{"Jane Smith", "Ravi Patel"}
+
+	// one article's paragraphs, in order, each with a quote
+	cases := []struct {
+		paragraph string
+		expected  string
+	}{
+		{`“We are not in the business of making excuses,” said Mrs Smith.`, "Jane Smith"},
+		{`“The deal will close by the end of the year,” Ravi Patel, chief financial officer of Acme, said on Tuesday.`, "Ravi Patel"},
+		{`“It is too early to say what the regulators will make of it,” he added.`, "Ravi Patel"},
+		{`Acme boss Tom Jones told the FT: “We have never been in better shape than we are now.”`, "Tom Jones"},
+		{`“Our customers have stuck with us through all of it,” Mr Jones said.`, "Tom Jones"},
+		{`“There is no plan to sell the business to anyone,” according to Dr Patel.`, "Ravi Patel"},
+		{`“Nobody saw this coming at all, not even us,” the chairman said.`, ""},
+		{`“The company will not be commenting on the rumours,” said her spokesman.`, ""},
+	}
+
+	s := newSpeakers(people)
+	for _, c := range cases {
+		quotes := speechQuotes(c.paragraph, s)
+		if len(quotes) != 1 {
+			t.Errorf("expected a quote in %q, got %d", c.paragraph, len(quotes))
+			continue
+		}
+		if quotes[0].Attribution != c.expected {
+			t.Errorf("expected %q to be attributed to %q, got %q", c.paragraph, c.expected, quotes[0].Attribution)
+		}
+	}
+}
+
+func TestSaidBy(t *testing.T) {
+	cases := []struct {
+		attribution, speaker string
+		expected             bool
+	}{
+		{"Jane Smith, chief executive of Acme", "jane smith", true},
+		{"Jane Smith", "Smith", true},
+		{"Jane Smithson", "Smith", false},
+		{"", "Smith", false},
+		{"Jane Smith", "", false},
+	}
+	for _, c := range cases {
+		if got := SaidBy(c.attribution, c.speaker); got != c.expected {
+			t.Errorf("SaidBy(%q, %q): expected %t, got %t", c.attribution, c.speaker, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package pullquotes
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/railsagainstignorance/alignment/content"
+	"golang.org/x/net/html"
+)
+
+const (
+	minQuoteWords         = 5   // fewer, and quote marks are more likely around a name or a scare quote than speech
+	maxMarkupQuoteChars   = 500 // more, and the markup was likely broken, e.g. an unclosed <p> swallowing the rest of the body
+	minQuotableChars      = 60  // a quotable sentence says something in itself
+	maxQuotableChars      = 200 // but still fits on a card
+	maxQuotablePerArticle = 2
+)
+
+var (
+	quoteRegexp = regexp.MustCompile(`["“]([^"“”]+)["”]`)
+	speechVerbs = `(?:said|says|say|told|tells|added|adds|explained|explains|warned|warns|argued|argues|insisted|insists|wrote|writes)`
+	// e.g. “...,” he said, or “...,” according to Jane Smith, within the same sentence
+	speechAfterRegexp = regexp.MustCompile(`^[^"“”.!?]{0,100}\b(?:` + speechVerbs + `|according to)\b`)
+	// e.g. She told the FT: “...”
+	speechBeforeRegexp = regexp.MustCompile(`\b` + speechVerbs + `\b[^"“”.!?]{0,40}[:,]\s*$`)
+	sentenceRegexp     = regexp.MustCompile(`[^.!?]+[.!?]`)
+)
+
+// ExtractQuotes finds the quotes in an article's body html, beyond its pullQuote assets: each <pull-quote> and <blockquote>,
+// with the attribution in its <pull-quote-source>, <cite> or <footer>, and each piece of quoted speech in its paragraphs,
+// attributed to its speaker, normalised against the people the article is about. If quotable, it also picks the sentences
+// most worth quoting. Each quote is plain text, labelled with its Source.
+func ExtractQuotes(body string, people []string, quotable bool) []content.PullQuoteAsset {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	markup := []content.PullQuoteAsset{}
+	speech := []content.PullQuoteAsset{}
+	paragraphs := []string{}
+	s := newSpeakers(people)
+
+	// in the order of the body, so a speaker is named before being referred to
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "pull-quote", "blockquote":
+				if q, ok := markupQuote(n, s); ok {
+					markup = append(markup, q)
+				}
+				return
+			case "p":
+				if text := textOf(n, nil); text != "" {
+					paragraphs = append(paragraphs, text)
+					speech = append(speech, speechQuotes(text, s)...)
+				}
+				return
+			case "script", "style":
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	quotes := append(markup, speech...)
+	if quotable {
+		quotes = append(quotes, quotableSentences(paragraphs, quotes)...)
+	}
+	return quotes
+}
+
+var citationElements = map[string]bool{"pull-quote-source": true, "cite": true, "footer": true, "figcaption": true}
+
+func markupQuote(n *html.Node, s *speakers) (content.PullQuoteAsset, bool) {
+	q := content.PullQuoteAsset{
+		Body:   trimQuoteMarks(textOf(n, citationElements)),
+		Source: content.QuoteSourceMarkup,
+	}
+
+	var findCitation func(n *html.Node)
+	findCitation = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && q.Attribution == ""; c = c.NextSibling {
+			if c.Type == html.ElementNode && citationElements[c.Data] {
+				q.Attribution = s.normaliseAttribution(strings.TrimLeft(textOf(c, nil), "—–- "))
+			} else {
+				findCitation(c)
+			}
+		}
+	}
+	findCitation(n)
+
+	return q, q.Body != "" && len(q.Body) <= maxMarkupQuoteChars
+}
+
+// textOf is the text within n, apart from that within any of the skipped elements, with its whitespace collapsed.
+func textOf(n *html.Node, skip map[string]bool) string {
+	words := []string{}
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			words = append(words, strings.Fields(n.Data)...)
+			return
+		case n.Type == html.ElementNode && skip[n.Data]:
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.Join(words, " ")
+}
+
+func trimQuoteMarks(s string) string {
+	return strings.TrimSpace(strings.Trim(s, `"“”‘’' `))
+}
+
+// speechQuotes are the quotes in the paragraph next to a verb of speech, e.g. “...,” she said, rather than a name or a scare quote,
+// each attributed to its speaker, if named.
+func speechQuotes(paragraph string, s *speakers) []content.PullQuoteAsset {
+	quotes := []content.PullQuoteAsset{}
+	for _, m := range quoteRegexp.FindAllStringSubmatchIndex(paragraph, -1) {
+		text := strings.TrimRight(strings.TrimSpace(paragraph[m[2]:m[3]]), ",")
+		if len(strings.Fields(text)) < minQuoteWords {
+			continue
+		}
+		if speechAfterRegexp.MatchString(paragraph[m[1]:]) || speechBeforeRegexp.MatchString(paragraph[:m[0]]) {
+			quotes = append(quotes, content.PullQuoteAsset{
+				Body:        text,
+				Attribution: s.attribute(paragraph[:m[0]], paragraph[m[1]:]),
+				Source:      content.QuoteSourceSpeech,
+			})
+		}
+	}
+	return quotes
+}
+
+type scoredSentence struct {
+	text  string
+	score int
+}
+
+type byScore []scoredSentence
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool { return s[i].score > s[j].score }
+
+var quotableWords = map[string]bool{"but": true, "yet": true, "not": true, "never": true, "only": true, "must": true, "should": true}
+
+// quotableScore favours sentences of about 20 words, which turn on a but or a never, and lead their paragraph,
+// over those full of figures.
+func quotableScore(sentence string, leads bool) int {
+	words := strings.Fields(sentence)
+	score := -abs(len(words) - 20)
+	seen := map[string]bool{}
+	for _, w := range words {
+		lw := strings.ToLower(strings.TrimFunc(w, unicode.IsPunct))
+		if quotableWords[lw] && !seen[lw] {
+			score += 3
+			seen[lw] = true
+		}
+		if strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			score -= 2
+		}
+	}
+	if leads {
+		score += 2
+	}
+	return score
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// quotableSentences picks the sentences of the paragraphs most worth quoting, leaving out any quoted or already found.
+func quotableSentences(paragraphs []string, found []content.PullQuoteAsset) []content.PullQuoteAsset {
+	candidates := byScore{}
+	for _, p := range paragraphs {
+		for i, sentence := range sentenceRegexp.FindAllString(p, -1) {
+			sentence = strings.TrimSpace(sentence)
+			if len(sentence) < minQuotableChars || len(sentence) > maxQuotableChars || strings.ContainsAny(sentence, `"“”`) || isFound(sentence, found) {
+				continue
+			}
+			candidates = append(candidates, scoredSentence{sentence, quotableScore(sentence, i == 0)})
+		}
+	}
+	sort.Stable(candidates)
+
+	quotes := []content.PullQuoteAsset{}
+	for i := 0; i < len(candidates) && i < maxQuotablePerArticle; i++ {
+		quotes = append(quotes, content.PullQuoteAsset{Body: candidates[i].text, Source: content.QuoteSourceQuotable})
+	}
+	return quotes
+}
+
+// normalisedQuote is the quote's letters and digits, in lower case, so the same quote matches whatever its punctuation.
+func normalisedQuote(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// findQuote is the index of the quote already found which the quote is, or is within, or contains,
+// e.g. a pullQuote asset also in the body's markup, or -1.
+func findQuote(quote string, found []content.PullQuoteAsset) int {
+	nq := normalisedQuote(quote)
+	for i, f := range found {
+		nf := normalisedQuote(f.Body)
+		if nq != "" && nf != "" && (strings.Contains(nf, nq) || strings.Contains(nq, nf)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isFound(quote string, found []content.PullQuoteAsset) bool {
+	return normalisedQuote(quote) == "" || findQuote(quote, found) >= 0
+}
+
+// mergeQuotes adds the extracted quotes to those already found, e.g. the pullQuote assets, skipping any found twice,
+// but taking their attribution for any found without one, as pullQuote assets often are. The found quotes' attributions
+// are normalised against the people the article is about and the speakers of the extracted quotes.
+func mergeQuotes(found []content.PullQuoteAsset, extracted []content.PullQuoteAsset, people []string) []content.PullQuoteAsset {
+	s := newSpeakers(people)
+	for _, q := range extracted {
+		if name := strings.SplitN(q.Attribution, ",", 2)[0]; strings.TrimSpace(name) != "" {
+			s.resolve(name)
+		}
+	}
+
+	merged := append([]content.PullQuoteAsset{}, found...)
+	for i := range merged {
+		if merged[i].Attribution != "" {
+			merged[i].Attribution = s.normaliseAttribution(merged[i].Attribution)
+		}
+	}
+	for _, q := range extracted {
+		if normalisedQuote(q.Body) == "" {
+			continue
+		}
+		if i := findQuote(q.Body, merged); i >= 0 {
+			if merged[i].Attribution == "" {
+				merged[i].Attribution = q.Attribution
+			}
+			continue
+		}
+		merged = append(merged, q)
+	}
+	return merged
+}
@@ -0,0 +1,68 @@
+package pullquotes
+
+import (
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/content"
+)
+
+const body = `<body>
+<p>Shares fell sharply on Tuesday after the company cut its forecast for the third time this year.</p>
+<pull-quote><pull-quote-text><p>We are not in the business of making excuses</p></pull-quote-text><pull-quote-source>Jane Smith</pull-quote-source></pull-quote>
+<p>“We are not in the business of making excuses, and we never will be,” said Jane Smith, the chief executive.</p>
+<p>Analysts called the results “disappointing”. The board told the FT: “There is no plan to sell the business to anyone.”</p>
+<blockquote><p>Markets can stay irrational longer than you can stay solvent</p><footer>— John Maynard Keynes</footer></blockquote>
+<p>The chairman said the strategy was sound but that the timing had been wrong for a business of this size and age.</p>
+</body>`
+
+func TestExtractQuotes(t *testing.T) {
+	quotes := ExtractQuotes(body, nil, false)
+
+	expected := []content.PullQuoteAsset{
+		{Body: "We are not in the business of making excuses", Attribution: "Jane Smith", Source: content.QuoteSourceMarkup},
+		{Body: "Markets can stay irrational longer than you can stay solvent", Attribution: "John Maynard Keynes", Source: content.QuoteSourceMarkup},
+		{Body: "We are not in the business of making excuses, and we never will be", Attribution: "Jane Smith", Source: content.QuoteSourceSpeech},
+		{Body: "There is no plan to sell the business to anyone.", Source: content.QuoteSourceSpeech},
+	}
+	if len(quotes) != len(expected) {
+		t.Fatalf("expected %d quotes, got %d: %+v", len(expected), len(quotes), quotes)
+	}
+	for i, q := range quotes {
+		if q != expected[i] {
+			t.Errorf("quote %d: expected %+v, got %+v", i, expected[i], q)
+		}
+	}
+
+	quotes = ExtractQuotes(body, nil, true)
+	quotable := []content.PullQuoteAsset{}
+	for _, q := range quotes {
+		if q.Source == content.QuoteSourceQuotable {
+			quotable = append(quotable, q)
+		}
+	}
+	if len(quotable) != maxQuotablePerArticle || quotable[0].Body != "The chairman said the strategy was sound but that the timing had been wrong for a business of this size and age." {
+		t.Errorf("expected the most quotable sentences, the one turning on a but first, got %+v", quotable)
+	}
+}
+
+func TestMergeQuotes(t *testing.T) {
+	assets := []content.PullQuoteAsset{{Body: "We are not in the business of making excuses.", Source: content.QuoteSourceAsset}}
+	merged := mergeQuotes(assets, ExtractQuotes(body, nil, false), nil)
+
+	if len(merged) != 3 || merged[0].Source != content.QuoteSourceAsset || merged[1].Attribution != "John Maynard Keynes" {
+		t.Errorf("expected the asset, then the quotes not already found, got %+v", merged)
+	}
+	if merged[0].Attribution != "Jane Smith" {
+		t.Errorf("expected the asset to take the attribution of the same quote in the body, got %q", merged[0].Attribution)
+	}
+
+	// the assets' own attributions are normalised too, against the article's people and the speakers in its body
+	assets = []content.PullQuoteAsset{
+		{Body: "Growth will return next year.", Attribution: "Mr Patel, Acme", Source: content.QuoteSourceAsset},
+		{Body: "Markets can stay irrational longer than you can stay solvent.", Attribution: "Keynes", Source: content.QuoteSourceAsset},
+	}
+	merged = mergeQuotes(assets, ExtractQuotes(body, nil, false), []string{"Ravi Patel"})
+	if merged[0].Attribution != "Ravi Patel, Acme" || merged[1].Attribution != "John Maynard Keynes" {
+		t.Errorf("expected the assets' attributions normalised, got %q and %q", merged[0].Attribution, merged[1].Attribution)
+	}
+}
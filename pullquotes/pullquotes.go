@@ -1,31 +1,20 @@
 package pullquotes
 
 import (
-	"fmt"
-	"os"
-	"log"
+	"context"
 	// "regexp"
 	// "strings"
-	"strconv"
 	"time"
 	// "encoding/json"
-	"github.com/joho/godotenv"
-	. "github.com/gorilla/feeds"
+	"html"
+    "github.com/railsagainstignorance/alignment/card"
     "github.com/railsagainstignorance/alignment/content"
+    "github.com/railsagainstignorance/alignment/dedup"
+    "github.com/railsagainstignorance/alignment/feed"
     "github.com/railsagainstignorance/alignment/image"
+    "github.com/railsagainstignorance/alignment/logging"
 )
 
-func getEnvParam(key string, defaultValue string) string {
-	godotenv.Load()
-	value := os.Getenv(key)
-
-	if value == "" {
-		value = defaultValue
-	}
-
-	return value
-}
-
 var defaultImageUrl    = `https://www.ft.com/__origami/service/image/v2/images/raw/http%3A%2F%2Fprod-upp-image-read.ft.com%2F69f10230-2272-11e6-aa98-db1e01fabc0c?source=next&fit=scale-down&compression=best&width=600`
 var defaultImageWidth  = 600
 var defaultImageHeight = 338
@@ -42,10 +31,14 @@ type PullQuote struct {
 	ImageWidth     int
 	ImageHeight    int
 	ProminentColours *[]image.ProminentColour
-	PullQuoteAssets *[]content.PullQuoteAsset
+	ColourPair       image.ColourPair // a background and text colour from ProminentColours, with the contrast for WCAG AA
+	PullQuoteAssets *[]content.PullQuoteAsset // CAPI's pullQuote assets, then those found in the body, labelled by their Source
+	Categories      []string
 }
 
-func GetPullQuotesWithImages(ontologyName string, ontologyValue string, maxArticles int, maxMillis int) *[]*PullQuote {
+// GetPullQuotesWithImages finds the pull quotes of the latest articles matching the ontology, in their pullQuote assets
+// and their bodies, and, if quotable, their most quotable sentences too. With a speaker, only their quotes are kept.
+func GetPullQuotesWithImages(ctx context.Context, ontologyName string, ontologyValue string, maxArticles int, maxMillis int, quotable bool, speaker string) *[]*PullQuote {
 
 	sRequest := &content.SearchRequest{
 		QueryType:         ontologyName,
@@ -55,19 +48,24 @@ func GetPullQuotesWithImages(ontologyName string, ontologyValue string, maxArtic
 		SearchOnly:        false,
 	}
 
-	fmt.Println("GetPullQuotesWithImages: sRequest=", sRequest, ", maxArticles=", maxArticles, ", maxMillis=", maxMillis)
-
-	sapiResult := content.Search(sRequest)
+	logger := logging.FromContext(ctx)
+	logger.Info("pullquotes: GetPullQuotesWithImages", "ontology", ontologyName, "value", ontologyValue, "maxArticles", maxArticles, "maxMillis", maxMillis, "quotable", quotable, "speaker", speaker)
 
 	items := []*PullQuote {}
 
-	for i, article := range *(sapiResult.Articles) {
-		fmt.Println("GetPullQuotesWithImages: ", i, ") ", article.Title)
+	sapiResult, err := content.Search(ctx, sRequest)
+	if err != nil {
+		return &items
+	}
+
+	for _, article := range *(sapiResult.Articles) {
+		quotes := mergeQuotes( *article.PullQuoteAssets, ExtractQuotes(article.Body, article.People, quotable), article.People )
+		if speaker != "" {
+			quotes = filterBySpeaker( quotes, speaker )
+		}
+		logger.Debug("pullquotes: GetPullQuotesWithImages: article", "uuid", article.Uuid, "title", article.Title, "numPullQuoteAssets", len(*article.PullQuoteAssets), "numPullQuotes", len(quotes))
 
-		if len(*article.PullQuoteAssets) == 0 {
-			fmt.Println("GetPullQuotesWithImages: found no PullQuoteAssets")
-		} else {
-			fmt.Println("GetPullQuotesWithImages: found some PullQuoteAssets")
+		if len(quotes) > 0 {
 			
 			item := &PullQuote{
 					Author:          article.Author,
@@ -78,8 +76,11 @@ func GetPullQuotesWithImages(ontologyName string, ontologyValue string, maxArtic
 					ImageWidth:      article.ImageWidth,
 					ImageHeight:     article.ImageHeight,
 					PubDateString:   article.PubDateString,
-					PubDateEpoch:    article.PubDate.Unix(),
-					PullQuoteAssets: article.PullQuoteAssets,
+					PullQuoteAssets: &quotes,
+					Categories:      article.Categories,
+			}
+			if article.PubDate != nil {
+				item.PubDateEpoch = article.PubDate.Unix()
 			}
 
 			if item.ImageUrl == "" {
@@ -88,7 +89,8 @@ func GetPullQuotesWithImages(ontologyName string, ontologyValue string, maxArtic
 				item.ImageHeight = defaultImageHeight
 			} 
 
-			item.ProminentColours = image.GetProminentColours( item.ImageUrl )
+			item.ProminentColours = image.GetProminentColours( ctx, item.ImageUrl )
+			item.ColourPair       = image.RecommendColourPair( *item.ProminentColours, image.ContrastAA )
 
 			items = append( items, item )
 		}
@@ -97,78 +99,90 @@ func GetPullQuotesWithImages(ontologyName string, ontologyValue string, maxArtic
 	return &items
 }
 
-func pullQuotesToRss(pullQuotes *[]*PullQuote) *string {
+// FindQuote is the pull quote of the article with the uuid whose body has the fingerprint, as in its card's path,
+// and the article's image, found as GetPullQuotesWithImages would, quotable sentences and all. ok is false if there is none.
+func FindQuote(ctx context.Context, uuid string, fingerprint string) (quote content.PullQuoteAsset, imageUrl string, ok bool) {
+	article, err := content.GetArticle( ctx, uuid, false )
+	if err != nil || article.Uuid == "" {
+		return quote, "", false
+	}
+
+	quotes := mergeQuotes( *article.PullQuoteAssets, ExtractQuotes(article.Body, article.People, true), article.People )
+	for _, q := range quotes {
+		if dedup.Fingerprint(q.Body) == fingerprint {
+			imageUrl = article.ImageUrl
+			if imageUrl == "" {
+				imageUrl = defaultImageUrl
+			}
+			return q, imageUrl, true
+		}
+	}
+
+	logging.FromContext(ctx).Info("pullquotes: FindQuote: no such quote", "uuid", uuid, "fingerprint", fingerprint, "numPullQuotes", len(quotes))
+	return quote, "", false
+}
+
+func pullQuotesToFeed(pullQuotes *[]*PullQuote) *feed.Feed {
 	const siteUrl = "http://www.ft.com/"
 	now := time.Now()
 
-	feed := &Feed{
+	f := &feed.Feed{
 		Title:       "Pull Quotes from the latest Financial Times articles",
-		Link:        &Link{Href: siteUrl},
+		Url:         siteUrl,
 		Description: "Exploring the visceral impact of Pull Quotes in Financial Times articles.",
-		Author:      &Author{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"},
-		Created:     now,
+		Language:    "en-gb",
+		Authors:     []feed.Person{{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"}},
+		Updated:     now,
 		Copyright:   "This work is copyright © Financial Times",
 	}
 
-	feed.Items = []*Item{}
+	f.Items = []*feed.Item{}
 
 	for _, pq := range *pullQuotes {
 
-		created, _ := time.Parse("2006-01-02", pq.PubDateString)
-
-		for pqAssetI, pqAsset := range *pq.PullQuoteAssets {
-			guid       := pq.Url + "#" + strconv.Itoa(pqAssetI)
-			var description string
-			imgHtml := "<img src=" + pq.ImageUrl + "/>"
+		// left out of the feed, if not known
+		var published time.Time
+		if pq.PubDateEpoch != 0 {
+			published = time.Unix(pq.PubDateEpoch, 0).UTC()
+		}
+		authors   := []feed.Person{}
+		if pq.Author != "" {
+			authors = append( authors, feed.Person{Name: pq.Author} )
+		}
 
-			if pqAsset.Attribution == "" {
-				description = imgHtml + pqAsset.Body
-			} else {
-				description = imgHtml + pqAsset.Body + "<p>" + pqAsset.Attribution + "</p>"
+		for _, pqAsset := range *pq.PullQuoteAssets {
+			// by the quote's text, not its place among the article's, so the guid stays the same if they are reordered
+			guid       := pq.Url + "#" + dedup.Fingerprint(pqAsset.Body)
+			if pq.Uuid != "" {
+				guid = "https://www.ft.com/content/" + pq.Uuid + "#" + dedup.Fingerprint(pqAsset.Body)
+			}
+			description := `<img src="` + html.EscapeString(pq.ImageUrl) + `"/>` + "<blockquote>" + html.EscapeString(pqAsset.Body) + "</blockquote>"
+			if pqAsset.Attribution != "" {
+				description += "<p>" + html.EscapeString(pqAsset.Attribution) + "</p>"
 			}
 
-			feed.Items = append(feed.Items, &Item{
-				Title:       pq.Title,
-				Link:        &Link{Href: pq.Url},
-				Description: description,
-				Created:     created,
+			// registered, so the card is served at its path, made from the quote as here
+			cardPath := card.Path(card.KindPullQuote, pq.Uuid, pqAsset.Body)
+			card.Register( cardPath, &card.Spec{Text: pqAsset.Body, Attribution: pqAsset.Attribution, ImageUrl: pq.ImageUrl} )
+
+			f.Items = append(f.Items, &feed.Item{
 				Id:          guid,
+				Title:       pq.Title,
+				Url:         pq.Url,
+				ContentHtml: description,
+				Published:   published,
+				Authors:     authors,
+				Categories:  pq.Categories,
+				Image:       &feed.Image{Url: cardPath, Type: card.ContentType, Width: card.Width, Height: card.Height},
 			})
 		}
 	}
 
-	rss, _ := feed.ToRss()
-	return &rss
+	return f
 }
 
-func GenerateRss(ontologyName string, ontologyValue string, maxArticles int, maxMillis int) *string {
-	pqs := GetPullQuotesWithImages( ontologyName, ontologyValue, maxArticles, maxMillis )
-	rssString := pullQuotesToRss( pqs )
-	return rssString
-}
-
-func main() {
-	godotenv.Load()
-	var maxArticles, _ = strconv.Atoi( getEnvParam("MAX_ARTICLES",   "10") )
-	var maxMillis,   _ = strconv.Atoi( getEnvParam("MAX_MILLIS",   "3000") )
-
-	// pqs := GetPullQuotesWithImages( "before", "2016-12-06T19:00:00Z", maxArticles, maxMillis )
-	// pqsB, _ := json.Marshal(pqs)
-
- //    ofile, err := os.Create("pullquotes.json")
- //    if err != nil {
- //        log.Fatal("pullquotes:main: Cannot create file", err)
- //    }
- //    defer ofile.Close()
-
-	rssString := GenerateRss( "before", "2016-12-06T19:00:00Z", maxArticles, maxMillis )
-
-    ofile, err := os.Create("pullquotes.rss")
-    if err != nil {
-        log.Fatal("pullquotes:main: Cannot create file", err)
-    }
-    defer ofile.Close()
-
-
-    fmt.Fprint(ofile, *rssString)
+// GenerateFeed is the pull quotes of the latest articles matching the ontology, as a feed, to be written as RSS, Atom or JSON Feed.
+func GenerateFeed(ctx context.Context, ontologyName string, ontologyValue string, maxArticles int, maxMillis int, quotable bool, speaker string) *feed.Feed {
+	pqs := GetPullQuotesWithImages( ctx, ontologyName, ontologyValue, maxArticles, maxMillis, quotable, speaker )
+	return pullQuotesToFeed( pqs )
 }
\ No newline at end of file
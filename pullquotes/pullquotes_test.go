@@ -0,0 +1,34 @@
+package pullquotes
+
+import (
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/content"
+)
+
+func TestPullQuotesToFeed(t *testing.T) {
+	first := content.PullQuoteAsset{Body: "We are not in the business of making excuses", Attribution: "Jane Smith"}
+	second := content.PullQuoteAsset{Body: "There is no plan to sell the business to anyone"}
+	pq := func(epoch int64, assets ...content.PullQuoteAsset) *PullQuote {
+		return &PullQuote{
+			Url:             "https://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f",
+			Uuid:            "d2f40934-1792-11e6-b8d5-4c1fcdbe169f",
+			PubDateEpoch:    epoch,
+			PullQuoteAssets: &assets,
+		}
+	}
+
+	f := pullQuotesToFeed(&[]*PullQuote{pq(1488369600, first, second)})
+	reordered := pullQuotesToFeed(&[]*PullQuote{pq(1488369600, second, first)})
+	if len(f.Items) != 2 || f.Items[0].Id != reordered.Items[1].Id || f.Items[1].Id != reordered.Items[0].Id || f.Items[0].Id == f.Items[1].Id {
+		t.Errorf("expected each quote's guid to stay the same, whatever its place, got %q, %q and %q, %q", f.Items[0].Id, f.Items[1].Id, reordered.Items[0].Id, reordered.Items[1].Id)
+	}
+	if f.Items[0].Published.Unix() != 1488369600 {
+		t.Errorf("expected the article's publication date, got %v", f.Items[0].Published)
+	}
+
+	undated := pullQuotesToFeed(&[]*PullQuote{pq(0, first)})
+	if !undated.Items[0].Published.IsZero() {
+		t.Errorf("expected no publication date without one, got %v", undated.Items[0].Published)
+	}
+}
@@ -0,0 +1,229 @@
+// Package quota protects the expensive routes from being overused: each client (an authenticated principal, or else an IP)
+// gets a quota of requests per route, the scanning routes share a limit on how many run at once, and the max param
+// of each route is capped. Requests over a quota, or finding every scan slot taken, get a 429 with a Retry-After.
+package quota
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/railsagainstignorance/alignment/auth"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/metrics"
+)
+
+// BusyRetryAfter is how long a request finding every scan slot taken is told to wait.
+const BusyRetryAfter = 5 * time.Second
+
+var rejections = metrics.NewCounter("alignment_quota_rejections_total", "Requests turned away with a 429, by route and reason (quota or busy).", "route", "reason")
+
+// Rule is a route's quota: Requests per Period for each client (none, if 0), and the most its max param may be (any, if 0).
+type Rule struct {
+	Route    string
+	Requests int
+	Period   time.Duration
+	MaxCap   int
+}
+
+// ParseRules parses a comma separated list of route:requests/period:maxCap, e.g. "pullquotes:30/1m:20,detect:120/1m".
+func ParseRules(s string) (map[string]*Rule, error) {
+	rules := map[string]*Rule{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("quota: ParseRules: expected route:requests/period:maxCap, got %q", entry)
+		}
+		rule := Rule{Route: parts[0]}
+
+		rate := strings.Split(parts[1], "/")
+		var err error
+		if len(rate) != 2 {
+			err = fmt.Errorf("expected requests/period")
+		}
+		if err == nil {
+			rule.Requests, err = strconv.Atoi(rate[0])
+		}
+		if err == nil {
+			rule.Period, err = time.ParseDuration(rate[1])
+		}
+		if err == nil && len(parts) == 3 {
+			rule.MaxCap, err = strconv.Atoi(parts[2])
+		}
+		if err == nil && (rule.Requests < 0 || rule.Period <= 0 || rule.MaxCap < 0) {
+			err = fmt.Errorf("expected positive numbers")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("quota: ParseRules: %q: %s", entry, err)
+		}
+
+		rules[rule.Route] = &rule
+	}
+	return rules, nil
+}
+
+// bucket holds a client's unused requests, refilled steadily up to the rule's Requests.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	rule      *Rule
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// allow takes one of the client's requests, or says how long until it has one.
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	perSecond := float64(l.rule.Requests) / l.rule.Period.Seconds()
+	full := float64(l.rule.Requests)
+
+	// forget the clients whose buckets have refilled, so they don't accumulate
+	if now.Sub(l.lastSweep) > l.rule.Period {
+		for c, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*perSecond >= full {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: full, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(full, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+type Quotas struct {
+	rules          map[string]*Rule
+	limiters       map[string]*limiter
+	scans          chan struct{}
+	trustForwarded bool
+	now            func() time.Time
+}
+
+// New applies the rules, lets at most maxScans scanning requests run at once, and, if trustForwarded,
+// takes the client's IP from the X-Forwarded-For header added by a proxy in front, e.g. Heroku's router.
+func New(rules map[string]*Rule, maxScans int, trustForwarded bool) *Quotas {
+	q := Quotas{
+		rules:          rules,
+		limiters:       map[string]*limiter{},
+		scans:          make(chan struct{}, maxScans),
+		trustForwarded: trustForwarded,
+		now:            time.Now,
+	}
+	for route, rule := range rules {
+		if rule.Requests > 0 {
+			q.limiters[route] = &limiter{rule: rule, buckets: map[string]*bucket{}}
+		}
+	}
+	return &q
+}
+
+// ClientKey identifies who made the request: the principal, if authenticated as someone in particular, or else their IP.
+func (q *Quotas) ClientKey(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil && p.Provider != auth.ProviderNone {
+		return p.Provider + ":" + p.Name
+	}
+
+	if q.trustForwarded {
+		// the proxy appends the address it saw, so the last is the one which can't be made up by the client
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			return "ip:" + strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Limit wraps next, which serves route, in the route's quota and cap of its max param,
+// and, if it scans articles, in the limit of scans at once, as Scan.
+func (q *Quotas) Limit(route string, scans bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+
+		if l, ok := q.limiters[route]; ok {
+			client := q.ClientKey(r)
+			if allowed, retryAfter := l.allow(client, q.now()); !allowed {
+				logger.Warn("quota: Limit: over quota", "route", route, "client", client, "retryAfter", retryAfter)
+				rejections.With(route, "quota").Inc()
+				tooManyRequests(w, retryAfter, fmt.Sprintf("over the quota of %d requests per %s for %s", l.rule.Requests, l.rule.Period, route))
+				return
+			}
+		}
+
+		if rule, ok := q.rules[route]; ok && rule.MaxCap > 0 {
+			capMax(r, rule.MaxCap)
+		}
+
+		if scans {
+			next = q.Scan(route, next)
+		}
+		next(w, r)
+	}
+}
+
+// Scan wraps next, which serves route by scanning articles, in the limit of scans at once, and nothing else,
+// e.g. for a cached route, inside the cache, so only filling it takes a scan slot, not serving from it.
+func (q *Quotas) Scan(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case q.scans <- struct{}{}:
+			defer func() { <-q.scans }()
+		default:
+			logging.FromContext(r.Context()).Warn("quota: Scan: busy", "route", route, "maxScans", cap(q.scans))
+			rejections.With(route, "busy").Inc()
+			tooManyRequests(w, BusyRetryAfter, "too many scans running, try again shortly")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// capMax lowers the request's max param to maxCap, if above it, for the handler to read as usual with FormValue.
+func capMax(r *http.Request, maxCap int) {
+	r.ParseForm()
+	if i, err := strconv.Atoi(r.Form.Get("max")); err == nil && i > maxCap {
+		logging.FromContext(r.Context()).Debug("quota: capMax: lowered max", "max", i, "maxCap", maxCap)
+		r.Form.Set("max", strconv.Itoa(maxCap))
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "too many requests: "+msg, http.StatusTooManyRequests)
+}
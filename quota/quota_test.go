@@ -0,0 +1,86 @@
+package quota
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("pullquotes:30/1m:20, detect:120/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules["pullquotes"].Requests != 30 || rules["pullquotes"].Period != time.Minute || rules["pullquotes"].MaxCap != 20 || rules["detect"].MaxCap != 0 {
+		t.Errorf("unexpected rules %v %v", rules["pullquotes"], rules["detect"])
+	}
+
+	for _, s := range []string{"pullquotes", "pullquotes:30", "pullquotes:many/1m", "pullquotes:30/1m:-1"} {
+		if _, err := ParseRules(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	rules, _ := ParseRules("pullquotes:2/1m:20")
+	q := New(rules, 10, false)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	var seenMax string
+	h := q.Limit("pullquotes", true, func(w http.ResponseWriter, r *http.Request) {
+		seenMax = r.FormValue("max")
+	})
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/pullquotes/json?max=500", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusOK || seenMax != "20" {
+		t.Errorf("expected a 200 with max capped at 20, got %d and max=%s", w.Code, seenMax)
+	}
+	get("10.0.0.1:1234")
+	w := get("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected a 429, retry after 30s, got %d, %s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected another client to have its own quota, got %d", w.Code)
+	}
+
+	now = now.Add(30 * time.Second)
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the quota to have refilled by one, got %d", w.Code)
+	}
+}
+
+func TestBusy(t *testing.T) {
+	q := New(map[string]*Rule{}, 1, false)
+
+	started, finish := make(chan bool), make(chan bool)
+	h := q.Limit("align", true, func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-finish
+	})
+	go h(httptest.NewRecorder(), httptest.NewRequest("GET", "/align", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/align", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a 429 with a Retry-After while the other scan runs, got %d", w.Code)
+	}
+	close(finish)
+
+	// Scan, used inside the caches, takes from the same slots
+	w = httptest.NewRecorder()
+	q.Scan("pullquotes", func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest("GET", "/pullquotes/json", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected Scan to find the only slot taken too, got %d", w.Code)
+	}
+}
@@ -2,11 +2,14 @@ package rhyme
 
 import (
     "bufio"
+    "context"
     "os"
     "strings"
-    "fmt"
     "regexp"
     "sort"
+    "sync"
+    "github.com/railsagainstignorance/alignment/logging"
+    "github.com/railsagainstignorance/alignment/metrics"
 )
 
 const (
@@ -53,7 +56,7 @@ func readSyllables(filenames *[]string) (*map[string]*Word, int, int) {
 	badEnds := []string{}            
 
 	for _,filename := range *filenames {
-	    fmt.Println("rhyme: readSyllables: readingfrom: filename=", filename) 
+	    logging.Default().Info("rhyme: readSyllables: reading", "filename", filename)
 
 	    // Open the file.
 	    f, _ := os.Open(filename)
@@ -63,13 +66,13 @@ func readSyllables(filenames *[]string) (*map[string]*Word, int, int) {
 	    for scanner.Scan() {
 			line := strings.TrimSpace(scanner.Text())
 			if line == "" {
-				fmt.Println("WARNING: rhyme: readSyllables: empty line: ignoring")
+				logging.Default().Warn("rhyme: readSyllables: empty line: ignoring", "filename", filename)
 			} else if strings.HasPrefix(line, ";;;") {
 				// ignore it
 			} else {
 				nameAndRemainder := strings.Split(line, "  ")
 				if len(nameAndRemainder) != 2 {
-					fmt.Println("WARNING: rhyme: readSyllables: line doesn't split on double space:", line)
+					logging.Default().Warn("rhyme: readSyllables: line doesn't split on double space", "filename", filename, "line", line)
 				} else {
 					name      := nameAndRemainder[0]
 					remainder := nameAndRemainder[1]
@@ -104,7 +107,7 @@ func readSyllables(filenames *[]string) (*map[string]*Word, int, int) {
 				    	emphasisPointsString := strings.Join(emphasisPoints, "")
 
 				    	if numSyllables == 0 {
-				    		fmt.Println("WARNING: no syllables found for name=", name) 
+				    		logging.Default().Warn("rhyme: readSyllables: no syllables found", "name", name)
 				    		emphasisPointsString = unknownEmphasis
 				    	} else if numSyllables == 1 {
 				    		emphasisPointsString = loneSyllableEmphasis
@@ -115,7 +118,7 @@ func readSyllables(filenames *[]string) (*map[string]*Word, int, int) {
 				    	if matches != nil {
 				    		finalSyllable = matches[1]
 				    	} else {
-				    		fmt.Println("WARNING: no final syllable found for name=", name) 
+				    		logging.Default().Warn("rhyme: readSyllables: no final syllable found", "name", name)
 				    	}
 
 				    	countSyllables = countSyllables + numSyllables
@@ -196,7 +199,7 @@ type Syllabi struct {
     FinalSyllable  func(string) string
     FinalSyllableOfPhrase func(string) string
     SortPhrasesByFinalSyllable func( []string ) *RhymingPhrases
-    RhymeAndMetersOfPhrase func(string, ...*regexp.Regexp) *[]*RhymeAndMeter
+    RhymeAndMetersOfPhrase func(context.Context, string, ...*regexp.Regexp) *[]*RhymeAndMeter
     FindMatchingWord func(string) *Word
     KnownUnknowns func() *[]string
 	PhraseWordsRegexp            *regexp.Regexp
@@ -248,6 +251,7 @@ var (
 	acceptableMeterRegex = regexp.MustCompile(`^(\^*)([012 \.]*)(\$*)$`)
 	multipleSpacesRegex  = regexp.MustCompile(`\s\s+`)
 	DefaultMeter         = `01$`
+	HaikuMeter           = `..... ....... .....`
 	anchorAtStartChar    = "^"
 	anchorAtEndChar      = "$"
 	wordBoundaryChar     = `\b`
@@ -335,8 +339,150 @@ type MatchesOnMeter struct {
 	FinalDuringSyllableAZ string
 	FinalDuringWordWord *Word
 	SecondaryMatch *SecondaryMatch
+	SyllableAlignments *[]*SyllableAlignment
 }
 
+// SyllableAlignment records where one syllable of one word of a phrase landed against the meter.
+// MeterSlot is the position in the meter (ignoring spaces), or -1 if the syllable falls outside the match.
+// Matched means the syllable's own stress fits its slot, Forced means it only fits because of the
+// leniency in the meter regexp (e.g. a one-syllable word, or a secondary stress, taking a beat).
+type SyllableAlignment struct {
+	WordIndex     int
+	Word          string
+	SyllableIndex int
+	Phoneme       string
+	Stress        string
+	MeterSlot     int
+	MeterSymbol   string
+	Matched       bool
+	Forced        bool
+}
+
+func (sa *SyllableAlignment) InMeter() bool { return sa.MeterSlot >= 0 }
+
+var meterSlotRegexp = regexp.MustCompile(`\[(0|12|012)\\\*\]`)
+
+// MeterSlotsOfEmphasisRegexp recovers the sequence of meter symbols ("0", "1", ".")
+// from a regexp constructed by ConvertToEmphasisPointsStringRegexp.
+func MeterSlotsOfEmphasisRegexp(r *regexp.Regexp) []string {
+	slots := []string{}
+	for _,m := range meterSlotRegexp.FindAllStringSubmatch(r.String(), -1) {
+		switch m[1] {
+		case "0":
+			slots = append(slots, "0")
+		case "12":
+			slots = append(slots, "1")
+		default:
+			slots = append(slots, ".")
+		}
+	}
+	return slots
+}
+
+// syllableAlignmentsOfPhrase lists every syllable of the phrase in order, along with a map from each syllable's
+// offset in the EmphasisPointsCombinedString to its position in that list,
+// relying on each emphasis point being a single char.
+func syllableAlignmentsOfPhrase(epd *EmphasisPointsDetails) ([]SyllableAlignment, map[int]int) {
+	alignments := []SyllableAlignment{}
+	offsets    := map[int]int{}
+	offset     := 1 // skip the leading space of the combined string
+
+	for wi,eps := range epd.EmphasisPointsStrings {
+		word     := epd.MatchingWords[wi]
+		phonemes := []string{}
+		for _,f := range word.Fragments {
+			if syllableRegexp.MatchString(f) {
+				phonemes = append(phonemes, f)
+			}
+		}
+
+		for si,ep := range strings.Split(eps, "") {
+			phoneme := ""
+			stress  := ep
+			if si < len(phonemes) {
+				phoneme = phonemes[si]
+				stress  = syllableRegexp.FindStringSubmatch(phoneme)[1]
+			}
+
+			offsets[offset+si] = len(alignments)
+			alignments = append(alignments, SyllableAlignment{
+				WordIndex:     wi,
+				Word:          epd.PhraseWords[wi],
+				SyllableIndex: si,
+				Phoneme:       phoneme,
+				Stress:        stress,
+				MeterSlot:     -1,
+			})
+		}
+
+		offset = offset + len(eps) + 1
+	}
+
+	return alignments, offsets
+}
+
+// alignSyllablesOnMatch copies the phrase's syllables, assigning meter slots to those
+// within the [start,end) match of the EmphasisPointsCombinedString.
+func alignSyllablesOnMatch(phraseAlignments []SyllableAlignment, offsets map[int]int, meterSlots []string, start int, end int) *[]*SyllableAlignment {
+	alignments := []*SyllableAlignment{}
+	for i := range phraseAlignments {
+		sa := phraseAlignments[i]
+		alignments = append(alignments, &sa)
+	}
+
+	slot := 0
+	for offset := start; offset < end; offset++ {
+		i, ok := offsets[offset]
+		if !ok {
+			continue
+		}
+		sa := alignments[i]
+		sa.MeterSlot = slot
+		if slot < len(meterSlots) {
+			sa.MeterSymbol = meterSlots[slot]
+		}
+		switch sa.MeterSymbol {
+		case "0":
+			sa.Forced = (sa.Stress != "0")
+		case "1":
+			sa.Forced = (sa.Stress != "1")
+		}
+		sa.Matched = !sa.Forced
+		slot++
+	}
+
+	return &alignments
+}
+
+// wordCountsFromSyllableAlignments derives the number of words before, during and after a match
+// from which words the aligned syllables belong to.
+func wordCountsFromSyllableAlignments(alignments *[]*SyllableAlignment, numWords int) (int, int, int) {
+	first := -1
+	last  := -1
+	for _,sa := range *alignments {
+		if sa.InMeter() {
+			if first < 0 {
+				first = sa.WordIndex
+			}
+			last = sa.WordIndex
+		}
+	}
+
+	if first < 0 {
+		return numWords, 0, 0
+	}
+
+	return first, last - first + 1, numWords - last - 1
+}
+
+// wordLookups counts the words looked up in the dictionary, so the rate of unknown ones can be watched.
+// A word is looked up once for each thing asked of it, e.g. its syllables and its final syllable.
+var (
+	wordLookups        = metrics.NewCounter("alignment_syllabi_word_lookups_total", "Words looked up in the Syllabi dictionary, by result (known or unknown).", "result")
+	knownWordLookups   = wordLookups.With("known")
+	unknownWordLookups = wordLookups.With("unknown")
+)
+
 func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 	if sourceFilenames == nil {
 		sourceFilenames = &[]string{SyllableFilename}
@@ -345,7 +491,9 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 	words, numFragments, numSyllables := readSyllables(sourceFilenames)
 	finalSyllables := processFinalSyllables(words)
 
+	// counted by every scan at once, the request handlers', the jobs' and the ingester's, so behind a lock
 	knownUnknowns := map[string]int{}
+	var knownUnknownsMutex sync.Mutex
 
 	stats := Stats{
 		NumWords:                len(*words),
@@ -374,13 +522,17 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 		// then look up the word in the master list
 		if w,ok := (*words)[stringAsKey]; ok {
 			word = w
+			knownWordLookups.Inc()
 		} else {
+			unknownWordLookups.Inc()
+			knownUnknownsMutex.Lock()
 			if _,ok := knownUnknowns[stringAsKey]; ok {
 				knownUnknowns[stringAsKey]++
 			} else {
 				knownUnknowns[stringAsKey] = 1
-				fmt.Println("rhyme: findMatchingWord: new knownUnknown:", stringAsKey)
+				logging.Default().Debug("rhyme: findMatchingWord: new knownUnknown", "word", stringAsKey)
 			} 
+			knownUnknownsMutex.Unlock()
 
 			word = &Word{
 				Name:            s,
@@ -544,7 +696,7 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 		return allMatches
 	}
 
-	rhymeAndMetersOfPhrase := func(phrase string, emphasisRegexps ...*regexp.Regexp) (*[]*RhymeAndMeter) {
+	rhymeAndMetersOfPhrase := func(ctx context.Context, phrase string, emphasisRegexps ...*regexp.Regexp) (*[]*RhymeAndMeter) {
 
 		emphasisRegexp               := emphasisRegexps[0]
 		var emphasisRegexpSecondary *regexp.Regexp
@@ -561,6 +713,8 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 		finalWord := "" // ????
 
 		rams := []*RhymeAndMeter{}
+		phraseAlignments, syllableOffsets := syllableAlignmentsOfPhrase(emphasisPointsDetails)
+		meterSlots := MeterSlotsOfEmphasisRegexp(emphasisRegexp)
 		// allEmphasisRegexpIndexes := emphasisRegexp.FindAllStringIndex(emphasisPointsCombinedString, -1)
 		allEmphasisRegexpIndexes := findAllIndexIncludingOverlapping(emphasisRegexp, emphasisPointsCombinedString)
 
@@ -598,8 +752,17 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 					numBeforeDuring  := numBefore + numDuring
 					numTotal         := numBeforeDuring       + numAfter
 
+					syllableAlignments := alignSyllablesOnMatch(phraseAlignments, syllableOffsets, meterSlots, emphasisRegexpIndexes[0], emphasisRegexpIndexes[1])
+
 					if numTotal != len(phraseWords) {
-						fmt.Println("rhyme: rhymeAndMeterOfPhrase: matchesOnMeter: mismatched counts: numTotal=", numTotal, ", len(phraseWords)=", len(phraseWords))
+						logging.FromContext(ctx).Debug("rhyme: rhymeAndMeterOfPhrase: matchesOnMeter: mismatched counts: realigning on syllables", "numTotal", numTotal, "numPhraseWords", len(phraseWords))
+						numBefore, numDuring, numAfter = wordCountsFromSyllableAlignments(syllableAlignments, len(phraseWords))
+						numBeforeDuring = numBefore + numDuring
+						numTotal        = numBeforeDuring + numAfter
+					}
+
+					if numDuring == 0 {
+						logging.FromContext(ctx).Warn("rhyme: rhymeAndMeterOfPhrase: matchesOnMeter: no words during match", "phrase", phrase)
 					} else {
 						matchBefore        := ""
 						matchBeforeCropped := matchBefore
@@ -701,6 +864,7 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 							FinalDuringSyllableAZ: finalDuringSyllableAZ,
 							FinalDuringWordWord: finalDuringWordWord,
 							SecondaryMatch:  secondaryMatch,
+							SyllableAlignments: syllableAlignments,
 						}
 					}
 				}
@@ -752,9 +916,11 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 
 		list := []string{}
 
+		knownUnknownsMutex.Lock()
 		for k,_ := range knownUnknowns {
 			list = append(list, k)
 		}
+		knownUnknownsMutex.Unlock()
 
 		sort.Strings(list)
 
@@ -780,47 +946,3 @@ func ConstructSyllabi(sourceFilenames *[]string) (*Syllabi){
 
 	return &syllabi
 }
-
-func main() {
-	syllabi := ConstructSyllabi(&[]string{SyllableFilename})
-
-    fmt.Println("main: num words =", (*syllabi).Stats.NumWords ) 
-	fmt.Println("main: num unique final syllables =", (*syllabi).Stats.NumUniqueFinalSyllables)
-	fmt.Println("main: num fragments =", (*syllabi).Stats.NumFragments)
-	fmt.Println("main: num syllables =", (*syllabi).Stats.NumSyllables)
-
-	s := "hyperactivity"
-	rhymesWith := (*syllabi).FindRhymes(s)
-	sort.Strings(rhymesWith)
-	fmt.Println("main:", s, "rhymes with", len(rhymesWith), ": first=", rhymesWith[0], ", last=", rhymesWith[len(rhymesWith)-1])
-
-	numSyllables := (*syllabi).CountSyllables(s)
-	fmt.Println("main:", s, "has", numSyllables, "syllables")
-
-	ep := (*syllabi).EmphasisPoints(s)
-	fmt.Println("main:", s, "emphasisPoints=", strings.Join(ep, ","))
-
-	fs := (*syllabi).FinalSyllable(s)
-	fmt.Println("main:", s, "finalSyllable=", fs)
-
-	p := "bananas are the scourge of " + s
-	fsop := (*syllabi).FinalSyllableOfPhrase( p )
-	fmt.Println( "main:", p, ": final syllable of phrase=", fsop)
-
-	phrases := []string{
-		"I am a savanna",
-		"please fetch me my banjo.",
-		"give me my bandana",
-		"I am an armadillo",
-		"catch me if you can.",
-		"so so so.",
-	}
-	fmt.Println("main: phrases:")
-	fmt.Println(strings.Join(phrases, "\n"))
-
-	rps := (*syllabi).SortPhrasesByFinalSyllable( phrases)
-	fmt.Println("main: sorted rhyming phrases:")
-	for _,rp := range *rps {
-		fmt.Println("fs:", rp.FinalSyllable, ", phrase:", rp.Phrase)
-	}
-}
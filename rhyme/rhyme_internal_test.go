@@ -1,5 +1,20 @@
 package rhyme
 
 import (
-	"github.com/railsagainstignorance/alignment/align"
+	"testing"
 )
+
+func TestMeterSlotsOfEmphasisRegexp(t *testing.T) {
+	r, _ := ConvertToEmphasisPointsStringRegexp("01. 10$")
+	slots := MeterSlotsOfEmphasisRegexp(r)
+	expected := []string{"0", "1", ".", "1", "0"}
+
+	if len(slots) != len(expected) {
+		t.Fatalf("MeterSlotsOfEmphasisRegexp: got %v, expected %v", slots, expected)
+	}
+	for i := range expected {
+		if slots[i] != expected[i] {
+			t.Errorf("MeterSlotsOfEmphasisRegexp: slot %d: got %s, expected %s", i, slots[i], expected[i])
+		}
+	}
+}
@@ -1,5 +1,99 @@
 package rhyme_test
 
 import (
-	"github.com/railsagainstignorance/alignment/align"
+	"context"
+	"fmt"
+	"github.com/railsagainstignorance/alignment/rhyme"
+	"strings"
+	"sync"
+	"testing"
 )
+
+var syllabi = rhyme.ConstructSyllabi(&[]string{"cmudict-0.7b", "cmudict-0.7b_my_additions"})
+
+func TestSyllableAlignments(t *testing.T) {
+	phrase := "When I do count the clock that tells the time"
+	meter := "0101010101"
+	emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp(meter)
+	rams := syllabi.RhymeAndMetersOfPhrase(context.Background(), phrase, emphasisRegexp)
+
+	if len(*rams) != 1 {
+		t.Fatalf("RhymeAndMetersOfPhrase: got %d matches, expected 1", len(*rams))
+	}
+
+	mom := (*rams)[0].MatchesOnMeter
+	if mom.SyllableAlignments == nil || len(*mom.SyllableAlignments) != len(meter) {
+		t.Fatalf("SyllableAlignments: expected %d syllables, got %v", len(meter), mom.SyllableAlignments)
+	}
+
+	for i, sa := range *mom.SyllableAlignments {
+		if sa.MeterSlot != i {
+			t.Errorf("syllable %d: MeterSlot=%d", i, sa.MeterSlot)
+		}
+		if sa.MeterSymbol != meter[i:i+1] {
+			t.Errorf("syllable %d: MeterSymbol=%s, expected %s", i, sa.MeterSymbol, meter[i:i+1])
+		}
+		if sa.Matched == sa.Forced {
+			t.Errorf("syllable %d: Matched and Forced both %v", i, sa.Matched)
+		}
+		if sa.Forced != (sa.Stress != sa.MeterSymbol) {
+			t.Errorf("syllable %d: Forced=%v, but Stress=%s on MeterSymbol=%s", i, sa.Forced, sa.Stress, sa.MeterSymbol)
+		}
+	}
+}
+
+func TestSyllableAlignmentsOutsideMatch(t *testing.T) {
+	phrase := "bananas are the scourge of hyperactivity"
+	emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp("100100$")
+	rams := syllabi.RhymeAndMetersOfPhrase(context.Background(), phrase, emphasisRegexp)
+
+	if len(*rams) != 1 {
+		t.Fatalf("RhymeAndMetersOfPhrase: got %d matches, expected 1", len(*rams))
+	}
+
+	mom := (*rams)[0].MatchesOnMeter
+	if mom.During != "hyperactivity" {
+		t.Errorf("During=%q, expected hyperactivity", mom.During)
+	}
+
+	inMeter := []string{}
+	for _, sa := range *mom.SyllableAlignments {
+		if sa.InMeter() {
+			if sa.Word != "hyperactivity" {
+				t.Errorf("syllable of %q unexpectedly in meter", sa.Word)
+			}
+			inMeter = append(inMeter, sa.Phoneme)
+		} else if sa.Matched || sa.Forced {
+			t.Errorf("syllable outside meter marked Matched=%v Forced=%v", sa.Matched, sa.Forced)
+		}
+	}
+
+	expected := "AY2 ER0 AE0 IH1 IH0 IY0"
+	if strings.Join(inMeter, " ") != expected {
+		t.Errorf("in meter phonemes=%v, expected %s", inMeter, expected)
+	}
+}
+
+func TestUnknownWordsInParallel(t *testing.T) {
+	emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp("01")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				syllabi.RhymeAndMetersOfPhrase(context.Background(), fmt.Sprintf("the zzqxv%d and qqzzv%d", i, j), emphasisRegexp)
+				syllabi.KnownUnknowns()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	known := map[string]bool{}
+	for _, w := range *syllabi.KnownUnknowns() {
+		known[w] = true
+	}
+	if !known["ZZQXV0"] || !known["QQZZV49"] {
+		t.Errorf("expected the unknown words counted, got %d of them", len(known))
+	}
+}
@@ -2,17 +2,25 @@ package rss
 
 import (
 	"encoding/json"
-	"fmt"
-	. "github.com/gorilla/feeds"
-	"github.com/joho/godotenv"
+	"strings"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"time"
 	"crypto/md5"
     "encoding/hex"
+	"html"
+	"math"
     "html/template"
+	"mime"
+	"path"
 	"regexp"
+	"sort"
+	"sync"
+	"github.com/railsagainstignorance/alignment/card"
+	"github.com/railsagainstignorance/alignment/curation"
+	"github.com/railsagainstignorance/alignment/dedup"
+	"github.com/railsagainstignorance/alignment/feed"
+	"github.com/railsagainstignorance/alignment/logging"
 )
 
 func GetMD5Hash(text string) string {
@@ -21,24 +29,26 @@ func GetMD5Hash(text string) string {
     return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func getJsonUrl() string {
-	const jsonUrlEnvParamName = "HAIKU_JSON_URL"
+var jsonUrl string
 
-	godotenv.Load()
-	url := os.Getenv(jsonUrlEnvParamName)
+// with no haiku json url (or "local"), the haiku approved in the local curation store are used instead
+const localJsonUrl = "local"
 
-	if url == "" {
-		fmt.Println("rss: getJsonUrl: no such env param: ", jsonUrlEnvParamName)
+// SetHaikuJsonUrl sets where the haiku come from, before any are read.
+func SetHaikuJsonUrl(url string) {
+	jsonUrl = url
+}
+
+func getHaikuJsonBody() *[]byte {
+	if jsonUrl == "" || jsonUrl == localJsonUrl {
+		logging.Default().Debug("rss: getHaikuJsonBody: using local curation store")
+		return curation.DefaultStore().ApprovedJson(0)
 	}
 
-	return url
+	return getJsonBody(jsonUrl)
 }
 
-var jsonUrl = getJsonUrl()
-
 func getJsonBody(url string) *[]byte {
-	fmt.Println("rss: getJsonBody: url=", url)
-
 	req, err := http.NewRequest("GET", url, nil)
 	req.Header.Set("Content-Type", "application/json")
 	client := &http.Client{}
@@ -47,8 +57,7 @@ func getJsonBody(url string) *[]byte {
 		panic(err)
 	}
 	defer resp.Body.Close()
-	fmt.Println("rss: getJsonBody: response Status:", resp.Status)
-	fmt.Println("rss: getJsonBody: response:", resp)
+	logging.Default().Info("rss: getJsonBody", "url", url, "status", resp.StatusCode, "contentLength", resp.ContentLength)
 	jsonBody, _ := ioutil.ReadAll(resp.Body)
 
 	return &jsonBody
@@ -118,7 +127,9 @@ func parseJsonToGenerateItems(jsonBody *[]byte, maxItems int) *[]*Haiku {
 			}
 		}
 
-		description := "<strong>" + haiku + "</strong>" + "<BR>" + "-" + author
+		sort.Strings(themes) // rather than in the random order of the keys, so the feed doesn't change when the haiku haven't
+
+		description := haikuHtml(haiku, haikuRaw, author)
 
 		haikuStruct := Haiku{
 			Author:       author,
@@ -134,61 +145,178 @@ func parseJsonToGenerateItems(jsonBody *[]byte, maxItems int) *[]*Haiku {
 			TextRaw:      haikuRaw,
 			}
 
-		fmt.Println( "rss: parseJsonToGenerateItems: haikuStruct.Themes=", haikuStruct.Themes, ", uuid=", haikuStruct.Uuid)
+		logging.Default().Debug("rss: parseJsonToGenerateItems", "uuid", haikuStruct.Uuid, "themes", strings.Join(themes, ","))
 
 		items = append( items, &haikuStruct )
 	}
 	return &items
 }
 
-func itemsToRss(items *[]*Haiku) *string {
+// haikuHtml is the haiku in bold, from its raw lines, escaped, if known, or else its (already escaped) html,
+// followed by its author, escaped.
+func haikuHtml(haiku string, haikuRaw string, author string) string {
+	if haikuRaw != "" {
+		escapedLines := []string{}
+		for _, line := range strings.Split(strings.Replace(haikuRaw, "\r\n", "\n", -1), "\n") {
+			escapedLines = append(escapedLines, html.EscapeString(line))
+		}
+		haiku = strings.Join(escapedLines, "<br>")
+	}
+	return "<p><strong>" + haiku + "</strong><br>-" + html.EscapeString(author) + "</p>"
+}
+
+const dateSelectedLayout = "2006-01-02"
+
+func parseDateSelected(ds string) (time.Time, error) {
+	return time.Parse(dateSelectedLayout, ds)
+}
+
+// imageType is the media type of the image, by its URL's extension, or else image/jpeg, as most of the FT's are.
+func imageType(imageUrl string) string {
+	ext := path.Ext(strings.SplitN(imageUrl, "?", 2)[0])
+	if t := mime.TypeByExtension(ext); strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/jpeg"
+}
+
+// Haikus implements dedup.Documents, dating each haiku by when it was selected
+type Haikus []*Haiku
+
+func (hs Haikus) Len() int          { return len(hs) }
+func (hs Haikus) Text(i int) string { return hs[i].Text }
+func (hs Haikus) Date(i int) *time.Time {
+	if ds, err := parseDateSelected(hs[i].DateSelected); err == nil {
+		return &ds
+	}
+	return nil
+}
+
+// dedupeItems drops the later copies of any haiku selected more than once, e.g. from syndicated or updated articles
+func dedupeItems(items *[]*Haiku) *[]*Haiku {
+	deduped := []*Haiku{}
+	for _, group := range dedup.Group(Haikus(*items), dedup.DefaultThreshold) {
+		if len(group) > 1 {
+			logging.Default().Info("rss: dedupeItems: dropping duplicates", "uuid", (*items)[group[0]].Uuid, "numDuplicates", len(group)-1)
+		}
+		deduped = append(deduped, (*items)[group[0]])
+	}
+	return &deduped
+}
+
+func itemsToFeed(items *[]*Haiku) *feed.Feed {
 	const hiddenHaikuUrl = "http://www.ft.com/hidden-haiku"
 	now := time.Now()
 
-	feed := &Feed{
+	f := &feed.Feed{
 		Title:       "Haiku found verbatim in Financial Times articles",
-		Link:        &Link{Href: hiddenHaikuUrl},
+		Url:         hiddenHaikuUrl,
 		Description: "There are plenty more such haiku: identified by computer algorithm, selected by a human, and brought to light in ft.com/hidden-haiku.",
-		Author:      &Author{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"},
-		Created:     now,
+		Language:    "en-gb",
+		Authors:     []feed.Person{{Name: "Chris Gathercole", Email: "chris.gathercole@ft.com"}},
+		Updated:     now,
 		Copyright:   "This work is copyright © Financial Times",
 	}
 
-	feed.Items = []*Item{}
+	f.Items = []*feed.Item{}
 
 	for _, item := range *items {
-		guid       := item.Url + "#" + GetMD5Hash(item.Text)
-		created, _ := time.Parse("2006-01-02", item.DateSelected)
+		// by the article's uuid, if known, so the guid stays the same whichever form of the article's url the haiku has,
+		// and by its plain lines, not its html, so a change to the markup alone doesn't change it either
+		guid := item.Url + "#" + dedup.Fingerprint(item.CardText())
+		if item.Uuid != "" {
+			guid = "https://www.ft.com/content/" + item.Uuid + "#" + dedup.Fingerprint(item.CardText())
+		}
 
-		feed.Items = append(feed.Items, &Item{
-			Title:       item.Title,
-			Link:        &Link{Href: item.Url},
-			Description: item.Description,
-			Created:     created,
+		fItem := &feed.Item{
 			Id:          guid,
-		})
+			Title:       item.Title,
+			Url:         item.Url,
+			ContentHtml: item.Description,
+			Authors:     []feed.Person{{Name: item.Author}},
+			Categories:  *item.Themes,
+		}
+
+		if published, err := parseDateSelected(item.DateSelected); err == nil {
+			fItem.Published = published
+		} else {
+			logging.Default().Warn("rss: itemsToFeed: could not parse dateselected, so leaving out the pubDate", "uuid", item.Uuid, "dateSelected", item.DateSelected, "err", err)
+		}
+
+		// the haiku's card, over its image, or else the image itself, as a card needs the uuid for its path
+		if item.Uuid != "" {
+			cardPath := card.Path(card.KindHaiku, item.Uuid, item.CardText())
+			card.Register(cardPath, &card.Spec{Text: item.CardText(), Attribution: item.Author, ImageUrl: item.ImageUrl})
+			fItem.Image = &feed.Image{Url: cardPath, Type: card.ContentType, Width: card.Width, Height: card.Height}
+		} else if item.ImageUrl != "" {
+			fItem.Image = &feed.Image{Url: item.ImageUrl, Type: imageType(item.ImageUrl)}
+		}
+
+		f.Items = append(f.Items, fItem)
 	}
 
-	rss, _ := feed.ToRss()
-	return &rss
+	return f
 }
 
-func Generate(maxItems int) *string {
-	jsonBody := getJsonBody(jsonUrl)
-	items := parseJsonToGenerateItems( jsonBody, maxItems )
-	rssString := itemsToRss(items)
-	return rssString
-}
+// every haiku, as last loaded for a feed, so the cards of any can be found without loading them again
+var (
+	loadedItems      *[]*Haiku
+	loadedItemsMutex sync.RWMutex
+)
 
-func GenerateItems(maxItems int) *[]*Haiku {
-	jsonBody := getJsonBody(jsonUrl)
-	items := parseJsonToGenerateItems( jsonBody, maxItems )
+// loadItems is every haiku, deduped, kept for FindHaiku.
+func loadItems(jsonBody *[]byte) *[]*Haiku {
+	items := dedupeItems( parseJsonToGenerateItems( jsonBody, math.MaxInt32 ) )
+	loadedItemsMutex.Lock()
+	loadedItems = items
+	loadedItemsMutex.Unlock()
 	return items
 }
 
-func main() {
-	godotenv.Load()
-	rssString := Generate(10)
+// latestItems is the latest maxItems haiku, deduped before they are counted, so duplicates don't leave fewer than there are.
+func latestItems(jsonBody *[]byte, maxItems int) *[]*Haiku {
+	items := *loadItems( jsonBody )
+	if len(items) > maxItems {
+		items = items[:maxItems]
+	}
+	return &items
+}
+
+// GenerateFeed is the latest maxItems haiku, as a feed, to be written as RSS, Atom or JSON Feed.
+func GenerateFeed(maxItems int) *feed.Feed {
+	jsonBody := getHaikuJsonBody()
+	return itemsToFeed( latestItems( jsonBody, maxItems ) )
+}
+
+// FindHaiku is the haiku from the article with the uuid whose text has the fingerprint, as in its card's path, or nil if there is none,
+// among those last loaded for a feed, only loading them if none have been since the server started.
+func FindHaiku(uuid string, fingerprint string) *Haiku {
+	loadedItemsMutex.RLock()
+	items := loadedItems
+	loadedItemsMutex.RUnlock()
+	if items == nil {
+		items = loadItems( getHaikuJsonBody() )
+	}
 
-	fmt.Println("main: rssString=", *rssString)
+	for _, item := range *items {
+		if item.Uuid == uuid && dedup.Fingerprint(item.CardText()) == fingerprint {
+			return item
+		}
+	}
+	return nil
+}
+
+// CardText is the haiku as plain text, for its card, with a line break between its lines.
+func (h *Haiku) CardText() string {
+	if h.TextRaw != "" {
+		return strings.Replace(h.TextRaw, "\r\n", "\n", -1)
+	}
+	return html.UnescapeString(brRegexp.ReplaceAllString(h.Text, "\n"))
+}
+
+var brRegexp = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+func GenerateItems(maxItems int) *[]*Haiku {
+	jsonBody := getHaikuJsonBody()
+	return latestItems( jsonBody, maxItems )
 }
\ No newline at end of file
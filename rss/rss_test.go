@@ -0,0 +1,209 @@
+package rss
+
+import (
+	"encoding/xml"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const haikuJson = `[
+	{
+		"by": "Jane <Smith>",
+		"title": "Markets & ponds",
+		"articleurl": "http://www.ft.com/cms/s/0/d2f40934-1792-11e6-b8d5-4c1fcdbe169f.html",
+		"haikuhtml": "an old silent pond<br>a frog jumps into the pond<br>splash! silence again",
+		"haiku": "an old silent pond\na frog jumps <into> the pond\nsplash! silence again",
+		"dateselected": "2017-03-01",
+		"imageurl": "http://example.com/pond.png?width=600",
+		"nature": true,
+		"animals": true,
+		"spring": false
+	},
+	{
+		"by": "Joe Bloggs",
+		"title": "Undated",
+		"articleurl": "http://www.ft.com/hidden-haiku",
+		"haiku": "one\ntwo\nthree",
+		"dateselected": "last week",
+		"imageurl": "http://example.com/pond.png?width=600"
+	}
+]`
+
+// rssDoc is what the RSS 2.0 spec, http://www.rssboard.org/rss-specification, says a feed holds.
+type rssDoc struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       *string `xml:"title"`
+		Link        *string `xml:"link"`
+		Description *string `xml:"description"`
+		Items       []struct {
+			Title       string   `xml:"title"`
+			Link        string   `xml:"link"`
+			Description string   `xml:"description"`
+			Author      string   `xml:"author"`
+			Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			Categories  []string `xml:"category"`
+			Enclosures  []struct {
+				Url    *string `xml:"url,attr"`
+				Length *string `xml:"length,attr"`
+				Type   *string `xml:"type,attr"`
+			} `xml:"enclosure"`
+			Guid struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				Value       string `xml:",chardata"`
+			} `xml:"guid"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// validate checks the feed against the RSS 2.0 spec, returning it for any further checks.
+func validate(t *testing.T, b []byte) *rssDoc {
+	var doc rssDoc
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("expected well formed XML, got %s in\n%s", err, b)
+	}
+
+	if doc.Version != "2.0" {
+		t.Errorf("expected version 2.0, got %q", doc.Version)
+	}
+	if doc.Channel.Title == nil || doc.Channel.Link == nil || doc.Channel.Description == nil {
+		t.Errorf("expected the channel's required title, link and description")
+	}
+
+	guids := map[string]bool{}
+	for i, item := range doc.Channel.Items {
+		if item.Title == "" && item.Description == "" {
+			t.Errorf("item %d: expected a title or description", i)
+		}
+		if item.Link != "" {
+			if _, err := url.Parse(item.Link); err != nil {
+				t.Errorf("item %d: expected the link to be a url, got %q", i, item.Link)
+			}
+		}
+		if item.Author != "" {
+			if _, err := mail.ParseAddress(item.Author); err != nil {
+				t.Errorf("item %d: expected the author to be an email address, got %q", i, item.Author)
+			}
+		}
+		for _, c := range item.Categories {
+			if strings.TrimSpace(c) == "" {
+				t.Errorf("item %d: expected no empty categories", i)
+			}
+		}
+		if len(item.Enclosures) > 1 {
+			t.Errorf("item %d: expected at most one enclosure, got %d", i, len(item.Enclosures))
+		}
+		for _, e := range item.Enclosures {
+			if e.Url == nil || e.Length == nil || e.Type == nil {
+				t.Errorf("item %d: expected the enclosure's required url, length and type", i)
+				continue
+			}
+			if _, err := strconv.ParseInt(*e.Length, 10, 64); err != nil {
+				t.Errorf("item %d: expected the enclosure's length in bytes, got %q", i, *e.Length)
+			}
+		}
+		if item.Guid.Value == "" || guids[item.Guid.Value] {
+			t.Errorf("item %d: expected a unique guid, got %q", i, item.Guid.Value)
+		}
+		guids[item.Guid.Value] = true
+		if item.Guid.IsPermaLink != "true" && item.Guid.IsPermaLink != "false" {
+			t.Errorf("item %d: expected isPermaLink to be true or false, got %q", i, item.Guid.IsPermaLink)
+		}
+		if item.PubDate != "" {
+			if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+				t.Errorf("item %d: expected an RFC 822 pubDate, got %q", i, item.PubDate)
+			}
+		}
+	}
+
+	return &doc
+}
+
+func TestItemsToFeed(t *testing.T) {
+	jsonBody := []byte(haikuJson)
+	f := itemsToFeed(parseJsonToGenerateItems(&jsonBody, 10))
+	b, err := f.ToRss()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := validate(t, b)
+	if len(doc.Channel.Items) != 2 {
+		t.Fatalf("expected both haiku, got %d items", len(doc.Channel.Items))
+	}
+
+	item := doc.Channel.Items[0]
+	if !strings.HasPrefix(item.Guid.Value, "https://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f#") || item.Guid.IsPermaLink != "false" {
+		t.Errorf("expected a guid by the article's uuid, got %q (isPermaLink %s)", item.Guid.Value, item.Guid.IsPermaLink)
+	}
+	if item.Description != "<p><strong>an old silent pond<br>a frog jumps &lt;into&gt; the pond<br>splash! silence again</strong><br>-Jane &lt;Smith&gt;</p>" {
+		t.Errorf("expected the haiku and author escaped, got %q", item.Description)
+	}
+	if strings.Join(item.Creators, ",") != "Jane <Smith>" {
+		t.Errorf("expected the haiku's author as its creator, got %v", item.Creators)
+	}
+	if strings.Join(item.Categories, ",") != "animals,nature" {
+		t.Errorf("expected the themes, sorted, as categories, got %v", item.Categories)
+	}
+	if len(item.Enclosures) != 1 || !strings.HasPrefix(*item.Enclosures[0].Url, "/cards/haiku/d2f40934-1792-11e6-b8d5-4c1fcdbe169f/") || *item.Enclosures[0].Type != "image/png" {
+		t.Errorf("expected the haiku's card as an enclosure, got %+v", item.Enclosures)
+	}
+	if item.PubDate != "Wed, 01 Mar 2017 00:00:00 +0000" {
+		t.Errorf("expected the date selected as the pubDate, got %q", item.PubDate)
+	}
+
+	undated := doc.Channel.Items[1]
+	if undated.PubDate != "" {
+		t.Errorf("expected no pubDate for an unparseable date, got %q", undated.PubDate)
+	}
+	if len(undated.Enclosures) != 1 || *undated.Enclosures[0].Url != "http://example.com/pond.png?width=600" || *undated.Enclosures[0].Type != "image/png" {
+		t.Errorf("expected the image itself as an enclosure, without a uuid for a card, got %+v", undated.Enclosures)
+	}
+	if !strings.HasPrefix(undated.Guid.Value, "http://www.ft.com/hidden-haiku#") {
+		t.Errorf("expected a guid by the url without a uuid, got %q", undated.Guid.Value)
+	}
+}
+
+func TestCardText(t *testing.T) {
+	jsonBody := []byte(haikuJson)
+	items := *parseJsonToGenerateItems(&jsonBody, 10)
+
+	if got := items[0].CardText(); got != "an old silent pond\na frog jumps <into> the pond\nsplash! silence again" {
+		t.Errorf("expected the raw haiku, got %q", got)
+	}
+	items[0].TextRaw = ""
+	if got := items[0].CardText(); got != "an old silent pond\na frog jumps into the pond\nsplash! silence again" {
+		t.Errorf("expected the haiku's html as text, got %q", got)
+	}
+}
+
+func TestLatestItems(t *testing.T) {
+	jsonBody := []byte(`[
+		{"articleurl": "https://www.ft.com/content/17999e1c-a836-11e6-8b69-02899e8bd9d1", "haikuhtml": "an old silent pond<br>a frog jumps into the pond<br>splash! silence again", "dateselected": "2017-03-02"},
+		{"articleurl": "https://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f", "haikuhtml": "An old silent pond,<br>a frog jumps into the pond:<br>splash! Silence again.", "dateselected": "2017-03-01"},
+		{"articleurl": "https://www.ft.com/content/1f9974ea-f9e7-11e6-9516-2d969e0d3b65", "haikuhtml": "one<br>two<br>three", "dateselected": "2017-02-28"}
+	]`)
+
+	items := *latestItems(&jsonBody, 2)
+	if len(items) != 2 || items[0].Uuid != "d2f40934-1792-11e6-b8d5-4c1fcdbe169f" || items[1].Uuid != "1f9974ea-f9e7-11e6-9516-2d969e0d3b65" {
+		t.Errorf("expected the two distinct haiku, the later copy dropped before counting, got %d", len(items))
+	}
+}
+
+func TestGuidIgnoresMarkup(t *testing.T) {
+	jsonBody := []byte(`[
+		{"articleurl": "https://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f", "haikuhtml": "an old silent pond<br>a frog jumps into the pond<br>splash! silence again"},
+		{"articleurl": "https://www.ft.com/content/d2f40934-1792-11e6-b8d5-4c1fcdbe169f", "haikuhtml": "an old silent pond<br />a frog jumps into the pond<BR>splash! silence again"}
+	]`)
+
+	f := itemsToFeed(parseJsonToGenerateItems(&jsonBody, 10))
+	if f.Items[0].Id != f.Items[1].Id || f.Items[0].Image.Url != f.Items[1].Image.Url {
+		t.Errorf("expected the same guid and card for the same haiku in different markup, got %q and %q", f.Items[0].Id, f.Items[1].Id)
+	}
+}
@@ -0,0 +1,380 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+// Signal is one ingredient of a Score. Value is in the range [0,1], where higher is better.
+type Signal struct {
+	Name        string
+	Value       float64
+	Weight      float64
+	Explanation string
+}
+
+// Score combines the weighted Signals into a Total in the range [0,1].
+type Score struct {
+	Total   float64
+	Signals *[]*Signal
+}
+
+// Explanation lists each signal's contribution, for display alongside a match.
+func (s *Score) Explanation() string {
+	explanations := []string{}
+	for _, signal := range *s.Signals {
+		explanations = append(explanations, fmt.Sprintf("%s %.2f (x%.1f): %s", signal.Name, signal.Value, signal.Weight, signal.Explanation))
+	}
+	return strings.Join(explanations, "; ")
+}
+
+const (
+	badEndWeight        = 2.0
+	stopwordWeight      = 1.0
+	repeatedWordWeight  = 1.0
+	pronunciationWeight = 1.0
+	boundaryWeight      = 1.5
+	rarityWeight        = 1.0
+)
+
+var stopwords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a about above after again against all am an and any are as at be because been
+		before being below between both but by can could did do does doing down during each few for from further had has
+		have having he her here hers herself him himself his how i if in into is it its itself just me more most my myself
+		no nor not now of off on once only or other our ours ourselves out over own same she should so some such than that
+		the their theirs them themselves then there these they this those through to too under until up very was we were
+		what when where which while who whom why will with would you your yours yourself yourselves`) {
+		stopwords[w] = true
+	}
+}
+
+func isStopword(word string) bool {
+	return stopwords[strings.ToLower(word)]
+}
+
+// Scorer scores matches relative to a corpus of sentences, e.g. all the sentences scanned for an ontology query,
+// so that lexical rarity reflects what is unusual in that set of articles.
+type Scorer struct {
+	numPhrases      int
+	phraseFrequency map[string]int
+}
+
+// NewScorer counts how many of the sentences each word appears in,
+// splitting the sentences into words with the same regexp as the rhyme.Syllabi.
+func NewScorer(sentences []string, wordsRegexp *regexp.Regexp) *Scorer {
+	scorer := Scorer{
+		phraseFrequency: map[string]int{},
+	}
+
+	seenPhrases := map[string]bool{}
+	for _, sentence := range sentences {
+		if seenPhrases[sentence] {
+			continue
+		}
+		seenPhrases[sentence] = true
+		scorer.numPhrases++
+
+		seenWords := map[string]bool{}
+		for _, w := range wordsRegexp.FindAllString(sentence, -1) {
+			lw := strings.ToLower(w)
+			if !seenWords[lw] {
+				seenWords[lw] = true
+				scorer.phraseFrequency[lw]++
+			}
+		}
+	}
+
+	return &scorer
+}
+
+// linesOfMatch returns the words of each line of the match: one line per secondary match (e.g. haiku),
+// or the whole of the During words otherwise, along with the index of the first word in the phrase.
+func linesOfMatch(ram *rhyme.RhymeAndMeter) ([][]string, []*rhyme.Word, int) {
+	mom := ram.MatchesOnMeter
+	phraseWords := *ram.PhraseWords
+	start := mom.NumWordsBefore
+	end := start + mom.NumWordsDuring
+	if end > len(phraseWords) {
+		end = len(phraseWords)
+	}
+
+	lines := [][]string{}
+	finalWords := []*rhyme.Word{}
+	if mom.SecondaryMatch != nil {
+		for i, ws := range *mom.SecondaryMatch.WordsInEachMatch {
+			lines = append(lines, *ws)
+			finalWords = append(finalWords, (*mom.SecondaryMatch.FinalWordWordInEachMatch)[i])
+		}
+	} else {
+		lines = append(lines, phraseWords[start:end])
+		finalWords = append(finalWords, mom.FinalDuringWordWord)
+	}
+
+	return lines, finalWords, start
+}
+
+func (s *Scorer) badEndSignal(lines [][]string, finalWords []*rhyme.Word) *Signal {
+	badEnds := []string{}
+	for i, w := range finalWords {
+		// a blank line, e.g. from a blank line in a haiku, has no word to end on, weak or not
+		if i >= len(lines) || len(lines[i]) == 0 {
+			continue
+		}
+		if w == nil || w.IsBadEnd {
+			badEnds = append(badEnds, lines[i][len(lines[i])-1])
+		}
+	}
+
+	explanation := "no line ends on a weak word"
+	if len(badEnds) > 0 {
+		explanation = fmt.Sprintf("%d of %d lines end on a weak word (%s)", len(badEnds), len(lines), strings.Join(badEnds, ", "))
+	}
+
+	return &Signal{
+		Name:        "bad-end",
+		Value:       1.0 - float64(len(badEnds))/float64(len(lines)),
+		Weight:      badEndWeight,
+		Explanation: explanation,
+	}
+}
+
+func (s *Scorer) stopwordSignal(words []string) *Signal {
+	count := 0
+	for _, w := range words {
+		if isStopword(w) {
+			count++
+		}
+	}
+	density := float64(count) / float64(len(words))
+
+	return &Signal{
+		Name:        "stopwords",
+		Value:       1.0 - density,
+		Weight:      stopwordWeight,
+		Explanation: fmt.Sprintf("%d of %d words are stopwords", count, len(words)),
+	}
+}
+
+func (s *Scorer) repeatedWordSignal(words []string) *Signal {
+	seen := map[string]bool{}
+	repeated := []string{}
+	for _, w := range words {
+		lw := strings.ToLower(w)
+		if isStopword(lw) {
+			continue
+		}
+		if seen[lw] {
+			repeated = append(repeated, w)
+		}
+		seen[lw] = true
+	}
+
+	explanation := "no repeated words"
+	if len(repeated) > 0 {
+		explanation = "repeats " + strings.Join(repeated, ", ")
+	}
+
+	return &Signal{
+		Name:        "repeated-words",
+		Value:       1.0 - math.Min(1.0, 2.0*float64(len(repeated))/float64(len(words))),
+		Weight:      repeatedWordWeight,
+		Explanation: explanation,
+	}
+}
+
+// pronunciationSignal penalises matches which only fit the meter by forcing stresses,
+// and phrases containing words missing from the dictionary (whose syllables can only be guessed at).
+func (s *Scorer) pronunciationSignal(ram *rhyme.RhymeAndMeter) *Signal {
+	numInMeter := 0
+	numForced := 0
+	if ram.MatchesOnMeter.SyllableAlignments != nil {
+		for _, sa := range *ram.MatchesOnMeter.SyllableAlignments {
+			if sa.InMeter() {
+				numInMeter++
+				if sa.Forced {
+					numForced++
+				}
+			}
+		}
+	}
+
+	numUnknown := 0
+	for _, w := range *ram.MatchingWords {
+		if w.Unknown {
+			numUnknown++
+		}
+	}
+
+	value := 1.0
+	if numInMeter > 0 {
+		value = 1.0 - 0.5*float64(numForced)/float64(numInMeter)
+	}
+	if numUnknown > 0 {
+		value = value * 0.75
+	}
+
+	return &Signal{
+		Name:        "pronunciation",
+		Value:       value,
+		Weight:      pronunciationWeight,
+		Explanation: fmt.Sprintf("%d of %d syllables forced to fit, %d unrecognised words in the sentence", numForced, numInMeter, numUnknown),
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordEndOffsets locates each of the words, in order, in the phrase, returning the offset just after each.
+func wordEndOffsets(phrase string, words []string) []int {
+	offsets := []int{}
+	cursor := 0
+	for _, w := range words {
+		from := cursor
+		for {
+			i := strings.Index(phrase[from:], w)
+			if i < 0 {
+				break
+			}
+			start := from + i
+			end := start + len(w)
+			before, _ := utf8.DecodeLastRuneInString(phrase[:start])
+			after, _ := utf8.DecodeRuneInString(phrase[end:])
+			if (start == 0 || !isWordRune(before)) && (end == len(phrase) || !isWordRune(after)) {
+				cursor = end
+				break
+			}
+			from = start + 1
+		}
+		offsets = append(offsets, cursor)
+	}
+	return offsets
+}
+
+func isBoundaryRune(r rune) bool {
+	return unicode.IsPunct(r) && r != '\'' && r != '’'
+}
+
+// boundarySignal checks whether the match starts at the start of a sentence or clause,
+// and whether each line ends at the end of a sentence or clause.
+func (s *Scorer) boundarySignal(ram *rhyme.RhymeAndMeter, lines [][]string, startWord int) *Signal {
+	phrase := ram.Phrase
+	phraseWords := *ram.PhraseWords
+	ends := wordEndOffsets(phrase, phraseWords)
+
+	isBoundaryAfter := func(offset int) bool {
+		rest := strings.TrimLeftFunc(phrase[offset:], unicode.IsSpace)
+		if rest == "" {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(rest)
+		return isBoundaryRune(r)
+	}
+
+	numBoundaries := 1 + len(lines)
+	numAligned := 0
+	if startWord == 0 || isBoundaryAfter(ends[startWord-1]) {
+		numAligned++
+	}
+
+	wordIndex := startWord
+	for _, line := range lines {
+		wordIndex = wordIndex + len(line)
+		if wordIndex > 0 && wordIndex <= len(ends) && isBoundaryAfter(ends[wordIndex-1]) {
+			numAligned++
+		}
+	}
+
+	return &Signal{
+		Name:        "boundaries",
+		Value:       float64(numAligned) / float64(numBoundaries),
+		Weight:      boundaryWeight,
+		Explanation: fmt.Sprintf("%d of %d line starts/ends fall on a clause or sentence boundary", numAligned, numBoundaries),
+	}
+}
+
+// raritySignal averages the inverse phrase frequency of the non-stopwords, so matches made of words
+// which are unusual in the scanned articles score higher.
+func (s *Scorer) raritySignal(words []string) *Signal {
+	total := 0.0
+	count := 0
+	rarest := ""
+	rarestValue := -1.0
+	for _, w := range words {
+		if isStopword(w) {
+			continue
+		}
+		value := 1.0
+		if s.numPhrases > 1 {
+			df := s.phraseFrequency[strings.ToLower(w)]
+			if df < 1 {
+				df = 1
+			}
+			value = math.Log(float64(s.numPhrases)/float64(df)) / math.Log(float64(s.numPhrases))
+		}
+		total = total + value
+		count++
+		if value > rarestValue {
+			rarestValue = value
+			rarest = w
+		}
+	}
+
+	value := 0.0
+	explanation := "only stopwords"
+	if count > 0 {
+		value = total / float64(count)
+		explanation = fmt.Sprintf("rarest word is %s, across %d sentences", rarest, s.numPhrases)
+	}
+
+	return &Signal{
+		Name:        "rarity",
+		Value:       value,
+		Weight:      rarityWeight,
+		Explanation: explanation,
+	}
+}
+
+func (s *Scorer) ScoreRhymeAndMeter(ram *rhyme.RhymeAndMeter) *Score {
+	signals := []*Signal{}
+
+	lines, finalWords, startWord := linesOfMatch(ram)
+	words := []string{}
+	for _, line := range lines {
+		words = append(words, line...)
+	}
+
+	if len(words) > 0 {
+		signals = append(signals,
+			s.badEndSignal(lines, finalWords),
+			s.stopwordSignal(words),
+			s.repeatedWordSignal(words),
+			s.pronunciationSignal(ram),
+			s.boundarySignal(ram, lines, startWord),
+			s.raritySignal(words),
+		)
+	}
+
+	total := 0.0
+	totalWeight := 0.0
+	for _, signal := range signals {
+		total = total + signal.Value*signal.Weight
+		totalWeight = totalWeight + signal.Weight
+	}
+	if totalWeight > 0 {
+		total = total / totalWeight
+	}
+
+	return &Score{
+		Total:   total,
+		Signals: &signals,
+	}
+}
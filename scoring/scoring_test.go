@@ -0,0 +1,55 @@
+package scoring
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/rhyme"
+)
+
+var syllabi = rhyme.ConstructSyllabi(&[]string{"../rhyme/cmudict-0.7b", "../rhyme/cmudict-0.7b_my_additions"})
+
+func scoreOf(t *testing.T, scorer *Scorer, phrase string, meter string) *Score {
+	emphasisRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp(meter)
+	rams := syllabi.RhymeAndMetersOfPhrase(context.Background(), phrase, emphasisRegexp)
+	if len(*rams) == 0 {
+		t.Fatalf("no match for %q on meter %s", phrase, meter)
+	}
+	return scorer.ScoreRhymeAndMeter((*rams)[0])
+}
+
+func TestBadEndScoresLower(t *testing.T) {
+	good := "the clock that tells the time"
+	bad := "the clock that tells the"
+	scorer := NewScorer([]string{good, bad}, syllabi.PhraseWordsRegexp)
+
+	goodScore := scoreOf(t, scorer, good, "^......$")
+	badScore := scoreOf(t, scorer, bad, "^.....$")
+
+	if goodScore.Total <= badScore.Total {
+		t.Errorf("expected %q (%.2f) to outscore %q (%.2f): %s", good, goodScore.Total, bad, badScore.Total, badScore.Explanation())
+	}
+}
+
+func TestWordEndOffsets(t *testing.T) {
+	phrase := "a banana, and a pear"
+	offsets := wordEndOffsets(phrase, []string{"a", "banana", "and", "a", "pear"})
+	expected := []int{1, 8, 13, 15, 20}
+
+	for i := range expected {
+		if offsets[i] != expected[i] {
+			t.Errorf("wordEndOffsets: word %d: got %d, expected %d", i, offsets[i], expected[i])
+		}
+	}
+}
+
+func TestBadEndSignalWithBlankLine(t *testing.T) {
+	scorer := NewScorer([]string{}, syllabi.PhraseWordsRegexp)
+	lines := [][]string{{"an", "old", "silent", "pond"}, {}, {"splash", "the"}}
+	signal := scorer.badEndSignal(lines, []*rhyme.Word{nil, nil, nil})
+
+	if math.Abs(signal.Value-1.0/3.0) > 1e-9 {
+		t.Errorf("expected the two lines with words to end on weak words, and the blank line to be skipped, got %.2f: %s", signal.Value, signal.Explanation)
+	}
+}
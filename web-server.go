@@ -1,29 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"github.com/Financial-Times/ft-s3o-go/s3o"
-	"github.com/joho/godotenv"
 	"github.com/railsagainstignorance/alignment/align"
+	"github.com/railsagainstignorance/alignment/api"
 	"github.com/railsagainstignorance/alignment/article"
+	"github.com/railsagainstignorance/alignment/auth"
+	"github.com/railsagainstignorance/alignment/auth/s3oauth"
+	"github.com/railsagainstignorance/alignment/card"
+	"github.com/railsagainstignorance/alignment/config"
+	"github.com/railsagainstignorance/alignment/content"
+	"github.com/railsagainstignorance/alignment/curation"
+	"github.com/railsagainstignorance/alignment/feed"
+	"github.com/railsagainstignorance/alignment/httpcache"
+	"github.com/railsagainstignorance/alignment/ingest"
+	"github.com/railsagainstignorance/alignment/jobs"
+	"github.com/railsagainstignorance/alignment/logging"
+	"github.com/railsagainstignorance/alignment/metrics"
 	"github.com/railsagainstignorance/alignment/ontology"
 	"github.com/railsagainstignorance/alignment/rhyme"
 	"github.com/railsagainstignorance/alignment/rss"
 	"github.com/railsagainstignorance/alignment/pullquotes"
+	"github.com/railsagainstignorance/alignment/quota"
 	"github.com/railsagainstignorance/alignment/firstft"
 	"html/template"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"encoding/json"
 )
 
 // compile all templates and cache them
 var templates = template.Must(template.ParseGlob("templates/*"))
 
-// construct the syllable monster
-var syllabi = rhyme.ConstructSyllabi(&[]string{"rhyme/cmudict-0.7b", "rhyme/cmudict-0.7b_my_additions"})
+// the effective config, loaded in main
+var cfg *config.Config
+
+// the syllable monster, constructed in main from the configured dictionaries
+var syllabi *rhyme.Syllabi
 
 func templateExecuter(w http.ResponseWriter, pageName string, data interface{}) {
 	err := templates.ExecuteTemplate(w, pageName, data)
@@ -33,12 +55,35 @@ func templateExecuter(w http.ResponseWriter, pageName string, data interface{})
 	}
 }
 
+// jsonExecuter is the JSON counterpart of templateExecuter
+func jsonExecuter(w http.ResponseWriter, status int, data interface{}) {
+	jsonBody, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBody)
+}
+
+// wantsJson is true if the request asks for JSON, with ?format=json or an Accept header, instead of the HTML page
+func wantsJson(r *http.Request) bool {
+	return r.FormValue("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
 func alignFormHandler(w http.ResponseWriter, r *http.Request) {
 	templateExecuter(w, "alignPage", nil)
 }
 
 func alignHandler(w http.ResponseWriter, r *http.Request) {
-	p := align.Search(r.FormValue("text"), r.FormValue("source"))
+	p := align.Search(r.Context(), r.FormValue("text"), r.FormValue("source"))
+	w.Header().Add("Vary", "Accept")
+	if wantsJson(r) {
+		jsonExecuter(w, http.StatusOK, api.NewAlignResult(p))
+		return
+	}
 	templateExecuter(w, "alignedPage", p)
 }
 
@@ -46,7 +91,7 @@ func detailHandler(w http.ResponseWriter, r *http.Request) {
 	phrase := r.FormValue("phrase")
 	sentences := []string{phrase}
 	meter := r.FormValue("meter")
-	rams := article.FindRhymeAndMetersInSentences(&sentences, meter, syllabi)
+	rams := article.FindRhymeAndMetersInSentences(r.Context(), &sentences, meter, syllabi)
 	meterRegexp, _ := rhyme.ConvertToEmphasisPointsStringRegexp(meter)
 
 	type PhraseDetails struct {
@@ -72,12 +117,46 @@ func detailHandler(w http.ResponseWriter, r *http.Request) {
 	templateExecuter(w, "detailPage", pd)
 }
 
-func ontologyHandler(w http.ResponseWriter, r *http.Request) {
-	ontologyName := r.FormValue("ontology")
-	ontologyValue := r.FormValue("value")
-	meter := r.FormValue("meter")
+const maxApiRequestBytes = 1 << 20
+
+// apiDetectHandler accepts either a POSTed JSON api.DetectRequest,
+// or form values text (repeatable, for a batch), meter and form.
+func apiDetectHandler(w http.ResponseWriter, r *http.Request) {
+	req := api.DetectRequest{}
+
+	if r.Method == "POST" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiRequestBytes))
+		if err := decoder.Decode(&req); err != nil {
+			jsonExecuter(w, http.StatusBadRequest, api.ErrorResponse{Version: api.Version, Error: "invalid JSON: " + err.Error()})
+			return
+		}
+	} else {
+		r.ParseForm()
+		req.Texts = r.Form["text"]
+		req.Meter = r.FormValue("meter")
+		req.Form = r.FormValue("form")
+	}
+
+	resp, err := api.Detect(r.Context(), &req, syllabi)
+	if err != nil {
+		jsonExecuter(w, http.StatusBadRequest, api.ErrorResponse{Version: api.Version, Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	jsonExecuter(w, http.StatusOK, resp)
+}
 
-	maxArticles := 10
+type OntologyParams struct {
+	OntologyName  string
+	OntologyValue string
+	Meter         string
+	MaxArticles   int
+	MaxMillis     int
+}
+
+func ontologyParams(r *http.Request) *OntologyParams {
+	maxArticles := cfg.OntologyMaxArticles
 	if r.FormValue("max") != "" {
 		i, err := strconv.Atoi(r.FormValue("max"))
 		if err == nil {
@@ -85,22 +164,79 @@ func ontologyHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	maxMillis := 30000
+	return &OntologyParams{
+		OntologyName:  r.FormValue("ontology"),
+		OntologyValue: r.FormValue("value"),
+		Meter:         r.FormValue("meter"),
+		MaxArticles:   maxArticles,
+		MaxMillis:     cfg.OntologyMaxMillis,
+	}
+}
+
+func ontologyHandler(w http.ResponseWriter, r *http.Request) {
+	p := ontologyParams(r)
+
+	// the streamed page fetches its results from ontologyEventsHandler as they are found
+	if r.FormValue("stream") == "true" && !wantsJson(r) {
+		templateExecuter(w, "ontologyStreamPage", p)
+		return
+	}
 
-	details, containsHaikus := ontology.GetDetails(syllabi, ontologyName, ontologyValue, meter, maxArticles, maxMillis)
+	details, containsHaikus := ontology.GetDetailsContext(r.Context(), syllabi, p.OntologyName, p.OntologyValue, p.Meter, p.MaxArticles, p.MaxMillis, nil)
 
-	if containsHaikus {
+	w.Header().Add("Vary", "Accept")
+	if wantsJson(r) {
+		jsonExecuter(w, http.StatusOK, api.NewOntologyResult(details))
+	} else if containsHaikus {
 		templateExecuter(w, "ontologyHaikuPage", details)
 	} else {
 		templateExecuter(w, "ontologyPage", details)
 	}
 }
 
+// ontologyEventsHandler streams the ontology results as Server-Sent Events: an "article" event (an api.ArticleProgress)
+// as each article is processed, then a "done" event (an api.OntologyResult) with everything scored and ranked.
+// If the client goes away, the remaining articles are not processed.
+func ontologyEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	writeEvent := func(event string, data interface{}) {
+		jsonBody, err := json.Marshal(data)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("web-server: ontologyEventsHandler: could not marshal event", "event", event, "err", err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonBody)
+		flusher.Flush()
+	}
+
+	ctx := r.Context()
+	p := ontologyParams(r)
+	details, _ := ontology.GetDetailsContext(ctx, syllabi, p.OntologyName, p.OntologyValue, p.Meter, p.MaxArticles, p.MaxMillis, func(numDone int, numTotal int, a *article.ArticleWithSentencesAndMeter) {
+		writeEvent("article", api.NewArticleProgress(numDone, numTotal, a))
+	})
+
+	if ctx.Err() != nil {
+		logging.FromContext(ctx).Info("web-server: ontologyEventsHandler: client went away", "numArticles", details.NumArticles)
+		return
+	}
+
+	writeEvent("done", api.NewOntologyResult(details))
+}
+
 func pullquotesRssHandler(w http.ResponseWriter, r *http.Request) {
 	ontologyName := r.FormValue("ontology")
 	ontologyValue := r.FormValue("value")
 
-	maxArticles := 10
+	maxArticles := cfg.PullQuotesMaxArticles
 	if r.FormValue("max") != "" {
 		i, err := strconv.Atoi(r.FormValue("max"))
 		if err == nil {
@@ -108,18 +244,21 @@ func pullquotesRssHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	maxMillis := 30000
+	maxMillis := cfg.PullQuotesMaxMillis
+
+	quotable := r.FormValue("quotable") == "true"
 
-	rssText := pullquotes.GenerateRss(ontologyName, ontologyValue, maxArticles, maxMillis)
-	w.Header().Set("Content-Type", "application/rss+xml")
-	fmt.Fprintf(w, *rssText)
+	speaker := r.FormValue("speaker")
+
+	f := pullquotes.GenerateFeed(r.Context(), ontologyName, ontologyValue, maxArticles, maxMillis, quotable, speaker)
+	writeFeed(w, r, f)
 }
 
 func pullquotesJsonHandler(w http.ResponseWriter, r *http.Request) {
 	ontologyName := r.FormValue("ontology")
 	ontologyValue := r.FormValue("value")
 
-	maxArticles := 10
+	maxArticles := cfg.PullQuotesMaxArticles
 	if r.FormValue("max") != "" {
 		i, err := strconv.Atoi(r.FormValue("max"))
 		if err == nil {
@@ -127,9 +266,13 @@ func pullquotesJsonHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	maxMillis := 30000
+	maxMillis := cfg.PullQuotesMaxMillis
+
+	quotable := r.FormValue("quotable") == "true"
 
-	pullQuotes := pullquotes.GetPullQuotesWithImages(ontologyName, ontologyValue, maxArticles, maxMillis)
+	speaker := r.FormValue("speaker")
+
+	pullQuotes := pullquotes.GetPullQuotesWithImages(r.Context(), ontologyName, ontologyValue, maxArticles, maxMillis, quotable, speaker)
 	pqJsonB, _ := json.Marshal(pullQuotes)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -138,16 +281,14 @@ func pullquotesJsonHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func rssHandler(w http.ResponseWriter, r *http.Request) {
-	maxItems := 20
-	rssText := rss.Generate(maxItems)
-	w.Header().Set("Content-Type", "application/rss+xml")
+	maxItems := cfg.RssMaxItems
+	f := rss.GenerateFeed(maxItems)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	fmt.Fprintf(w, *rssText)
+	writeFeed(w, r, f)
 }
 
 func carouselHandler(w http.ResponseWriter, r *http.Request) {
-	maxItems := 20
+	maxItems := cfg.RssMaxItems
 	items := rss.GenerateItems(maxItems)
 
 	type CarouselDetails struct {
@@ -169,7 +310,7 @@ func meditationHandler(w http.ResponseWriter, r *http.Request) {
 
 func firstftRssHandler(w http.ResponseWriter, r *http.Request) {
 
-	maxArticles := 2
+	maxArticles := cfg.FirstFTMaxArticles
 	if r.FormValue("max") != "" {
 		i, err := strconv.Atoi(r.FormValue("max"))
 		if err == nil {
@@ -179,39 +320,554 @@ func firstftRssHandler(w http.ResponseWriter, r *http.Request) {
 
 	includeActualFirstFTArticle := false
 
-	rssText := firstft.GenerateRss( maxArticles, includeActualFirstFTArticle )
-	w.Header().Set("Content-Type", "application/rss+xml")
-	fmt.Fprint(w, *rssText)
+	f := firstft.GenerateFeed( r.Context(), maxArticles, cfg.FirstFTMaxMillis, includeActualFirstFTArticle )
+	writeFeed(w, r, f)
+}
+
+// negotiateFeed settles the format of a feed route's response, from its format param or else its Accept header,
+// into the format param, ahead of the cache, so each format is cached apart.
+func negotiateFeed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		r.Form.Set("format", feed.Negotiate(r.Form.Get("format"), r.Header.Get("Accept")))
+		next(w, r)
+	}
+}
+
+// writeFeed writes f in the format settled by negotiateFeed, linking it to itself in that format.
+func writeFeed(w http.ResponseWriter, r *http.Request, f *feed.Feed) {
+	format := r.FormValue("format")
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	f.FeedUrl = scheme + "://" + r.Host + r.URL.Path + "?" + r.Form.Encode()
+	f.ResolveUrls(f.FeedUrl)
+
+	body, err := f.Write(format)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("web-server: writeFeed: could not write the feed", "format", format, "err", err)
+		http.Error(w, "could not write the feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", feed.ContentTypes[feed.Negotiate(format, "")])
+	w.Header().Set("Vary", "Accept")
+	w.Write(body)
+}
+
+// cardsHandler renders the card of a pull quote or haiku, at the path given it in the feeds, as registered when they were made,
+// or else, e.g. since a restart, found again by the article's uuid and the fingerprint of the text in the path.
+func cardsHandler(w http.ResponseWriter, r *http.Request) {
+	spec, ok := card.Lookup(r.URL.Path)
+	if !ok {
+		spec = findCard(r.Context(), r.URL.Path)
+	}
+	if spec == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := card.Render(card.New(r.Context(), spec.Text, spec.Attribution, spec.ImageUrl))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("web-server: cardsHandler: could not render the card", "path", r.URL.Path, "err", err)
+		http.Error(w, "could not render the card", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", card.ContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(body)
+}
+
+// findCard is what the card at path is made from, found again from its article's pull quotes or the haiku, registered
+// so it needn't be again, or nil if there is no such card.
+func findCard(ctx context.Context, path string) *card.Spec {
+	kind, uuid, fingerprint, ok := card.ParsePath(path)
+	if !ok {
+		return nil
+	}
+
+	var spec *card.Spec
+	switch kind {
+	case card.KindPullQuote:
+		if quote, imageUrl, found := pullquotes.FindQuote(ctx, uuid, fingerprint); found {
+			spec = &card.Spec{Text: quote.Body, Attribution: quote.Attribution, ImageUrl: imageUrl}
+		}
+	case card.KindHaiku:
+		if haiku := rss.FindHaiku(uuid, fingerprint); haiku != nil {
+			spec = &card.Spec{Text: haiku.CardText(), Attribution: haiku.Author, ImageUrl: haiku.ImageUrl}
+		}
+	}
+	if spec != nil {
+		card.Register(path, spec)
+	}
+	return spec
 }
 
+func curationHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.FormValue("status")
+
+	type CurationDetails struct {
+		Status   string
+		Statuses []string
+		Haikus   *[]*curation.Haiku
+	}
+
+	cd := CurationDetails{
+		Status:   status,
+		Statuses: []string{curation.StatusPending, curation.StatusApproved, curation.StatusRejected},
+		Haikus:   curation.DefaultStore().List(status),
+	}
+
+	templateExecuter(w, "curationPage", cd)
+}
+
+// curationActionHandler applies an editor's action either to a haiku already in the store (by id),
+// or to a new candidate posted from the ontology page.
+func curationActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "curation actions must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	store := curation.DefaultStore()
+	action := r.FormValue("action")
+
+	var candidate *curation.Haiku
+	if id := r.FormValue("id"); id != "" {
+		candidate = store.Get(id)
+		if candidate == nil {
+			http.Error(w, "no such haiku: id="+id, http.StatusNotFound)
+			return
+		}
+		candidate.Themes = nil
+	} else {
+		r.ParseForm()
+		candidate = &curation.Haiku{
+			Uuid:     r.FormValue("uuid"),
+			Url:      r.FormValue("url"),
+			Title:    r.FormValue("title"),
+			Author:   r.FormValue("author"),
+			ImageUrl: r.FormValue("imageurl"),
+			PubDateString: r.FormValue("pubdate"),
+			Text:     strings.Join(r.Form["line"], "\n"),
+		}
+	}
+
+	candidate.Lines = curation.SplitLines(r.FormValue("lines"))
+	if themes := r.FormValue("themes"); themes != "" || action == curation.ActionTag {
+		candidate.Themes = curation.SplitThemes(themes)
+	}
+
+	h, err := store.Apply(action, candidate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/curation?status="+h.Status+"#"+h.Id, http.StatusSeeOther)
+}
+
+func curationHaikuJsonHandler(w http.ResponseWriter, r *http.Request) {
+	maxItems := 0
+	if r.FormValue("max") != "" {
+		i, err := strconv.Atoi(r.FormValue("max"))
+		if err == nil {
+			maxItems = i
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(*curation.DefaultStore().ApprovedJson(maxItems))
+}
+
+// configHandler shows the effective config, with the secrets redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	jsonExecuter(w, http.StatusOK, cfg.Redacted())
+}
+
+// the background ingester, if INGEST_ENABLED
+var ingester *ingest.Ingester
+
+func ingestStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status := &ingest.Status{}
+	if ingester != nil {
+		status = ingester.Status()
+	}
+
+	jsonExecuter(w, http.StatusOK, status)
+}
+
+// the background scans submitted via /jobs
+var jobManager *jobs.Manager
+
+func jobsErrorStatus(err error) int {
+	switch err {
+	case jobs.ErrNotFound:
+		return http.StatusNotFound
+	case jobs.ErrFinished, jobs.ErrNotDone:
+		return http.StatusConflict
+	case jobs.ErrNoQuery, jobs.ErrUnknownForm:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func jobsError(w http.ResponseWriter, err error) {
+	jsonExecuter(w, jobsErrorStatus(err), api.ErrorResponse{Version: api.Version, Error: err.Error()})
+}
+
+// jobsHandler lists the jobs on GET, and submits one on POST,
+// either as a JSON jobs.Request or with form values ontology, value, meter, form, max and maxMillis.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		jsonExecuter(w, http.StatusOK, jobManager.List())
+		return
+	}
+
+	req := jobs.Request{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiRequestBytes))
+		if err := decoder.Decode(&req); err != nil {
+			jsonExecuter(w, http.StatusBadRequest, api.ErrorResponse{Version: api.Version, Error: "invalid JSON: " + err.Error()})
+			return
+		}
+	} else {
+		req.Ontology = r.FormValue("ontology")
+		req.Value = r.FormValue("value")
+		req.Meter = r.FormValue("meter")
+		req.Form = r.FormValue("form")
+		req.MaxArticles, _ = strconv.Atoi(r.FormValue("max"))
+		req.MaxMillis, _ = strconv.Atoi(r.FormValue("maxMillis"))
+	}
+
+	job, err := jobManager.Submit(req)
+	if err != nil {
+		jobsError(w, err)
+		return
+	}
+
+	jsonExecuter(w, http.StatusAccepted, job)
+}
+
+// jobsStatusHandler shows a job's progress, with its partial results while it runs, and its final results once done.
+func jobsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job := jobManager.Get(r.FormValue("id"))
+	if job == nil {
+		jobsError(w, jobs.ErrNotFound)
+		return
+	}
+	jsonExecuter(w, http.StatusOK, job)
+}
+
+func jobsCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	if err := jobManager.Cancel(id); err != nil {
+		jobsError(w, err)
+		return
+	}
+	jsonExecuter(w, http.StatusOK, jobManager.Get(id))
+}
+
+// jobsResultsHandler downloads the final results of a job, as JSON (an api.OntologyResult) or, with format=csv, as CSV.
+func jobsResultsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	result, err := jobManager.Result(id)
+	if err != nil {
+		jobsError(w, err)
+		return
+	}
+
+	if r.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="job-`+id+`.csv"`)
+		api.WriteOntologyCSV(w, result)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="job-`+id+`.json"`)
+	jsonExecuter(w, http.StatusOK, result)
+}
+
+// Health is reported by /healthz and /readyz.
+type Health struct {
+	Status          string // ok, or unavailable
+	Dictionary      string // the size of the loaded dictionary, or empty if there is none
+	Upstream        string // ok, or why the FT search API could not be reached
+	UpstreamChecked string
+}
+
+const upstreamCheckInterval = 30 * time.Second
+const upstreamCheckTimeout = 5 * time.Second
+
+// upstreamCheck remembers whether the FT search API was reachable, so frequent probes don't hit it every time.
+type upstreamCheck struct {
+	mutex   sync.Mutex
+	checked time.Time
+	err     error
+}
+
+var upstream upstreamCheck
+
+func (u *upstreamCheck) get() (time.Time, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if time.Since(u.checked) > upstreamCheckInterval {
+		u.err = content.CheckSapi(upstreamCheckTimeout)
+		u.checked = time.Now()
+		if u.err != nil {
+			logging.Default().Warn("web-server: upstreamCheck: FT search API unreachable", "err", u.err)
+		}
+	}
+	return u.checked, u.err
+}
+
+func health() (*Health, bool, bool) {
+	h := Health{Status: "ok", Upstream: "ok"}
+
+	dictionaryLoaded := syllabi != nil && syllabi.Stats.NumWords > 0
+	if dictionaryLoaded {
+		h.Dictionary = fmt.Sprintf("words=%d, syllables=%d", syllabi.Stats.NumWords, syllabi.Stats.NumSyllables)
+	}
+
+	checked, err := upstream.get()
+	h.UpstreamChecked = checked.Format(time.RFC3339)
+	if err != nil {
+		h.Upstream = err.Error()
+	}
+
+	return &h, dictionaryLoaded, err == nil
+}
+
+// healthzHandler is the liveness check: the server is up, with its dictionary. The upstream is reported, but not required.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	h, dictionaryLoaded, _ := health()
+	status := http.StatusOK
+	if !dictionaryLoaded {
+		h.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	jsonExecuter(w, status, h)
+}
+
+// readyzHandler is the readiness check: the server can scan articles, so needs the upstream too.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	h, dictionaryLoaded, upstreamReachable := health()
+	status := http.StatusOK
+	if !dictionaryLoaded || !upstreamReachable {
+		h.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	jsonExecuter(w, status, h)
+}
+
+// recoverer turns a panic in a handler, e.g. from an FT API call failing, into a 500 rather than a dropped connection.
+func recoverer(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				// the request id, if any, was set on the response by log
+				logging.Default().Error("web-server: recoverer: panic", "requestId", w.Header().Get("X-Request-Id"), "path", r.URL.Path, "err", err, "stack", string(debug.Stack()))
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter remembers the status of a response, for the request log, and passes on flushes, for streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+var (
+	httpRequests = metrics.NewCounter("alignment_http_requests_total", "Requests served, by route and response status.", "route", "status")
+	httpLatency  = metrics.NewHistogram("alignment_http_request_duration_seconds", "How long requests took to serve, by route.", metrics.LatencyBuckets, "route")
+)
+
+// route is the pattern the request was served by, rather than its path, so the metrics have a series per handler.
+func route(r *http.Request) string {
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// metricsHandler is for Prometheus to scrape, so, like /healthz, is neither behind an auth policy nor logged.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	metrics.WriteText(w)
+}
+
+var requestIdRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// log gives each request an id (the caller's X-Request-Id, if sensible, or a new one), returned in X-Request-Id,
+// and a logger and timings carried by its context through the packages which serve it.
+// Once done, it logs the request, with how long it spent in each stage, e.g. search, fetch, parse and scan,
+// and counts it, and how long it took, in the metrics for its route.
 func log(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("REQUEST URL: ", r.URL)
-		fn(w, r)
+		requestId := r.Header.Get("X-Request-Id")
+		if !requestIdRegexp.MatchString(requestId) {
+			requestId = logging.NewRequestId()
+		}
+		w.Header().Set("X-Request-Id", requestId)
+
+		ctx, timings := logging.WithTimings(logging.WithRequestId(r.Context(), requestId))
+		sw := statusWriter{w, http.StatusOK}
+		start := time.Now()
+
+		defer func() {
+			err := recover()
+			status := sw.status
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
+
+			took := time.Since(start)
+			routeName := route(r)
+			httpRequests.With(routeName, strconv.Itoa(status)).Inc()
+			httpLatency.With(routeName).Observe(took.Seconds())
+
+			fields := []interface{}{"method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery, "status", status, "millis", took.Nanoseconds() / 1e6}
+			fields = append(fields, timings.Fields()...)
+			if status >= http.StatusInternalServerError {
+				logging.FromContext(ctx).Warn("web-server: request", fields...)
+			} else {
+				logging.FromContext(ctx).Info("web-server: request", fields...)
+			}
+
+			// on to recoverer
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		fn(&sw, r.WithContext(ctx))
 	}
 }
 
 func main() {
-	godotenv.Load()
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	var err error
+	cfg, err = config.Load("web-server", os.Args[1:])
+	if err != nil {
+		logging.Default().Error("web-server: main: invalid config", "err", err)
+		os.Exit(2)
+	}
+	cfg.Inject(os.Stdout)
+
+	syllabi = rhyme.ConstructSyllabi(cfg.DictionaryFiles())
+
+	// the staff pages, and the endpoints partner tools call, each route requiring its own scope
+	authProviders := cfg.AuthProviders(s3oauth.New())
+	staffAuth, err := auth.NewPolicy(cfg.AuthStaff, authProviders)
+	var apiAuth *auth.Policy
+	if err == nil {
+		apiAuth, err = auth.NewPolicy(cfg.AuthApi, authProviders)
+	}
+	if err != nil {
+		logging.Default().Error("web-server: main: invalid auth policy", "err", err)
+		os.Exit(2)
 	}
 
 	http.HandleFunc("/", log(alignFormHandler))
-	http.HandleFunc("/align", log(alignHandler))
+	// each client's quota per route, and the limit on scans at once, apply after auth, so clients with keys are told apart
+	quotas := quota.New(cfg.QuotaRules(), cfg.MaxScans, cfg.TrustForwardedFor)
+	// the feeds' responses are cached within the quotas, so a refresh in the background isn't charged to whoever found it stale
+	cache := httpcache.New(cfg.CacheTTLsByRoute(), cfg.CacheStale, cfg.CacheMaxEntries)
+
+	http.HandleFunc("/align", log(quotas.Limit("align", true, alignHandler)))
 	http.HandleFunc("/detail", log(detailHandler))
-	http.HandleFunc("/rss", log(rssHandler))
+	http.HandleFunc("/rss", log(negotiateFeed(cache.Handler("rss", rssHandler))))
 	http.HandleFunc("/carousel", log(carouselHandler))
-	http.Handle("/ontology", s3o.Handler(http.HandlerFunc(log(ontologyHandler))))
+	http.HandleFunc("/ontology", log(staffAuth.Require("ontology", quotas.Limit("ontology", true, ontologyHandler))))
+	http.HandleFunc("/ontology/events", log(staffAuth.Require("ontology", quotas.Limit("ontology", true, ontologyEventsHandler))))
 	http.HandleFunc("/meditation", log(meditationHandler))
-	http.HandleFunc("/pullquotes/rss", log(pullquotesRssHandler))
-	http.HandleFunc("/pullquotes/json", log(pullquotesJsonHandler))
-	http.HandleFunc("/firstft/rss", log(firstftRssHandler))
+	// the cached routes take a scan slot inside the cache, only when filling it, so hits and 304s aren't turned away as busy
+	http.HandleFunc("/pullquotes/rss", log(apiAuth.Require("pullquotes", quotas.Limit("pullquotes", false, negotiateFeed(cache.Handler("pullquotes", quotas.Scan("pullquotes", pullquotesRssHandler)))))))
+	http.HandleFunc("/pullquotes/json", log(apiAuth.Require("pullquotes", quotas.Limit("pullquotes", false, cache.Handler("pullquotes", quotas.Scan("pullquotes", pullquotesJsonHandler))))))
+	http.HandleFunc("/firstft/rss", log(apiAuth.Require("firstft", quotas.Limit("firstft", false, negotiateFeed(cache.Handler("firstft", quotas.Scan("firstft", firstftRssHandler)))))))
+	http.HandleFunc("/cards/", log(quotas.Limit("cards", false, cache.Handler("cards", quotas.Scan("cards", cardsHandler)))))
+	http.HandleFunc("/curation", log(staffAuth.Require("curation", curationHandler)))
+	http.HandleFunc("/curation/action", log(staffAuth.Require("curation", curationActionHandler)))
+	http.HandleFunc("/curation/haiku.json", log(curationHaikuJsonHandler))
+	http.HandleFunc("/ingest/status", log(ingestStatusHandler))
+	http.HandleFunc("/api/"+api.Version+"/detect", log(apiAuth.Require("detect", quotas.Limit("detect", true, apiDetectHandler))))
+	http.HandleFunc("/jobs", log(staffAuth.Require("jobs", jobsHandler)))
+	http.HandleFunc("/jobs/status", log(staffAuth.Require("jobs", jobsStatusHandler)))
+	http.HandleFunc("/jobs/cancel", log(staffAuth.Require("jobs", jobsCancelHandler)))
+	http.HandleFunc("/jobs/results", log(staffAuth.Require("jobs", jobsResultsHandler)))
+	http.HandleFunc("/config", log(staffAuth.Require("config", configHandler)))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 
     http.Handle("/javascript/", http.StripPrefix("/javascript/", http.FileServer(http.Dir("./public/javascript"))))
     http.Handle("/data/", http.StripPrefix("/data/", http.FileServer(http.Dir("./public/data"))))
 
 
-	http.ListenAndServe(":"+string(port), nil)
+	jobManager = jobs.NewManager(cfg.JobsDir, jobs.OntologyScan(syllabi), cfg.JobsWorkers)
+	jobManager.Start()
+
+	if cfg.IngestEnabled {
+		ingester = ingest.New(syllabi, cfg.IngestSource, cfg.IngestDir, cfg.IngestInterval, cfg.IngestMaxArticles, cfg.IngestBackfill)
+		ingester.Start()
+	}
+
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      recoverer(http.DefaultServeMux),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Default().Error("web-server: main: could not serve", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	logging.Default().Info("web-server: main: listening", "port", cfg.Port)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	logging.Default().Info("web-server: main: shutting down", "signal", <-signals)
+
+	// stop taking requests and let those in flight, e.g. ontology scans, finish
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Default().Warn("web-server: main: requests still in flight after SHUTDOWN_TIMEOUT", "err", err)
+	}
+
+	// running jobs are left queued, to be picked up again after a restart
+	jobManager.Stop()
+	if ingester != nil {
+		ingester.Stop()
+	}
+	logging.Default().Info("web-server: main: stopped")
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/railsagainstignorance/alignment/logging"
+)
+
+func TestRecoverer(t *testing.T) {
+	h := recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("no such uuid")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/align?text=x", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a 500 after a panic, got %d", w.Code)
+	}
+}
+
+func TestLogRequestId(t *testing.T) {
+	var seen string
+	h := log(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestId(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/align?text=x", nil)
+	r.Header.Set("X-Request-Id", "from-the-caller")
+	h(w, r)
+
+	if seen != "from-the-caller" || w.Header().Get("X-Request-Id") != "from-the-caller" {
+		t.Errorf("expected the caller's request id to be used and returned, got %q and %q", seen, w.Header().Get("X-Request-Id"))
+	}
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected the handler's status, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/align?text=x", nil)
+	r.Header.Set("X-Request-Id", "not a sensible id\n")
+	h(w, r)
+
+	if seen == "not a sensible id\n" || seen == "" || w.Header().Get("X-Request-Id") != seen {
+		t.Errorf("expected a new request id, got %q", seen)
+	}
+}